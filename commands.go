@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/unkmonster/tmd/internal/downloading"
+	"github.com/unkmonster/tmd/internal/gallery"
+	"github.com/unkmonster/tmd/internal/server"
+)
+
+// 子命令，形如 tmd <name> [flags]
+type subcommand struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var subcommands []*subcommand
+
+func init() {
+	subcommands = []*subcommand{
+		{name: "dedupe", usage: "replace media with identical content in the archive by links", run: runDedupe},
+		{name: "gallery", usage: "generate static html pages to browse the archive offline", run: runGallery},
+		{name: "serve", usage: "serve the archive over http with a read-only json api", run: runServe},
+		{name: "daemon", usage: "keep running and sync the targets in config on schedule", run: runDaemon},
+		{name: "run", usage: "run the job with the given name in jobs.yaml", run: runJob},
+	}
+
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(out, "\nSubcommands:")
+		for _, cmd := range subcommands {
+			fmt.Fprintf(out, "  %-10s %s\n", cmd.name, cmd.usage)
+		}
+	}
+}
+
+func findSubcommand(name string) *subcommand {
+	for _, cmd := range subcommands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// 读取已存在的配置，供不需要登录的子命令使用
+func loadConf() (*Config, error) {
+	conf, err := readConf(filepath.Join(getAppRootPath(), "conf.yaml"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("config does not exist, run 'tmd --conf' first")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %v", err)
+	}
+	return conf, nil
+}
+
+func runDedupe(args []string) error {
+	flags := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	modeArg := flags.String("mode", "", "hardlink or symlink (default: dedupe in config, or hardlink)")
+	flags.Parse(args)
+
+	conf, err := loadConf()
+	if err != nil {
+		return err
+	}
+	if *modeArg == "" {
+		*modeArg = conf.Dedupe
+	}
+	mode, err := downloading.ParseDedupeMode(*modeArg)
+	if err != nil {
+		return err
+	}
+	if mode == downloading.DM_NONE {
+		mode = downloading.DM_HARDLINK
+	}
+
+	pathHelper, err := newStorePath(conf.RootPath)
+	if err != nil {
+		return err
+	}
+	db, err := connectDatabase(pathHelper.db)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	roots, err := archiveRoots(conf)
+	if err != nil {
+		return err
+	}
+	total := downloading.DedupeStats{}
+	for _, root := range roots {
+		stats, err := downloading.DedupeDir(db, root, mode)
+		if err != nil {
+			return err
+		}
+		total.Files += stats.Files
+		total.Linked += stats.Linked
+		total.Reclaimed += stats.Reclaimed
+	}
+	fmt.Printf("checked %d files, replaced %d duplicates, reclaimed %.2f MiB\n", total.Files, total.Linked, float64(total.Reclaimed)/(1<<20))
+	return nil
+}
+
+func runGallery(args []string) error {
+	flags := flag.NewFlagSet("gallery", flag.ExitOnError)
+	outArg := flags.String("out", "", "output directory (default: <root_path>/gallery)")
+	flags.Parse(args)
+
+	conf, err := loadConf()
+	if err != nil {
+		return err
+	}
+	pathHelper, err := newStorePath(conf.RootPath)
+	if err != nil {
+		return err
+	}
+	if *outArg == "" {
+		*outArg = filepath.Join(pathHelper.root, "gallery")
+	}
+	db, err := connectDatabase(pathHelper.db)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stats, err := gallery.Generate(db, pathHelper.root, *outArg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("generated pages for %d users, %d lists, %d media: %s\n", stats.Users, stats.Lists, stats.Media, filepath.Join(*outArg, "index.html"))
+	return nil
+}
+
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addrArg := flags.String("addr", "127.0.0.1:8080", "address to listen on")
+	flags.Parse(args)
+
+	conf, err := loadConf()
+	if err != nil {
+		return err
+	}
+	pathHelper, err := newStorePath(conf.RootPath)
+	if err != nil {
+		return err
+	}
+	db, err := connectDatabase(pathHelper.db)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	roots, err := archiveRoots(conf)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("serving %s on http://%s\n", strings.Join(roots, ", "), *addrArg)
+	return http.ListenAndServe(*addrArg, server.New(db, pathHelper.root, roots[1:]...))
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"github.com/unkmonster/tmd/internal/downloading"
+	"github.com/unkmonster/tmd/internal/twitter"
+	"github.com/unkmonster/tmd/internal/utils"
+)
+
+// 常驻运行时定期同步的目标，user, list, foll 三者之一。其余选项为空时使用命令行的默认值
+type DaemonTarget struct {
+	User       string   `yaml:"user,omitempty"` // user_id 或 screen_name
+	List       uint64   `yaml:"list,omitempty"`
+	Foll       string   `yaml:"foll,omitempty"`  // user_id 或 screen_name
+	Every      string   `yaml:"every"`           // 同步间隔，如 30m, 6h
+	Media      []string `yaml:"media,omitempty"` // photo, video, gif, space，为空下载全部类型
+	AutoFollow *bool    `yaml:"auto_follow,omitempty"`
+	Since      string   `yaml:"since,omitempty"` // 相对时长在每次同步时重新计算
+	Until      string   `yaml:"until,omitempty"`
+	Retweets   string   `yaml:"retweets,omitempty"`
+	Quotes     string   `yaml:"quotes,omitempty"`
+}
+
+type DaemonConfig struct {
+	Jitter  string          `yaml:"jitter,omitempty"` // 每次同步随机推迟不超过此时长
+	Targets []*DaemonTarget `yaml:"targets"`
+}
+
+func (t *DaemonTarget) String() string {
+	switch {
+	case t.User != "":
+		return "user " + t.User
+	case t.List != 0:
+		return "list " + strconv.FormatUint(t.List, 10)
+	}
+	return "foll " + t.Foll
+}
+
+// 获取目标对应的用户或列表
+func (t *DaemonTarget) resolve(ctx context.Context, client *resty.Client) (*twitter.User, twitter.ListBase, error) {
+	getUser := func(str string) (*twitter.User, error) {
+		if id, err := strconv.ParseUint(str, 10, 64); err == nil {
+			return twitter.GetUserById(ctx, client, id)
+		}
+		return twitter.GetUserByScreenName(ctx, client, strings.TrimPrefix(str, "@"))
+	}
+
+	switch {
+	case t.User != "":
+		user, err := getUser(t.User)
+		return user, nil, err
+	case t.List != 0:
+		list, err := twitter.GetLst(ctx, client, t.List)
+		return nil, list, err
+	}
+	user, err := getUser(t.Foll)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, user.Following(), nil
+}
+
+type scheduledTarget struct {
+	*DaemonTarget
+	every    time.Duration
+	next     time.Time
+	media    mediaArgs
+	retweets downloading.RepostPolicy
+	quotes   downloading.RepostPolicy
+}
+
+// 解析目标的下载选项
+func (t *scheduledTarget) parseOptions() error {
+	if len(t.Media) != 0 {
+		if err := t.media.Set(strings.Join(t.Media, ",")); err != nil {
+			return err
+		}
+	}
+	for _, str := range []string{t.Since, t.Until} {
+		if str == "" {
+			continue
+		}
+		if _, err := utils.ParseTimeArg(str, time.Now()); err != nil {
+			return err
+		}
+	}
+	var err error
+	if t.Retweets != "" {
+		if t.retweets, err = downloading.ParseRepostPolicy(t.Retweets); err != nil {
+			return err
+		}
+	}
+	if t.Quotes != "" {
+		if t.quotes, err = downloading.ParseRepostPolicy(t.Quotes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 目标在 now 同步时的下载选项，未设置的选项取自 defaults
+func (t *scheduledTarget) options(defaults *downloading.BatchOptions, now time.Time) downloading.BatchOptions {
+	opts := *defaults
+	if t.AutoFollow != nil {
+		opts.AutoFollow = *t.AutoFollow
+	}
+	if len(t.media.types) != 0 {
+		opts.Media.Types = t.media.types
+	}
+	if t.Since != "" {
+		opts.Since, _ = utils.ParseTimeArg(t.Since, now)
+	}
+	if t.Until != "" {
+		opts.Until, _ = utils.ParseTimeArg(t.Until, now)
+	}
+	if t.Retweets != "" {
+		opts.Retweets = t.retweets
+	}
+	if t.Quotes != "" {
+		opts.Quotes = t.quotes
+	}
+	return opts
+}
+
+func parseDaemonConfig(conf *DaemonConfig) ([]*scheduledTarget, time.Duration, error) {
+	if conf == nil || len(conf.Targets) == 0 {
+		return nil, 0, fmt.Errorf("no daemon targets in config")
+	}
+	var jitter time.Duration
+	var err error
+	if conf.Jitter != "" {
+		jitter, err = time.ParseDuration(conf.Jitter)
+		if err != nil || jitter < 0 {
+			return nil, 0, fmt.Errorf("invalid daemon jitter: %s", conf.Jitter)
+		}
+	}
+
+	targets := []*scheduledTarget{}
+	for _, t := range conf.Targets {
+		n := 0
+		for _, set := range []bool{t.User != "", t.List != 0, t.Foll != ""} {
+			if set {
+				n++
+			}
+		}
+		if n != 1 {
+			return nil, 0, fmt.Errorf("daemon target must have exactly one of user, list and foll: %+v", *t)
+		}
+		every, err := time.ParseDuration(t.Every)
+		if err != nil || every < time.Minute {
+			return nil, 0, fmt.Errorf("invalid interval of %s: '%s', at least 1m", t, t.Every)
+		}
+		target := &scheduledTarget{DaemonTarget: t, every: every}
+		if err := target.parseOptions(); err != nil {
+			return nil, 0, fmt.Errorf("invalid options of %s: %v", t, err)
+		}
+		targets = append(targets, target)
+	}
+	return targets, jitter, nil
+}
+
+type daemon struct {
+	client     *resty.Client
+	additional []*resty.Client
+	db         *sqlx.DB
+	dumper     *downloading.TweetDumper
+	pathHelper *storePath
+	opts       downloading.BatchOptions // 目标未设置的选项的默认值
+	retryMedia downloading.MediaOptions // 重试失败的推文时下载的媒体类型，为各目标的并集
+	noRetry    bool
+}
+
+// 选项相同的一组目标，一次批量下载
+type targetGroup struct {
+	opts    downloading.BatchOptions
+	targets []*scheduledTarget
+}
+
+// 按目标在 now 同步时的下载选项分组，未设置的选项取自 defaults
+func groupTargets(targets []*scheduledTarget, defaults *downloading.BatchOptions, now time.Time) []*targetGroup {
+	groups := []*targetGroup{}
+	for _, t := range targets {
+		opts := t.options(defaults, now)
+		var group *targetGroup
+		for _, g := range groups {
+			if reflect.DeepEqual(g.opts, opts) {
+				group = g
+				break
+			}
+		}
+		if group == nil {
+			group = &targetGroup{opts: opts}
+			groups = append(groups, group)
+		}
+		group.targets = append(group.targets, t)
+	}
+	return groups
+}
+
+// 同步一轮到期的目标，选项相同的目标一同下载，结束后重试并转储失败的推文
+func (d *daemon) sync(ctx context.Context, targets []*scheduledTarget) {
+	downloading.ResetSyncState()
+
+	for _, g := range groupTargets(targets, &d.opts, time.Now()) {
+		if ctx.Err() != nil {
+			break
+		}
+		users := []*twitter.User{}
+		lists := []twitter.ListBase{}
+		for _, t := range g.targets {
+			user, list, err := t.resolve(ctx, d.client)
+			if err != nil {
+				log.WithField("target", t.String()).Warnln("failed to resolve target:", err)
+				continue
+			}
+			if user != nil {
+				users = append(users, user)
+			} else {
+				lists = append(lists, list)
+			}
+		}
+		if len(users) == 0 && len(lists) == 0 {
+			continue
+		}
+		todump, err := downloading.BatchDownloadAny(ctx, d.client, d.db, lists, users, d.pathHelper.root, d.pathHelper.users, &g.opts, d.additional)
+		if err != nil {
+			log.Errorln("failed to download:", err)
+		}
+		for _, te := range todump {
+			d.dumper.Push(te.Entity.Id(), te.Tweet)
+		}
+	}
+	if ctx.Err() == nil && !d.noRetry {
+		if err := retryFailedTweets(ctx, d.dumper, d.db, d.client, &d.retryMedia); err != nil {
+			log.Warnln("failed to retry failed tweets:", err)
+		}
+	}
+	if err := d.dumper.Dump(d.pathHelper.errorj); err != nil {
+		log.Errorln("failed to dump failed tweets:", err)
+	}
+	log.Infof("sync is done, %d tweets are pending", d.dumper.Count())
+	reportParseErrors(d.pathHelper.badPayloads)
+	twitter.ResetParseErrors()
+	reportRefetchStats()
+	twitter.ResetRefetchStats()
+}
+
+// 按各目标的间隔循环同步，直至 ctx 被取消
+func (d *daemon) run(ctx context.Context, targets []*scheduledTarget, jitter time.Duration) {
+	delay := func() time.Duration {
+		if jitter <= 0 {
+			return 0
+		}
+		return time.Duration(rand.Int63n(int64(jitter)))
+	}
+	now := time.Now()
+	for _, t := range targets {
+		t.next = now.Add(delay())
+	}
+
+	for {
+		next := targets[0].next
+		for _, t := range targets {
+			if t.next.Before(next) {
+				next = t.next
+			}
+		}
+		log.Infoln("next sync at", next.Local().Format(time.DateTime))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		due := []*scheduledTarget{}
+		for _, t := range targets {
+			if !t.next.After(time.Now()) {
+				due = append(due, t)
+			}
+		}
+		log.Infoln("start syncing", len(due), "targets")
+		d.sync(ctx, due)
+		if ctx.Err() != nil {
+			return
+		}
+		for _, t := range due {
+			t.next = time.Now().Add(t.every + delay())
+		}
+	}
+}
+
+// 各目标下载的媒体类型的并集，有目标下载全部类型时返回 nil
+func unionMedia(targets []*scheduledTarget) []twitter.MediaType {
+	types := []twitter.MediaType{}
+	for _, t := range targets {
+		if len(t.media.types) == 0 {
+			return nil
+		}
+		for _, typ := range t.media.types {
+			if !slices.Contains(types, typ) {
+				types = append(types, typ)
+			}
+		}
+	}
+	return types
+}
+
+func runDaemon(args []string) error {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	dbg := flags.Bool("dbg", false, "display debug message")
+	autoFollow := flags.Bool("auto-follow", false, "send follow request automatically to protected users")
+	noRetry := flags.Bool("no-retry", false, "do not retry failed tweets after each sync")
+	flags.Parse(args)
+
+	conf, err := loadConf()
+	if err != nil {
+		return err
+	}
+	targets, jitter, err := parseDaemonConfig(conf.Daemon)
+	if err != nil {
+		return err
+	}
+
+	appRootPath := getAppRootPath()
+	logFile, err := os.OpenFile(filepath.Join(appRootPath, "daemon.log"), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	initLogger(*dbg, logFile)
+	if err := applyConf(conf); err != nil {
+		return err
+	}
+	pathHelper, err := newStorePath(conf.RootPath)
+	if err != nil {
+		return err
+	}
+	twitter.BadPayloadDir = pathHelper.badPayloads
+
+	// 收到信号后结束当前一轮同步，转储失败的推文后退出
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+
+	cliLogFile, err := os.OpenFile(filepath.Join(appRootPath, "daemon_client.log"), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer cliLogFile.Close()
+	client, additional, err := signIn(ctx, conf, filepath.Join(appRootPath, "additional_cookies.yaml"), *dbg, cliLogFile)
+	if err != nil {
+		return fmt.Errorf("failed to login: %v", err)
+	}
+
+	db, err := connectDatabase(pathHelper.db)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	dumper := downloading.NewDumper()
+	if err := dumper.Load(pathHelper.errorj); err != nil {
+		return err
+	}
+
+	d := daemon{
+		client:     client,
+		additional: additional,
+		db:         db,
+		dumper:     dumper,
+		pathHelper: pathHelper,
+		opts:       downloading.BatchOptions{AutoFollow: *autoFollow},
+		retryMedia: downloading.MediaOptions{Types: unionMedia(targets)},
+		noRetry:    *noRetry,
+	}
+	log.Infoln("daemon started with", len(targets), "targets")
+	d.run(ctx, targets, jitter)
+	log.Infof("daemon stopped, %d tweets are pending", dumper.Count())
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/unkmonster/tmd/internal/downloading"
+	"github.com/unkmonster/tmd/internal/twitter"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseDaemonConfig(t *testing.T) {
+	data := `
+jitter: 5m
+targets:
+  - user: alice
+    every: 30m
+  - list: 1234
+    every: 6h
+    media: [photo, gif]
+    auto_follow: false
+    since: 30d
+    retweets: author
+    quotes: quoter
+  - foll: bob
+    every: 1m
+`
+	conf := &DaemonConfig{}
+	if err := yaml.Unmarshal([]byte(data), conf); err != nil {
+		t.Fatal(err)
+	}
+	targets, jitter, err := parseDaemonConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jitter != 5*time.Minute || len(targets) != 3 {
+		t.Fatalf("jitter %v, %d targets", jitter, len(targets))
+	}
+
+	everies := []time.Duration{}
+	names := []string{}
+	for _, target := range targets {
+		everies = append(everies, target.every)
+		names = append(names, target.String())
+	}
+	if !reflect.DeepEqual(everies, []time.Duration{30 * time.Minute, 6 * time.Hour, time.Minute}) {
+		t.Errorf("intervals: %v", everies)
+	}
+	if !reflect.DeepEqual(names, []string{"user alice", "list 1234", "foll bob"}) {
+		t.Errorf("targets: %v", names)
+	}
+	list := targets[1]
+	if !reflect.DeepEqual(list.media.types, []twitter.MediaType{twitter.MT_PHOTO, twitter.MT_GIF}) ||
+		list.AutoFollow == nil || *list.AutoFollow ||
+		list.retweets != downloading.RP_AUTHOR || list.quotes != downloading.RP_SELF {
+		t.Errorf("options of list: %+v", list)
+	}
+}
+
+func TestParseDaemonConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		conf *DaemonConfig
+	}{
+		{"nil", nil},
+		{"no targets", &DaemonConfig{}},
+		{"jitter", &DaemonConfig{Jitter: "soon", Targets: []*DaemonTarget{{User: "alice", Every: "1h"}}}},
+		{"negative jitter", &DaemonConfig{Jitter: "-1m", Targets: []*DaemonTarget{{User: "alice", Every: "1h"}}}},
+		{"no kind", &DaemonConfig{Targets: []*DaemonTarget{{Every: "1h"}}}},
+		{"two kinds", &DaemonConfig{Targets: []*DaemonTarget{{User: "alice", List: 1, Every: "1h"}}}},
+		{"no interval", &DaemonConfig{Targets: []*DaemonTarget{{User: "alice"}}}},
+		{"interval", &DaemonConfig{Targets: []*DaemonTarget{{User: "alice", Every: "daily"}}}},
+		{"short interval", &DaemonConfig{Targets: []*DaemonTarget{{User: "alice", Every: "30s"}}}},
+		{"media", &DaemonConfig{Targets: []*DaemonTarget{{User: "alice", Every: "1h", Media: []string{"audio"}}}}},
+		{"since", &DaemonConfig{Targets: []*DaemonTarget{{User: "alice", Every: "1h", Since: "yesterday"}}}},
+		{"retweets", &DaemonConfig{Targets: []*DaemonTarget{{User: "alice", Every: "1h", Retweets: "everyone"}}}},
+		{"quotes", &DaemonConfig{Targets: []*DaemonTarget{{User: "alice", Every: "1h", Quotes: "nobody"}}}},
+	}
+	for _, test := range tests {
+		if _, _, err := parseDaemonConfig(test.conf); err == nil {
+			t.Errorf("%s: config is valid", test.name)
+		}
+	}
+}
+
+func TestDaemonTargetOptions(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	defaults := &downloading.BatchOptions{AutoFollow: true}
+	no := false
+
+	tests := []struct {
+		name   string
+		target DaemonTarget
+		want   downloading.BatchOptions
+	}{
+		{"defaults", DaemonTarget{}, downloading.BatchOptions{AutoFollow: true}},
+		{"auto follow", DaemonTarget{AutoFollow: &no}, downloading.BatchOptions{}},
+		{"media", DaemonTarget{Media: []string{"video"}}, downloading.BatchOptions{AutoFollow: true, Media: downloading.MediaOptions{Types: []twitter.MediaType{twitter.MT_VIDEO}}}},
+		// 相对时长相对每次同步的时间
+		{"window", DaemonTarget{Since: "30d", Until: "2024-06-01"}, downloading.BatchOptions{AutoFollow: true, Since: now.AddDate(0, 0, -30), Until: time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)}},
+		{"reposts", DaemonTarget{Retweets: "skip", Quotes: "author"}, downloading.BatchOptions{AutoFollow: true, Retweets: downloading.RP_SKIP, Quotes: downloading.RP_AUTHOR}},
+	}
+	for _, test := range tests {
+		target := &scheduledTarget{DaemonTarget: &test.target}
+		if err := target.parseOptions(); err != nil {
+			t.Fatal(err)
+		}
+		got := target.options(defaults, now)
+		if !got.Since.Equal(test.want.Since) || !got.Until.Equal(test.want.Until) {
+			t.Errorf("%s: since %v, until %v, want %v, %v", test.name, got.Since, got.Until, test.want.Since, test.want.Until)
+		}
+		got.Since, got.Until = test.want.Since, test.want.Until
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: options %+v, want %+v", test.name, got, test.want)
+		}
+	}
+	if !defaults.AutoFollow || len(defaults.Media.Types) != 0 {
+		t.Errorf("defaults are modified: %+v", defaults)
+	}
+}
+
+func TestGroupTargets(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	no := false
+	targets := []*DaemonTarget{
+		{User: "a"},
+		{User: "b", Media: []string{"photo"}},
+		{List: 1},
+		{User: "c", Media: []string{"photo"}},
+		{User: "d", AutoFollow: &no},
+		{Foll: "e", Retweets: "author"},
+		{User: "f", Since: "1d"},
+		{User: "g", Since: "24h"},
+		// 与默认值相同的显式选项
+		{User: "h", Retweets: "retweeter"},
+	}
+	scheduled := []*scheduledTarget{}
+	for _, target := range targets {
+		st := &scheduledTarget{DaemonTarget: target}
+		if err := st.parseOptions(); err != nil {
+			t.Fatal(err)
+		}
+		scheduled = append(scheduled, st)
+	}
+
+	groups := groupTargets(scheduled, &downloading.BatchOptions{AutoFollow: true, Retweets: downloading.RP_SELF}, now)
+	got := [][]string{}
+	for _, g := range groups {
+		names := []string{}
+		for _, target := range g.targets {
+			names = append(names, target.String())
+		}
+		got = append(got, names)
+	}
+	want := [][]string{
+		{"user a", "list 1", "user h"},
+		{"user b", "user c"},
+		{"user d"},
+		{"foll e"},
+		{"user f", "user g"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("groups = %v, want %v", got, want)
+	}
+}
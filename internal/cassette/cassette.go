@@ -0,0 +1,249 @@
+// 录制和回放 HTTP 请求的磁带：录制模式下将请求和响应写入目录，回放模式下从目录中读取响应，不访问网络
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type Mode int
+
+const (
+	Replay Mode = iota
+	Record
+)
+
+// 解析模式 record, replay，为空时录制
+func ParseMode(str string) (Mode, error) {
+	switch strings.ToLower(strings.TrimSpace(str)) {
+	case "", "record":
+		return Record, nil
+	case "replay":
+		return Replay, nil
+	}
+	return Replay, fmt.Errorf("invalid cassette mode: %s", str)
+}
+
+type Request struct {
+	Method string      `json:"method"`
+	Url    string      `json:"url"`
+	Header http.Header `json:"header"`
+}
+
+type Response struct {
+	Status  int             `json:"status"`
+	Header  http.Header     `json:"header"`
+	Body    json.RawMessage `json:"body,omitempty"`     // JSON 响应体
+	RawBody []byte          `json:"raw_body,omitempty"` // 其他响应体
+}
+
+// 一次请求和其响应
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// 响应体，JSON 响应体被压缩为一行
+func (i *Interaction) Body() []byte {
+	if len(i.Response.Body) != 0 {
+		buf := bytes.Buffer{}
+		if json.Compact(&buf, i.Response.Body) == nil {
+			return buf.Bytes()
+		}
+		return i.Response.Body
+	}
+	return i.Response.RawBody
+}
+
+func Load(path string) (*Interaction, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	interaction := Interaction{}
+	if err := json.Unmarshal(data, &interaction); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %v", path, err)
+	}
+	return &interaction, nil
+}
+
+type Cassette struct {
+	Dir  string
+	Mode Mode
+
+	mtx    sync.Mutex
+	counts map[string]int
+}
+
+func New(dir string, mode Mode) (*Cassette, error) {
+	if mode == Record {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	return &Cassette{Dir: dir, Mode: mode, counts: make(map[string]int)}, nil
+}
+
+// 包装 next，使经过它的请求被录制或回放
+func (c *Cassette) Wrap(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{cassette: c, next: next}
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// 请求的文件名，由端点名和忽略主机的请求确定，n 为相同请求中的序号
+func (c *Cassette) fileName(req *http.Request, n int) string {
+	key := req.Method + " " + req.URL.Path + "?" + req.URL.Query().Encode()
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	op := unsafeChars.ReplaceAllString(path.Base(req.URL.Path), "_")
+	return filepath.Join(c.Dir, fmt.Sprintf("%s-%016x-%d.json", op, h.Sum64(), n))
+}
+
+// 相同请求的序号
+func (c *Cassette) next(req *http.Request) int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	key := c.fileName(req, 0)
+	n := c.counts[key]
+	c.counts[key]++
+	return n
+}
+
+type transport struct {
+	cassette *Cassette
+	next     http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	n := t.cassette.next(req)
+	if t.cassette.Mode == Replay {
+		return t.cassette.replay(req, n)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := t.cassette.record(req, resp, body, n); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// 重放第 n 个相同的请求，超出录制的次数时重放最后一个
+func (c *Cassette) replay(req *http.Request, n int) (*http.Response, error) {
+	for ; n >= 0; n-- {
+		interaction, err := Load(c.fileName(req, n))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		body := interaction.Body()
+		header := interaction.Response.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.Status, http.StatusText(interaction.Response.Status)),
+			StatusCode:    interaction.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("cassette: no recorded response for %s %s", req.Method, req.URL.Path)
+}
+
+const redacted = "REDACTED"
+
+// 请求中的凭据：cookie、csrf token 和 authorization
+func secretsOf(req *http.Request) []string {
+	secrets := []string{}
+	for _, cookie := range req.Cookies() {
+		secrets = append(secrets, cookie.Value)
+	}
+	secrets = append(secrets, req.Header.Get("X-Csrf-Token"))
+	secrets = append(secrets, strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+
+	// 过短的值可能误伤正常内容
+	result := []string{}
+	for _, s := range secrets {
+		if len(s) >= 8 {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func redact(str string, secrets []string) string {
+	for _, s := range secrets {
+		str = strings.ReplaceAll(str, s, redacted)
+		if escaped := url.QueryEscape(s); escaped != s {
+			str = strings.ReplaceAll(str, escaped, redacted)
+		}
+	}
+	return str
+}
+
+func (c *Cassette) record(req *http.Request, resp *http.Response, body []byte, n int) error {
+	secrets := secretsOf(req)
+
+	reqHeader := req.Header.Clone()
+	for _, name := range []string{"Cookie", "Authorization", "X-Csrf-Token"} {
+		if reqHeader.Get(name) != "" {
+			reqHeader.Set(name, redacted)
+		}
+	}
+	respHeader := resp.Header.Clone()
+	for _, name := range []string{"Set-Cookie", "Content-Length", "Content-Encoding"} {
+		respHeader.Del(name)
+	}
+
+	interaction := Interaction{
+		Request:  Request{Method: req.Method, Url: redact(req.URL.String(), secrets), Header: reqHeader},
+		Response: Response{Status: resp.StatusCode, Header: respHeader},
+	}
+	body = []byte(redact(string(body), secrets))
+	if len(body) != 0 && json.Valid(body) {
+		interaction.Response.Body = body
+	} else {
+		interaction.Response.RawBody = body
+	}
+
+	data, err := json.MarshalIndent(&interaction, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.fileName(req, n), data, 0644)
+}
@@ -0,0 +1,98 @@
+package cassette
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	authToken = "0123456789abcdef0123456789abcdef"
+	csrfToken = "fedcba9876543210fedcba9876543210"
+)
+
+func get(t *testing.T, client *http.Client, u string) (int, string) {
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	req.AddCookie(&http.Cookie{Name: "auth_token", Value: authToken})
+	req.Header.Set("X-Csrf-Token", csrfToken)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.SetCookie(w, &http.Cookie{Name: "ct0", Value: csrfToken})
+		if strings.HasSuffix(r.URL.Path, ".jpg") {
+			w.Write([]byte{0xff, 0xd8, 0xff})
+			return
+		}
+		w.Header().Set("X-Rate-Limit-Remaining", "10")
+		if r.URL.Query().Get("page") == "2" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte(`{"calls":` + string(rune('0'+calls)) + `,"echo":"` + r.Header.Get("X-Csrf-Token") + `"}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	c, err := New(dir, Record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: c.Wrap(nil)}
+	recorded := []string{}
+	for _, u := range []string{"/api/Op?page=1", "/api/Op?page=1", "/api/Op?page=2", "/media/a.jpg"} {
+		_, body := get(t, client, server.URL+u)
+		recorded = append(recorded, body)
+	}
+
+	// 凭据不出现在磁带中
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 4 {
+		t.Fatalf("files = %v", files)
+	}
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		if strings.Contains(string(data), authToken) || strings.Contains(string(data), csrfToken) {
+			t.Errorf("%s contains credentials: %s", file, data)
+		}
+	}
+
+	server.Close()
+	c, err = New(dir, Replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: c.Wrap(nil)}
+	// 相同的请求按顺序回放，主机不影响匹配
+	for i, u := range []string{"/api/Op?page=1", "/api/Op?page=1", "/api/Op?page=2", "/media/a.jpg"} {
+		code, body := get(t, client, "http://replay.invalid"+u)
+		want := strings.ReplaceAll(recorded[i], csrfToken, redacted)
+		if body != want {
+			t.Errorf("%s: body = %q, want %q", u, body, want)
+		}
+		if u == "/api/Op?page=2" && code != http.StatusNotFound {
+			t.Errorf("%s: status = %d", u, code)
+		}
+	}
+	// 超出录制次数时重放最后一个
+	if _, body := get(t, client, "http://replay.invalid/api/Op?page=1"); !strings.Contains(body, `"calls":2`) {
+		t.Errorf("extra replay = %q", body)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://replay.invalid/api/Missing", nil)
+	if _, err := client.Do(req); err == nil {
+		t.Error("replayed missing request")
+	}
+}
@@ -3,7 +3,9 @@ package database
 import (
 	"database/sql"
 	"path/filepath"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
@@ -70,6 +72,59 @@ CREATE TABLE IF NOT EXISTS user_links (
 );
 
 CREATE INDEX IF NOT EXISTS idx_user_links_user_id ON user_links (user_id);
+
+CREATE TABLE IF NOT EXISTS like_marks (
+	user_id INTEGER NOT NULL,
+	tweet_id INTEGER NOT NULL,
+	PRIMARY KEY (user_id, tweet_id),
+	FOREIGN KEY(user_id) REFERENCES users (id)
+);
+
+CREATE TABLE IF NOT EXISTS bookmarks (
+	user_id INTEGER NOT NULL,
+	tweet_id INTEGER NOT NULL,
+	record_date DATE NOT NULL,
+	PRIMARY KEY (user_id, tweet_id),
+	FOREIGN KEY(user_id) REFERENCES users (id)
+);
+
+CREATE TABLE IF NOT EXISTS tweets (
+	id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	text VARCHAR NOT NULL,
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS tweet_media (
+	id INTEGER NOT NULL,
+	tweet_id INTEGER NOT NULL,
+	entity_id INTEGER NOT NULL,
+	url VARCHAR NOT NULL,
+	path VARCHAR,
+	size INTEGER,
+	status INTEGER NOT NULL,
+	downloaded_at DATETIME,
+	PRIMARY KEY (id),
+	UNIQUE (tweet_id, entity_id, url),
+	FOREIGN KEY(tweet_id) REFERENCES tweets (id),
+	FOREIGN KEY(entity_id) REFERENCES user_entities (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tweet_media_entity_id ON tweet_media (entity_id);
+
+CREATE TABLE IF NOT EXISTS media_blobs (
+	hash VARCHAR NOT NULL,
+	path VARCHAR NOT NULL,
+	size INTEGER NOT NULL,
+	PRIMARY KEY (hash)
+);
+
+CREATE TABLE IF NOT EXISTS deduped_files (
+	path VARCHAR NOT NULL,
+	mod_time INTEGER NOT NULL,
+	PRIMARY KEY (path)
+);
 `
 
 func CreateTables(db *sqlx.DB) {
@@ -294,6 +349,32 @@ func RecordUserPreviousName(db *sqlx.DB, uid uint64, name string, screenName str
 	return err
 }
 
+func GetUserPreviousNames(db *sqlx.DB, uid uint64) ([]*UserPreviousName, error) {
+	stmt := `SELECT * FROM user_previous_names WHERE uid = ? ORDER BY record_date DESC, id DESC`
+	res := []*UserPreviousName{}
+	err := db.Select(&res, stmt, uid)
+	return res, err
+}
+
+// 按 screen_name 分页获取用户，返回用户总数
+func GetUsers(db *sqlx.DB, offset int, limit int) ([]*User, int, error) {
+	var total int
+	if err := db.Get(&total, `SELECT COUNT(*) FROM users`); err != nil {
+		return nil, 0, err
+	}
+	stmt := `SELECT * FROM users ORDER BY screen_name LIMIT ? OFFSET ?`
+	res := []*User{}
+	err := db.Select(&res, stmt, limit, offset)
+	return res, total, err
+}
+
+func GetUserEntitiesByUid(db *sqlx.DB, uid uint64) ([]*UserEntity, error) {
+	stmt := `SELECT * FROM user_entities WHERE user_id = ?`
+	res := []*UserEntity{}
+	err := db.Select(&res, stmt, uid)
+	return res, err
+}
+
 func CreateUserLink(db *sqlx.DB, lnk *UserLink) error {
 	stmt := `INSERT INTO user_links(user_id, name, parent_lst_entity_id) VALUES(:user_id, :name, :parent_lst_entity_id)`
 	res, err := db.NamedExec(stmt, lnk)
@@ -316,6 +397,28 @@ func DelUserLink(db *sqlx.DB, id int32) error {
 	return err
 }
 
+func GetUserEntities(db *sqlx.DB) ([]*UserEntity, error) {
+	stmt := `SELECT * FROM user_entities ORDER BY name`
+	res := []*UserEntity{}
+	err := db.Select(&res, stmt)
+	return res, err
+}
+
+func GetLstEntities(db *sqlx.DB) ([]*LstEntity, error) {
+	stmt := `SELECT * FROM lst_entities ORDER BY name`
+	res := []*LstEntity{}
+	err := db.Select(&res, stmt)
+	return res, err
+}
+
+// 获取列表实体下的所有用户链接
+func GetLstEntityUserLinks(db *sqlx.DB, leid int32) ([]*UserLink, error) {
+	stmt := `SELECT * FROM user_links WHERE parent_lst_entity_id = ? ORDER BY name`
+	res := []*UserLink{}
+	err := db.Select(&res, stmt, leid)
+	return res, err
+}
+
 func GetUserLinks(db *sqlx.DB, uid uint64) ([]*UserLink, error) {
 	stmt := `SELECT * FROM user_links WHERE user_id = ?`
 	res := []*UserLink{}
@@ -342,3 +445,228 @@ func UpdateUserLink(db *sqlx.DB, id int32, name string) error {
 	_, err := db.Exec(stmt, name, id)
 	return err
 }
+
+// 获取用户上次下载到的最新的若干喜欢的推文，从未下载过返回空
+func GetLikeMarks(db *sqlx.DB, uid uint64) ([]uint64, error) {
+	stmt := `SELECT tweet_id FROM like_marks WHERE user_id = ?`
+	ids := []uint64{}
+	err := db.Select(&ids, stmt, uid)
+	return ids, err
+}
+
+// 用 tweetIds 替换用户已记录的喜欢的推文
+func SetLikeMarks(db *sqlx.DB, uid uint64, tweetIds []uint64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM like_marks WHERE user_id = ?`, uid); err != nil {
+		return err
+	}
+	for _, id := range tweetIds {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO like_marks(user_id, tweet_id) VALUES(?, ?)`, uid, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func HasBookmark(db *sqlx.DB, uid uint64, tweetId uint64) (bool, error) {
+	stmt := `SELECT COUNT(*) FROM bookmarks WHERE user_id = ? AND tweet_id = ?`
+	var count int
+	err := db.Get(&count, stmt, uid, tweetId)
+	return count != 0, err
+}
+
+func CreateBookmark(db *sqlx.DB, uid uint64, tweetId uint64) error {
+	stmt := `INSERT OR IGNORE INTO bookmarks(user_id, tweet_id, record_date) VALUES(?, ?, ?)`
+	_, err := db.Exec(stmt, uid, tweetId, time.Now())
+	return err
+}
+
+func RecordTweet(db *sqlx.DB, tweet *Tweet) error {
+	stmt := `INSERT INTO tweets(id, user_id, text, created_at) VALUES(:id, :user_id, :text, :created_at)
+	ON CONFLICT(id) DO UPDATE SET user_id=excluded.user_id, text=excluded.text, created_at=excluded.created_at`
+	_, err := db.NamedExec(stmt, tweet)
+	return err
+}
+
+func GetTweet(db *sqlx.DB, id uint64) (*Tweet, error) {
+	stmt := `SELECT * FROM tweets WHERE id = ?`
+	result := &Tweet{}
+	err := db.Get(result, stmt, id)
+	if err == sql.ErrNoRows {
+		err = nil
+		result = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 记录媒体在实体下的下载情况，已存在的记录会被覆盖
+func RecordTweetMedia(db *sqlx.DB, media *TweetMedia) error {
+	stmt := `INSERT INTO tweet_media(tweet_id, entity_id, url, path, size, status, downloaded_at) VALUES(:tweet_id, :entity_id, :url, :path, :size, :status, :downloaded_at)
+	ON CONFLICT(tweet_id, entity_id, url) DO UPDATE SET path=excluded.path, size=excluded.size, status=excluded.status, downloaded_at=excluded.downloaded_at`
+	_, err := db.NamedExec(stmt, media)
+	if err != nil {
+		return err
+	}
+
+	// 冲突时 LastInsertId 不可靠，重新查询 id
+	var id int32
+	err = db.Get(&id, `SELECT id FROM tweet_media WHERE tweet_id=? AND entity_id=? AND url=?`, media.TweetId, media.EntityId, media.Url)
+	if err != nil {
+		return err
+	}
+	media.Id.Scan(id)
+	return nil
+}
+
+func LocateTweetMedia(db *sqlx.DB, tweetId uint64, eid int, url string) (*TweetMedia, error) {
+	stmt := `SELECT * FROM tweet_media WHERE tweet_id=? AND entity_id=? AND url=?`
+	result := &TweetMedia{}
+	err := db.Get(result, stmt, tweetId, eid, url)
+	if err == sql.ErrNoRows {
+		err = nil
+		result = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 获取推文所有媒体在各实体下的下载记录
+func GetTweetMedia(db *sqlx.DB, tweetId uint64) ([]*TweetMedia, error) {
+	stmt := `SELECT * FROM tweet_media WHERE tweet_id = ?`
+	res := []*TweetMedia{}
+	err := db.Select(&res, stmt, tweetId)
+	return res, err
+}
+
+func GetEntityTweetMediaByStatus(db *sqlx.DB, eid int, status MediaStatus) ([]*TweetMedia, error) {
+	stmt := `SELECT * FROM tweet_media WHERE entity_id = ? AND status = ?`
+	res := []*TweetMedia{}
+	err := db.Select(&res, stmt, eid, status)
+	return res, err
+}
+
+// 分页获取内容包含 text 的推文，从新到旧排序，uid 不为 0 时仅获取其发布的推文，返回符合条件的推文总数
+func SearchTweets(db *sqlx.DB, uid uint64, text string, offset int, limit int) ([]*Tweet, int, error) {
+	cond := `(? = 0 OR user_id = ?) AND text LIKE ? ESCAPE '\'`
+	pattern := "%" + likeEscaper.Replace(text) + "%"
+	var total int
+	if err := db.Get(&total, `SELECT COUNT(*) FROM tweets WHERE `+cond, uid, uid, pattern); err != nil {
+		return nil, 0, err
+	}
+	stmt := `SELECT * FROM tweets WHERE ` + cond + ` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`
+	res := []*Tweet{}
+	err := db.Select(&res, stmt, uid, uid, pattern, limit, offset)
+	return res, total, err
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// 分页获取用户所有实体下已下载的媒体及其推文，从新到旧排序，返回媒体总数
+func GetUserDownloadedMedia(db *sqlx.DB, uid uint64, offset int, limit int) ([]*EntityMedia, int, error) {
+	cond := `tweet_media.status = ? AND tweet_media.entity_id IN (SELECT id FROM user_entities WHERE user_id = ?)`
+	var total int
+	if err := db.Get(&total, `SELECT COUNT(*) FROM tweet_media WHERE `+cond, MS_DOWNLOADED, uid); err != nil {
+		return nil, 0, err
+	}
+	stmt := `SELECT tweet_media.*, tweets.text, tweets.created_at FROM tweet_media
+	JOIN tweets ON tweets.id = tweet_media.tweet_id
+	WHERE ` + cond + `
+	ORDER BY tweets.created_at DESC, tweet_media.id LIMIT ? OFFSET ?`
+	res := []*EntityMedia{}
+	err := db.Select(&res, stmt, MS_DOWNLOADED, uid, limit, offset)
+	return res, total, err
+}
+
+// 获取实体下已下载的媒体及其推文，按推文发布时间从新到旧排序
+func GetEntityDownloadedMedia(db *sqlx.DB, eid int) ([]*EntityMedia, error) {
+	stmt := `SELECT tweet_media.*, tweets.text, tweets.created_at FROM tweet_media
+	JOIN tweets ON tweets.id = tweet_media.tweet_id
+	WHERE tweet_media.entity_id = ? AND tweet_media.status = ?
+	ORDER BY tweets.created_at DESC, tweet_media.id`
+	res := []*EntityMedia{}
+	err := db.Select(&res, stmt, eid, MS_DOWNLOADED)
+	return res, err
+}
+
+// 记录内容的首个副本，内容已被记录时忽略
+func CreateMediaBlob(db *sqlx.DB, blob *MediaBlob) error {
+	stmt := `INSERT OR IGNORE INTO media_blobs(hash, path, size) VALUES(:hash, :path, :size)`
+	_, err := db.NamedExec(stmt, blob)
+	return err
+}
+
+func GetMediaBlob(db *sqlx.DB, hash string) (*MediaBlob, error) {
+	stmt := `SELECT * FROM media_blobs WHERE hash=?`
+	result := &MediaBlob{}
+	err := db.Get(result, stmt, hash)
+	if err == sql.ErrNoRows {
+		err = nil
+		result = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func UpdateMediaBlob(db *sqlx.DB, blob *MediaBlob) error {
+	stmt := `UPDATE media_blobs SET path=:path, size=:size WHERE hash=:hash`
+	_, err := db.NamedExec(stmt, blob)
+	return err
+}
+
+// 记录被替换为链接的文件原本的修改时间，链接的修改时间是首个副本的
+func RecordDedupedFile(db *sqlx.DB, path string, modTime time.Time) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	stmt := `INSERT OR REPLACE INTO deduped_files(path, mod_time) VALUES(?, ?)`
+	_, err = db.Exec(stmt, path, modTime.Unix())
+	return err
+}
+
+// 获取被替换为链接的文件原本的修改时间，未记录时返回零值
+func GetDedupedFileTime(db *sqlx.DB, path string) (time.Time, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var modTime int64
+	err = db.Get(&modTime, `SELECT mod_time FROM deduped_files WHERE path=?`, path)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(modTime, 0), nil
+}
+
+// 目录改名后更新其下被去重文件的路径
+func MoveDedupedFiles(db *sqlx.DB, oldDir string, newDir string) error {
+	oldDir, err := filepath.Abs(oldDir)
+	if err != nil {
+		return err
+	}
+	newDir, err = filepath.Abs(newDir)
+	if err != nil {
+		return err
+	}
+	oldPrefix := oldDir + string(filepath.Separator)
+	// sqlite 的 substr 按字符计数
+	n := utf8.RuneCountInString(oldPrefix)
+	stmt := `UPDATE deduped_files SET path=? || substr(path, ?) WHERE substr(path, 1, ?)=?`
+	_, err = db.Exec(stmt, newDir+string(filepath.Separator), n+1, n, oldPrefix)
+	return err
+}
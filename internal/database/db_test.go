@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"sync"
 	"testing"
 	"time"
@@ -504,6 +506,207 @@ func hasSameUserLinkRecord(link *UserLink) (bool, error) {
 	return record != nil && *record == *link, err
 }
 
+func TestLikeMarks(t *testing.T) {
+	db = opentmpdb()
+	defer db.Close()
+
+	usr := generateUser(1)
+	if err := CreateUser(db, usr); err != nil {
+		t.Error(err)
+		return
+	}
+
+	marks, err := GetLikeMarks(db, usr.Id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(marks) != 0 {
+		t.Errorf("initial marks = %v, want empty", marks)
+	}
+
+	for _, want := range [][]uint64{{100, 200}, {300}} {
+		if err := SetLikeMarks(db, usr.Id, want); err != nil {
+			t.Error(err)
+			return
+		}
+		marks, err = GetLikeMarks(db, usr.Id)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		slices.Sort(marks)
+		if !reflect.DeepEqual(marks, want) {
+			t.Errorf("marks = %v, want %v", marks, want)
+		}
+	}
+}
+
+func TestBookmark(t *testing.T) {
+	db = opentmpdb()
+	defer db.Close()
+
+	usr := generateUser(1)
+	if err := CreateUser(db, usr); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		// 重复记录不应出错
+		if err := CreateBookmark(db, usr.Id, 100); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	tests := []struct {
+		tweetId uint64
+		want    bool
+	}{
+		{100, true},
+		{200, false},
+	}
+	for _, test := range tests {
+		yes, err := HasBookmark(db, usr.Id, test.tweetId)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if yes != test.want {
+			t.Errorf("HasBookmark(%d) = %v, want %v", test.tweetId, yes, test.want)
+		}
+	}
+}
+
+func TestTweetLedger(t *testing.T) {
+	db = opentmpdb()
+	defer db.Close()
+
+	tweet := &Tweet{Id: 1, Uid: 2, Text: "hello", CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	if err := RecordTweet(db, tweet); err != nil {
+		t.Error(err)
+		return
+	}
+	// 重复记录将更新
+	tweet.Text = "edited"
+	if err := RecordTweet(db, tweet); err != nil {
+		t.Error(err)
+		return
+	}
+	record, err := GetTweet(db, tweet.Id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if record == nil || record.Text != tweet.Text || record.Uid != tweet.Uid || !record.CreatedAt.Equal(tweet.CreatedAt) {
+		t.Errorf("record mismatch after record tweet: %v", record)
+		return
+	}
+
+	media := &TweetMedia{TweetId: tweet.Id, EntityId: 3, Url: "https://pbs.twimg.com/media/a.jpg", Status: MS_FAILED}
+	if err := RecordTweetMedia(db, media); err != nil {
+		t.Error(err)
+		return
+	}
+	id := media.Id.Int32
+
+	failed, err := GetEntityTweetMediaByStatus(db, 3, MS_FAILED)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(failed) != 1 || failed[0].Url != media.Url {
+		t.Errorf("failed media: %v, want 1", failed)
+		return
+	}
+
+	// 下载成功后覆盖记录
+	media.Status = MS_DOWNLOADED
+	media.Path.Scan("/tmp/a.jpg")
+	media.Size.Scan(int64(1024))
+	media.DownloadedAt.Scan(time.Now())
+	if err := RecordTweetMedia(db, media); err != nil {
+		t.Error(err)
+		return
+	}
+	if media.Id.Int32 != id {
+		t.Errorf("media.id = %d after update, want %d", media.Id.Int32, id)
+	}
+
+	located, err := LocateTweetMedia(db, tweet.Id, 3, media.Url)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if located == nil || located.Status != MS_DOWNLOADED || located.Path.String != "/tmp/a.jpg" || located.Size.Int64 != 1024 {
+		t.Errorf("record mismatch after update tweet media: %v", located)
+		return
+	}
+
+	all, err := GetTweetMedia(db, tweet.Id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(all) != 1 {
+		t.Errorf("len(media) = %d, want 1", len(all))
+	}
+
+	located, err = LocateTweetMedia(db, tweet.Id, 4, media.Url)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if located != nil {
+		t.Errorf("located media in another entity")
+	}
+}
+
+func TestMediaBlob(t *testing.T) {
+	db = opentmpdb()
+	defer db.Close()
+
+	blob := &MediaBlob{Hash: "abc", Path: "/tmp/a.jpg", Size: 10}
+	if err := CreateMediaBlob(db, blob); err != nil {
+		t.Error(err)
+		return
+	}
+	// 内容已被记录，保留首个副本
+	if err := CreateMediaBlob(db, &MediaBlob{Hash: "abc", Path: "/tmp/b.jpg", Size: 10}); err != nil {
+		t.Error(err)
+		return
+	}
+	record, err := GetMediaBlob(db, "abc")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if record == nil || *record != *blob {
+		t.Errorf("blob: %v, want %v", record, blob)
+		return
+	}
+
+	blob.Path = "/tmp/c.jpg"
+	if err := UpdateMediaBlob(db, blob); err != nil {
+		t.Error(err)
+		return
+	}
+	record, err = GetMediaBlob(db, "abc")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if record == nil || record.Path != blob.Path {
+		t.Errorf("blob: %v after update, want %v", record, blob)
+	}
+
+	record, err = GetMediaBlob(db, "none")
+	if err != nil || record != nil {
+		t.Errorf("GetMediaBlob(none) = %v, %v, want nil", record, err)
+	}
+}
+
 func benchmarkUpdateUser(b *testing.B, routines int) {
 	db = opentmpdb()
 	defer db.Close()
@@ -556,3 +759,37 @@ func BenchmarkUpdateUser12(b *testing.B) {
 func BenchmarkUpdateUser24(b *testing.B) {
 	benchmarkUpdateUser(b, 24)
 }
+
+func TestDedupedFile(t *testing.T) {
+	db = opentmpdb()
+	defer db.Close()
+
+	modTime := time.Unix(1700000000, 0)
+	for _, path := range []string{"/tmp/用户/a.jpg", "/tmp/用户2/a.jpg"} {
+		if err := RecordDedupedFile(db, path, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 目录改名后记录跟随移动，前缀相同的其他目录不受影响
+	if err := MoveDedupedFiles(db, "/tmp/用户", "/tmp/新名字"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want time.Time
+	}{
+		{"/tmp/新名字/a.jpg", modTime},
+		{"/tmp/用户/a.jpg", time.Time{}},
+		{"/tmp/用户2/a.jpg", modTime},
+	}
+	for _, test := range tests {
+		got, err := GetDedupedFileTime(db, test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("%s: mod time %v, want %v", test.path, got, test.want)
+		}
+	}
+}
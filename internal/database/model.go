@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -16,6 +17,14 @@ type User struct {
 	FriendsCount int    `db:"friends_count"`
 }
 
+type UserPreviousName struct {
+	Id         int32     `db:"id"`
+	Uid        uint64    `db:"uid"`
+	ScreenName string    `db:"screen_name"`
+	Name       string    `db:"name"`
+	RecordDate time.Time `db:"record_date"`
+}
+
 type UserEntity struct {
 	Id                sql.NullInt32 `db:"id"`
 	Uid               uint64        `db:"user_id"`
@@ -45,6 +54,47 @@ type LstEntity struct {
 	ParentDir string        `db:"parent_dir"`
 }
 
+type Tweet struct {
+	Id        uint64    `db:"id"`
+	Uid       uint64    `db:"user_id"`
+	Text      string    `db:"text"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type MediaStatus int
+
+const (
+	MS_PENDING    MediaStatus = iota // 正在下载，或下载被中断
+	MS_DOWNLOADED                    // 已移动到最终路径
+	MS_FAILED                        // 下载或提交失败
+)
+
+// 推文中的一个媒体在某个用户实体下的下载记录
+type TweetMedia struct {
+	Id           sql.NullInt32  `db:"id"`
+	TweetId      uint64         `db:"tweet_id"`
+	EntityId     int32          `db:"entity_id"`
+	Url          string         `db:"url"`
+	Path         sql.NullString `db:"path"`
+	Size         sql.NullInt64  `db:"size"`
+	Status       MediaStatus    `db:"status"`
+	DownloadedAt sql.NullTime   `db:"downloaded_at"`
+}
+
+// 媒体的下载记录及其所属推文的内容和发布时间
+type EntityMedia struct {
+	TweetMedia
+	Text      string    `db:"text"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// 内容相同的媒体只保留一个副本，其余副本链接至此
+type MediaBlob struct {
+	Hash string `db:"hash"` // sha256
+	Path string `db:"path"`
+	Size int64  `db:"size"`
+}
+
 func (le *LstEntity) Path() string {
 	if le.ParentDir == "" || le.Name == "" {
 		panic("no enough info to get path")
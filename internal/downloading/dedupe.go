@@ -0,0 +1,236 @@
+package downloading
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"github.com/unkmonster/tmd/internal/database"
+)
+
+type DedupeMode int
+
+const (
+	DM_NONE DedupeMode = iota
+	DM_HARDLINK
+	DM_SYMLINK
+)
+
+// 下载的媒体与已有媒体内容相同时的处理方式
+var Dedupe DedupeMode
+
+// 解析去重方式 none, hardlink, symlink
+func ParseDedupeMode(str string) (DedupeMode, error) {
+	switch strings.ToLower(strings.TrimSpace(str)) {
+	case "", "none":
+		return DM_NONE, nil
+	case "hardlink":
+		return DM_HARDLINK, nil
+	case "symlink":
+		return DM_SYMLINK, nil
+	}
+	return DM_NONE, fmt.Errorf("invalid dedupe mode: %s", str)
+}
+
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// 测试中替换以模拟跨文件系统
+var link = os.Link
+
+// 链接的两端位于不同的文件系统，无法创建硬链接
+func isCrossDevice(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	// windows 下为 ERROR_NOT_SAME_DEVICE
+	return errno == syscall.EXDEV || (runtime.GOOS == "windows" && errno == 17)
+}
+
+// 指向 target 的符号链接的内容，尽量使用相对路径，使存储目录被整体移动后链接仍然有效
+func symlinkTarget(target string, path string) (string, error) {
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(dir, target); err == nil {
+		return rel, nil
+	}
+	return target, nil
+}
+
+// 用指向 target 的链接替换 path
+func replaceWithLink(target string, path string, mode DedupeMode) error {
+	tmp := path + ".link"
+	os.Remove(tmp)
+
+	var err error
+	if mode == DM_HARDLINK {
+		err = link(target, tmp)
+	} else {
+		target, err = symlinkTarget(target, path)
+		if err == nil {
+			err = os.Symlink(target, tmp)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// 符号链接指向的副本所在的目录名
+const blobDirName = ".blobs"
+
+// 符号链接模式下同内容的副本共同指向的文件。首个副本被移动到其用户目录旁的 .blobs 目录下，
+// 以内容哈希命名，原位置替换为链接，因此用户目录改名不会使链接失效
+func ensureBlob(db *sqlx.DB, first *database.MediaBlob) (string, error) {
+	parent := filepath.Dir(filepath.Dir(first.Path))
+	if filepath.Base(parent) == blobDirName {
+		return first.Path, nil
+	}
+
+	blob := filepath.Join(parent, blobDirName, first.Hash[:2], first.Hash+filepath.Ext(first.Path))
+	if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(first.Path, blob); err != nil {
+		return "", err
+	}
+	if err := replaceWithLink(blob, first.Path, DM_SYMLINK); err != nil {
+		os.Rename(blob, first.Path)
+		return "", err
+	}
+	first.Path = blob
+	return blob, database.UpdateMediaBlob(db, first)
+}
+
+// 将文件与记录的同内容副本去重：内容未被记录过则记录此文件为首个副本，
+// 否则用指向首个副本的链接替换此文件。硬链接无法跨越文件系统时改用符号链接。返回回收的字节数
+func dedupeFile(db *sqlx.DB, path string, mode DedupeMode) (int64, error) {
+	hash, size, err := hashFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	blob := &database.MediaBlob{Hash: hash, Path: path, Size: size}
+	if err := database.CreateMediaBlob(db, blob); err != nil {
+		return 0, err
+	}
+	first, err := database.GetMediaBlob(db, hash)
+	if err != nil {
+		return 0, err
+	}
+	if first.Path == path {
+		return 0, nil
+	}
+
+	// 首个副本已丢失，由此文件接替
+	firstInfo, err := os.Stat(first.Path)
+	if os.IsNotExist(err) || (err == nil && firstInfo.Size() != first.Size) {
+		return 0, database.UpdateMediaBlob(db, blob)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if os.SameFile(firstInfo, info) {
+		return 0, nil
+	}
+	// 链接的修改时间是首个副本的，记录此文件原本的修改时间以便之后仍能识别出它
+	if err := database.RecordDedupedFile(db, path, info.ModTime()); err != nil {
+		return 0, err
+	}
+
+	if mode == DM_HARDLINK {
+		err = replaceWithLink(first.Path, path, mode)
+		if err == nil {
+			return size, nil
+		}
+		if !isCrossDevice(err) {
+			return 0, err
+		}
+		log.WithField("path", path).Debugln("first copy is on another file system, fall back to symlink")
+	}
+	target, err := ensureBlob(db, first)
+	if err != nil {
+		return 0, err
+	}
+	if err := replaceWithLink(target, path, DM_SYMLINK); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+type DedupeStats struct {
+	Files     int   // 检查的文件数
+	Linked    int   // 被替换为链接的文件数
+	Reclaimed int64 // 回收的字节数
+}
+
+// 对 root 下已有的媒体去重。跳过符号链接，临时文件和以 . 开头的目录
+func DedupeDir(db *sqlx.DB, root string, mode DedupeMode) (*DedupeStats, error) {
+	stats := DedupeStats{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(d.Name(), ".part") {
+			return nil
+		}
+
+		stats.Files++
+		reclaimed, err := dedupeFile(db, path, mode)
+		if err != nil {
+			log.WithField("path", path).Warnln("failed to dedupe file:", err)
+			return nil
+		}
+		if reclaimed != 0 {
+			stats.Linked++
+			stats.Reclaimed += reclaimed
+		}
+		return nil
+	})
+	return &stats, err
+}
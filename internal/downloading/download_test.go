@@ -1,15 +1,26 @@
 package downloading
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/go-resty/resty/v2"
 	"github.com/jmoiron/sqlx"
 	"github.com/unkmonster/tmd/internal/database"
 	"github.com/unkmonster/tmd/internal/twitter"
+	"github.com/unkmonster/tmd/internal/twitter/fake"
 	"github.com/unkmonster/tmd/internal/utils"
 )
 
@@ -356,3 +367,1140 @@ func generateSomeTweets(n int) []*twitter.Tweet {
 	}
 	return res
 }
+
+func TestFindMediaFile(t *testing.T) {
+	tempdir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(tempdir)
+
+	tweet := &twitter.Tweet{Id: 1, Text: "a", CreatedAt: time.Now().Add(-time.Hour).Truncate(time.Second)}
+	files := []struct {
+		name  string
+		mtime time.Time
+	}{
+		{"a.jpg", tweet.CreatedAt},
+		{"a(1).jpg", time.Now()}, // 另一条同名推文
+		{"a(2).jpg", tweet.CreatedAt},
+	}
+	for _, f := range files {
+		path := filepath.Join(tempdir, f.name)
+		if err := os.WriteFile(path, []byte(f.name), 0644); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := os.Chtimes(path, time.Time{}, f.mtime); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	tests := []struct {
+		nth  int
+		want string
+	}{
+		{0, "a.jpg"},
+		{1, "a(2).jpg"},
+		{2, ""},
+	}
+	for _, test := range tests {
+		path, err := findMediaFile(nil, tweet, filepath.Join(tempdir, "a.jpg"), test.nth)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if test.want == "" && path != "" {
+			t.Errorf("findMediaFile(%d) = %s, want none", test.nth, path)
+		} else if test.want != "" && path != filepath.Join(tempdir, test.want) {
+			t.Errorf("findMediaFile(%d) = %s, want %s", test.nth, path, test.want)
+		}
+	}
+}
+
+func TestFetchHlsMedia(t *testing.T) {
+	mux := http.NewServeMux()
+	// 与 X 相同，音轨在独立的分组中
+	mux.HandleFunc("/pl/video.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n#EXT-X-MEDIA:NAME=\"Audio\",TYPE=AUDIO,GROUP-ID=\"audio-128000\",AUTOSELECT=YES,URI=\"/pl/audio/audio.m3u8\"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720,AUDIO=\"audio-128000\"\n/pl/avc1/video.m3u8\n"))
+	})
+	mux.HandleFunc("/pl/avc1/video.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:3.0,\n0.m4s\n#EXTINF:3.0,\n1.m4s\n#EXT-X-ENDLIST\n"))
+	})
+	mux.HandleFunc("/pl/audio/audio.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:6.0,\n0.m4s\n#EXT-X-ENDLIST\n"))
+	})
+	for path, body := range map[string]string{"/pl/avc1/init.mp4": "init;", "/pl/avc1/0.m4s": "0;", "/pl/avc1/1.m4s": "1;", "/pl/audio/init.mp4": "audio;", "/pl/audio/0.m4s": "a0;"} {
+		body := body
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		})
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tempdir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	// 只有 m3u8 变体的视频
+	media := twitter.Media{
+		Type:     twitter.MT_VIDEO,
+		Url:      server.URL + "/pl/video.m3u8",
+		Variants: []twitter.Variant{{ContentType: "application/x-mpegURL", Url: server.URL + "/pl/video.m3u8"}},
+	}
+	part := filepath.Join(tempdir, "a.part")
+	fetched, err := fetchMedia(context.Background(), resty.New(), &media, mediaUrl(&media), part, func(ext string) string { return "a" + ext })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fetched) != 2 || fetched[0].name != "a.mp4" || fetched[1].name != "a.m4a" {
+		t.Fatalf("fetched = %+v, want a.mp4 and its audio a.m4a", fetched)
+	}
+
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	paths, err := commitMedia(tempdir, fetched, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"init;0;1;", "audio;a0;"} {
+		data, err := os.ReadFile(paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want || fetched[i].size != int64(len(data)) {
+			t.Errorf("content of %s = %q, size = %d", paths[i], data, fetched[i].size)
+		}
+		if info, err := os.Stat(paths[i]); err != nil || !info.ModTime().Equal(mtime) {
+			t.Errorf("mtime = %v, want %v", info.ModTime(), mtime)
+		}
+	}
+	for _, part := range []string{part, audioPartPath(part)} {
+		if ex, _ := utils.PathExists(part); ex {
+			t.Errorf("part file %s is left after commit", part)
+		}
+	}
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	ranges := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/range.mp4", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges++
+		}
+		http.ServeContent(w, r, "range.mp4", time.Time{}, strings.NewReader(content))
+	})
+	mux.HandleFunc("/norange.mp4", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tempdir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	tests := []struct {
+		path    string
+		partial string
+	}{
+		{"/range.mp4", content[:4321]},
+		{"/range.mp4", content + "garbage"}, // 416，重新下载
+		{"/norange.mp4", content[:4321]},
+		{"/range.mp4", ""},
+	}
+	for _, test := range tests {
+		part := filepath.Join(tempdir, "v.part")
+		if err := os.WriteFile(part, []byte(test.partial), 0644); err != nil {
+			t.Fatal(err)
+		}
+		size, err := downloadFile(context.Background(), resty.New(), server.URL+test.path, part)
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+			continue
+		}
+		data, _ := os.ReadFile(part)
+		if string(data) != content || size != int64(len(content)) {
+			t.Errorf("%s with %d bytes downloaded: got %d bytes, size = %d", test.path, len(test.partial), len(data), size)
+		}
+	}
+	if ranges != 2 {
+		t.Errorf("range requests: %d, want 2", ranges)
+	}
+}
+
+func TestDownloadTweetAllOrNothing(t *testing.T) {
+	broken := true
+	mux := http.NewServeMux()
+	mux.HandleFunc("/media/a.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a"))
+	})
+	mux.HandleFunc("/media/b.jpg", func(w http.ResponseWriter, r *http.Request) {
+		if broken {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("b"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tempdir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	tweet := &twitter.Tweet{
+		Id:        2,
+		Text:      "all or nothing",
+		CreatedAt: time.Now().Truncate(time.Second),
+		Creator:   &twitter.User{Name: "name", ScreenName: "screen"},
+		Media: []twitter.Media{
+			{Type: twitter.MT_PHOTO, Url: server.URL + "/media/a.jpg"},
+			{Type: twitter.MT_PHOTO, Url: server.URL + "/media/b.jpg"},
+		},
+	}
+	listNames := func() []string {
+		entries, _ := os.ReadDir(tempdir)
+		names := []string{}
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry.Name(), ".part") {
+				names = append(names, entry.Name())
+			}
+		}
+		return names
+	}
+
+	const eid = 9301
+	checkStatus := func(want database.MediaStatus) {
+		t.Helper()
+		for _, media := range tweet.Media {
+			record, err := database.LocateTweetMedia(db, tweet.Id, eid, media.Url)
+			if err != nil || record == nil || record.Status != want {
+				t.Errorf("record of %s = %+v, %v, want status %d", media.Url, record, err, want)
+			}
+		}
+	}
+
+	if err := downloadTweetMedia(context.Background(), resty.New(), db, eid, tempdir, tweet, nil); err == nil {
+		t.Fatal("download succeeded with a broken media")
+	}
+	if names := listNames(); len(names) != 0 {
+		t.Errorf("files left after failure: %v", names)
+	}
+	// 已下载至临时文件但未提交的媒体同样记录为失败
+	checkStatus(database.MS_FAILED)
+
+	broken = false
+	if err := downloadTweetMedia(context.Background(), resty.New(), db, eid, tempdir, tweet, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(database.MS_DOWNLOADED)
+	entries, _ := os.ReadDir(tempdir)
+	if len(entries) != 2 {
+		t.Errorf("files after download: %v, want 2 media without part files", listNames())
+	}
+	for _, want := range []string{"all or nothing.jpg", "all or nothing(1).jpg"} {
+		if ex, _ := utils.PathExists(filepath.Join(tempdir, want)); !ex {
+			t.Errorf("%s is not downloaded", want)
+		}
+	}
+}
+
+// 第二个媒体移动失败时，已移动的媒体被回滚，不留下元数据文件和下载记录
+func TestDownloadTweetCommitFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "gone.jpg") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(filepath.Base(r.URL.Path)))
+	}))
+	defer server.Close()
+
+	sidecar := Sidecar
+	Sidecar = true
+	defer func() { Sidecar = sidecar }()
+
+	tweet := &twitter.Tweet{
+		Id:        4,
+		Text:      "commit failure",
+		CreatedAt: time.Now().Add(-time.Hour).Truncate(time.Second),
+		Creator:   &twitter.User{Name: "name", ScreenName: "screen"},
+		Media: []twitter.Media{
+			{Type: twitter.MT_PHOTO, Url: server.URL + "/media/a.jpg"},
+			{Type: twitter.MT_PHOTO, Url: server.URL + "/media/b.jpg"},
+		},
+	}
+	const eid = 9302
+	dir := t.TempDir()
+	listNames := func() (names []string, parts int) {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".part") {
+				parts++
+			} else {
+				names = append(names, entry.Name())
+			}
+		}
+		return
+	}
+
+	renamed := 0
+	rename = func(from, to string) error {
+		if renamed++; renamed == 2 {
+			return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EIO}
+		}
+		return os.Rename(from, to)
+	}
+	err := downloadTweetMedia(context.Background(), resty.New(), db, eid, dir, tweet, nil)
+	rename = os.Rename
+	if err == nil {
+		t.Fatal("download succeeded with a failed rename")
+	}
+	if names, parts := listNames(); len(names) != 0 || parts != 2 {
+		t.Errorf("after failure: files %v, %d part files, want only 2 part files", names, parts)
+	}
+	for _, media := range tweet.Media {
+		if record, _ := database.LocateTweetMedia(db, tweet.Id, eid, media.Url); record == nil || record.Status != database.MS_FAILED {
+			t.Errorf("record of %s = %+v, want failed", media.Url, record)
+		}
+	}
+
+	if err := downloadTweetMedia(context.Background(), resty.New(), db, eid, dir, tweet, nil); err != nil {
+		t.Fatal(err)
+	}
+	if names, parts := listNames(); len(names) != 3 || parts != 0 {
+		t.Errorf("after retry: files %v, %d part files, want 2 media and a sidecar", names, parts)
+	}
+
+	// 媒体被删除时不会重试，临时文件同样被删除
+	tweet = &twitter.Tweet{
+		Id:        5,
+		Text:      "gone",
+		CreatedAt: tweet.CreatedAt,
+		Creator:   tweet.Creator,
+		Media: []twitter.Media{
+			{Type: twitter.MT_PHOTO, Url: server.URL + "/media/c.jpg"},
+			{Type: twitter.MT_PHOTO, Url: server.URL + "/media/gone.jpg"},
+		},
+	}
+	dir = t.TempDir()
+	if err := downloadTweetMedia(context.Background(), resty.New(), nil, 0, dir, tweet, nil); !isPermanent(err) {
+		t.Fatalf("err = %v, want 404", err)
+	}
+	if names, parts := listNames(); len(names) != 0 || parts != 0 {
+		t.Errorf("after permanent failure: files %v, %d part files", names, parts)
+	}
+}
+
+func TestDownloadTweetMediaTypes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(filepath.Ext(r.URL.Path)))
+	}))
+	defer server.Close()
+
+	tweet := &twitter.Tweet{
+		Id:        3,
+		Text:      "media types",
+		CreatedAt: time.Now().Truncate(time.Second),
+		Creator:   &twitter.User{Name: "name", ScreenName: "screen"},
+		Media: []twitter.Media{
+			{Type: twitter.MT_PHOTO, Url: server.URL + "/media/a.jpg"},
+			{Type: twitter.MT_VIDEO, Url: server.URL + "/media/b.mp4"},
+		},
+	}
+	for _, test := range []struct {
+		opts *MediaOptions
+		want []string
+	}{
+		{nil, []string{"media types.jpg", "media types.mp4"}},
+		{&MediaOptions{Types: []twitter.MediaType{twitter.MT_VIDEO}}, []string{"media types.mp4"}},
+		{&MediaOptions{NameTemplate: utils.MustParseNameTemplate("{id}_{index}", mediaNameFields...)}, []string{"3_1.jpg", "3_2.mp4"}},
+	} {
+		dir := t.TempDir()
+		if err := downloadTweetMedia(context.Background(), resty.New(), nil, 0, dir, tweet, test.opts); err != nil {
+			t.Fatal(err)
+		}
+		entries, _ := os.ReadDir(dir)
+		names := []string{}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		if !reflect.DeepEqual(names, test.want) {
+			t.Errorf("downloaded %v with %+v, want %v", names, test.opts, test.want)
+		}
+	}
+}
+
+func TestMediaName(t *testing.T) {
+	tweet := &twitter.Tweet{
+		Id:        1800000000000000000,
+		Text:      "hello\nworld",
+		CreatedAt: time.Date(2024, 7, 1, 8, 0, 0, 0, time.Local),
+		Creator:   &twitter.User{Name: "Name", ScreenName: "screen"},
+		Media:     []twitter.Media{{Type: twitter.MT_PHOTO}, {Type: twitter.MT_VIDEO}},
+	}
+
+	tests := []struct {
+		template string
+		index    int
+		want     string
+	}{
+		{"", 0, "hello world.jpg"},
+		{"{date:2006-01-02}_{id}_{index}_{text:5}{ext}", 1, "2024-07-01_1800000000000000000_2_hello.mp4"},
+		{"{user}-{name}-{type}", 1, "screen-Name-video.mp4"},
+	}
+	for _, test := range tests {
+		tmpl, err := ParseMediaNameTemplate(test.template)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		ext := ".jpg"
+		if test.index == 1 {
+			ext = ".mp4"
+		}
+		if got := mediaName(tmpl, tweet, test.index, ext); got != test.want {
+			t.Errorf("mediaName(%q) = %q, want %q", test.template, got, test.want)
+		}
+	}
+
+	if _, err := ParseMediaNameTemplate("{ext}{id}"); err == nil {
+		t.Errorf("{ext} in the middle of template is accepted")
+	}
+}
+
+func TestDedupeDir(t *testing.T) {
+	for _, mode := range []DedupeMode{DM_HARDLINK, DM_SYMLINK} {
+		tempdir, err := os.MkdirTemp("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tempdir)
+
+		files := map[string]string{
+			"a/1.jpg":       "same",
+			"b/2.jpg":       "same",
+			"b/3.jpg":       "different",
+			".data/foo.db":  "same",
+			"a/.1-abc.part": "same",
+		}
+		for name, content := range files {
+			path := filepath.Join(tempdir, name)
+			os.MkdirAll(filepath.Dir(path), 0755)
+			if err := os.WriteFile(path, []byte(content+tempdir), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stats, err := DedupeDir(db, tempdir, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Files != 3 || stats.Linked != 1 || stats.Reclaimed != int64(len("same"+tempdir)) {
+			t.Errorf("mode %d: stats = %+v", mode, stats)
+		}
+
+		first, _ := os.Stat(filepath.Join(tempdir, "a/1.jpg"))
+		dup, _ := os.Stat(filepath.Join(tempdir, "b/2.jpg"))
+		if !os.SameFile(first, dup) {
+			t.Errorf("mode %d: duplicate is not linked", mode)
+		}
+		if mode == DM_SYMLINK {
+			for _, name := range []string{"a/1.jpg", "b/2.jpg"} {
+				target, err := os.Readlink(filepath.Join(tempdir, name))
+				if err != nil || filepath.IsAbs(target) || !strings.Contains(target, blobDirName) {
+					t.Errorf("%s links to %s, %v, want a relative link to blob", name, target, err)
+				}
+			}
+			// 用户目录改名后链接仍然有效
+			if err := os.Rename(filepath.Join(tempdir, "a"), filepath.Join(tempdir, "a2")); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"a2/1.jpg", "b/2.jpg"} {
+				if data, err := os.ReadFile(filepath.Join(tempdir, name)); err != nil || string(data) != "same"+tempdir {
+					t.Errorf("%s after rename: %q, %v", name, data, err)
+				}
+			}
+		}
+
+		// 再次去重无事发生
+		stats, err = DedupeDir(db, tempdir, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Linked != 0 {
+			t.Errorf("mode %d: linked %d files again", mode, stats.Linked)
+		}
+	}
+}
+
+// 硬链接跨越文件系统时改用符号链接
+func TestDedupeCrossDevice(t *testing.T) {
+	tempdir := t.TempDir()
+	for _, name := range []string{"a/1.jpg", "b/2.jpg"} {
+		path := filepath.Join(tempdir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte("cross"+tempdir), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	link = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	defer func() { link = os.Link }()
+	stats, err := DedupeDir(db, tempdir, DM_HARDLINK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Linked != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if info, err := os.Lstat(filepath.Join(tempdir, "b/2.jpg")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("duplicate is not replaced with a symlink: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(tempdir, "b/2.jpg")); err != nil || string(data) != "cross"+tempdir {
+		t.Errorf("content = %q, %v", data, err)
+	}
+}
+
+func TestPackTweets(t *testing.T) {
+	tempdir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	self := &twitter.User{Id: 100, Name: "self", ScreenName: "self"}
+	author := &twitter.User{Id: 200, Name: "author", ScreenName: "author"}
+	entity, err := syncUserAndEntity(db, self, tempdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	media := []twitter.Media{{Type: twitter.MT_PHOTO, Url: "https://pbs.twimg.com/media/a.jpg"}}
+	original := &twitter.Tweet{Id: 1, Creator: author, Media: media}
+	quoted := &twitter.Tweet{Id: 2, Creator: author, Media: media}
+	tweets := []*twitter.Tweet{
+		{Id: 3, Creator: self, Retweeted: original},
+		{Id: 4, Creator: self, Media: media, Quoted: quoted},
+		{Id: 5, Creator: self, Media: media},
+	}
+
+	tests := []struct {
+		retweets RepostPolicy
+		quotes   RepostPolicy
+		want     map[uint64]uint64 // tweet id -> 实体所属用户 id
+	}{
+		{RP_SELF, RP_SKIP, map[uint64]uint64{1: 100, 4: 100, 5: 100}},
+		// 零值与命令行的默认值相同
+		{RP_DEFAULT, RP_DEFAULT, map[uint64]uint64{1: 100, 4: 100, 5: 100}},
+		{RP_SKIP, RP_SELF, map[uint64]uint64{2: 100, 4: 100, 5: 100}},
+		{RP_AUTHOR, RP_AUTHOR, map[uint64]uint64{1: 200, 2: 200, 4: 100, 5: 100}},
+	}
+	for _, test := range tests {
+		opts := BatchOptions{Retweets: test.retweets, Quotes: test.quotes}
+		pts := opts.packTweets(db, tweets, entity, tempdir)
+
+		got := make(map[uint64]uint64)
+		for _, pt := range pts {
+			got[pt.Tweet.Id] = pt.Entity.Uid()
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("retweets %d, quotes %d: packed %v, want %v", test.retweets, test.quotes, got, test.want)
+		}
+	}
+
+	if ex, _ := utils.PathExists(filepath.Join(tempdir, "author(author)")); !ex {
+		t.Errorf("entity of author is not created")
+	}
+}
+
+func TestWriteSidecar(t *testing.T) {
+	tempdir := t.TempDir()
+	tweet := &twitter.Tweet{
+		Id:        3,
+		Text:      "sidecar #tag @someone",
+		CreatedAt: time.Date(2024, 7, 1, 8, 0, 0, 0, time.UTC),
+		Creator:   &twitter.User{Id: 30, Name: "name", ScreenName: "screen"},
+		Media: []twitter.Media{{
+			Type:     twitter.MT_VIDEO,
+			Url:      "https://video.twimg.com/ext_tw_video/3/vid/720x1280/a.mp4",
+			Duration: 1500 * time.Millisecond,
+			Variants: []twitter.Variant{{Bitrate: 2176000, ContentType: "video/mp4", Url: "https://video.twimg.com/ext_tw_video/3/vid/720x1280/a.mp4"}},
+		}},
+		Legacy: json.RawMessage(`{"favorite_count": 5, "in_reply_to_status_id_str": "2",
+			"entities": {"hashtags": [{"text": "tag"}], "user_mentions": [{"screen_name": "someone"}]}}`),
+	}
+
+	if err := writeSidecar(tempdir, tweet, false); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(tempdir, "3.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := tweetSidecar{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != 3 || got.Creator.ScreenName != "screen" || !got.CreatedAt.Equal(tweet.CreatedAt) {
+		t.Errorf("sidecar = %+v", got)
+	}
+	if got.FavoriteCount != 5 || got.ReplyTo != 2 || !reflect.DeepEqual(got.Hashtags, []string{"tag"}) || !reflect.DeepEqual(got.Mentions, []string{"someone"}) {
+		t.Errorf("metadata = %+v", got.TweetMetadata)
+	}
+	if len(got.Media) != 1 || got.Media[0].Duration != 1.5 || len(got.Media[0].Variants) != 1 {
+		t.Errorf("media = %+v", got.Media)
+	}
+	if !json.Valid(got.Legacy) || len(got.Legacy) == 0 {
+		t.Errorf("legacy = %s", got.Legacy)
+	}
+
+	// 已存在时不覆盖，除非 force
+	tweet.Text = "changed"
+	writeSidecar(tempdir, tweet, false)
+	data, _ = os.ReadFile(filepath.Join(tempdir, "3.json"))
+	if strings.Contains(string(data), "changed") {
+		t.Error("existing sidecar is overwritten")
+	}
+	writeSidecar(tempdir, tweet, true)
+	data, _ = os.ReadFile(filepath.Join(tempdir, "3.json"))
+	if !strings.Contains(string(data), "changed") {
+		t.Error("sidecar is not overwritten with force")
+	}
+}
+
+func TestStoreRoots(t *testing.T) {
+	disk2, disk3 := t.TempDir(), t.TempDir()
+	StoreRoots = []*StoreRoot{{Path: disk2, Lists: []uint64{7}}, {Path: disk3, Users: []string{"@Alice", "42"}}}
+	defer func() { StoreRoots = nil }()
+
+	lstDir, usersDir := routeList(&twitter.List{Id: 7}, "/root", "/root/users")
+	if lstDir != disk2 || usersDir != filepath.Join(disk2, "users") {
+		t.Errorf("routed list: %s, %s", lstDir, usersDir)
+	}
+	if lstDir, usersDir = routeList(&twitter.List{Id: 8}, "/root", "/root/users"); lstDir != "/root" || usersDir != "/root/users" {
+		t.Errorf("unrouted list: %s, %s", lstDir, usersDir)
+	}
+	if dir := routeUser(&twitter.User{Id: 1, ScreenName: "alice"}, usersDir); dir != filepath.Join(disk3, "users") {
+		t.Errorf("user by screen_name: %s", dir)
+	}
+	if dir := routeUser(&twitter.User{Id: 42, ScreenName: "bob"}, usersDir); dir != filepath.Join(disk3, "users") {
+		t.Errorf("user by id: %s", dir)
+	}
+	if dir := routeUser(&twitter.User{Id: 2, ScreenName: "carol"}, usersDir); dir != usersDir {
+		t.Errorf("unrouted user: %s", dir)
+	}
+
+	// 任务的存储根目录匹配全部目标
+	job := t.TempDir()
+	StoreRoots = append([]*StoreRoot{{Path: job, All: true}}, StoreRoots...)
+	if lstDir, usersDir := routeList(twitter.UserFollowing{}, "/root", "/root/users"); lstDir != job || usersDir != filepath.Join(job, "users") {
+		t.Errorf("list in job root: %s, %s", lstDir, usersDir)
+	}
+	if dir := routeUser(&twitter.User{Id: 42, ScreenName: "bob"}, "/root/users"); dir != filepath.Join(job, "users") {
+		t.Errorf("user in job root: %s", dir)
+	}
+	StoreRoots = StoreRoots[1:]
+
+	// 用户目录移至其他根目录后，链接指向新目录
+	old, moved := filepath.Join(disk2, "user"), filepath.Join(disk3, "user")
+	link := filepath.Join(disk2, "link")
+	for _, target := range []string{old, old, moved} {
+		if err := ensureSymlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := os.Readlink(link); got != target {
+			t.Errorf("link points to %s, want %s", got, target)
+		}
+	}
+	// 不替换普通文件
+	file := filepath.Join(disk2, "file")
+	if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureSymlink(moved, file); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Lstat(file); info.Mode()&os.ModeSymlink != 0 {
+		t.Error("regular file is replaced")
+	}
+}
+
+func TestRerouteUser(t *testing.T) {
+	root, disk2 := t.TempDir(), t.TempDir()
+	usersDir := filepath.Join(root, "users")
+	user := &twitter.User{Id: 9401, Name: "rerouted", ScreenName: "rerouted"}
+	released := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	old, err := syncRoutedUserEntity(db, user, usersDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.SetLatestReleaseTime(released); err != nil {
+		t.Fatal(err)
+	}
+
+	// 用户被路由至另一根目录后沿用原实体的最新发布时间
+	StoreRoots = []*StoreRoot{{Path: disk2, Users: []string{"rerouted"}}}
+	defer func() { StoreRoots = nil }()
+	for range 2 {
+		moved, err := syncRoutedUserEntity(db, user, usersDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moved.Id() == old.Id() || moved.record.ParentDir != filepath.Join(disk2, "users") {
+			t.Fatalf("entity is not created in the new root: %+v", moved.record)
+		}
+		if !moved.LatestReleaseTime().Equal(released) {
+			t.Errorf("latest release time = %v, want %v", moved.LatestReleaseTime(), released)
+		}
+	}
+}
+
+func TestBatchDownloadAnyOffline(t *testing.T) {
+	server := fake.New()
+	defer server.Close()
+	defer server.Install()()
+	ResetSyncState()
+
+	created := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	server.AddUser(
+		&fake.User{Id: 9001, ScreenName: "e2e_alice", Name: "Alice"},
+		&fake.User{Id: 9002, ScreenName: "e2e_bob", Name: "Bob", Protected: true},
+	)
+	server.AddTweet(
+		&fake.Tweet{Id: 90010, Author: 9001, CreatedAt: created, Media: []*fake.Media{{Type: "photo", Key: "e2e", Data: []byte("jpg")}}},
+		&fake.Tweet{Id: 90011, Author: 9001, CreatedAt: created.Add(time.Hour), Media: []*fake.Media{{Type: "video", Key: "e2e", Data: []byte("mp4")}}},
+	)
+	server.AddList(&fake.List{Id: 9100, Name: "e2e", Creator: 9001, Members: []uint64{9001, 9002}})
+	// 过载错误被重试
+	server.Fail("UserMedia", twitter.ErrOverCapacity, 1)
+
+	ctx := context.Background()
+	client, _, err := twitter.Login(ctx, "token", "ct0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetRetryWaitTime(time.Millisecond)
+	twitter.EnableRateLimit(client)
+	list, err := twitter.GetLst(ctx, client, 9100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	usersDir := filepath.Join(root, "users")
+	os.Mkdir(usersDir, 0755)
+	todump, err := BatchDownloadAny(ctx, client, db, []twitter.ListBase{list}, nil, root, usersDir, &BatchOptions{AutoFollow: true}, nil)
+	if err != nil || len(todump) != 0 {
+		t.Fatalf("failed tweets: %v, %v", todump, err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(usersDir, "Alice(e2e_alice)", "*"))
+	contents := []string{}
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		contents = append(contents, string(data))
+	}
+	if !reflect.DeepEqual(contents, []string{"jpg", "mp4"}) {
+		t.Errorf("downloaded %v: %q", files, contents)
+	}
+	if target, err := os.Readlink(filepath.Join(root, "e2e(9100)", "Alice(e2e_alice)")); err != nil || target != filepath.Join(usersDir, "Alice(e2e_alice)") {
+		t.Errorf("link to alice: %s, %v", target, err)
+	}
+	if follows := server.Follows(); !reflect.DeepEqual(follows, []uint64{9002}) {
+		t.Errorf("follows = %v", follows)
+	}
+}
+
+func TestSkippedTweetKeepsWatermark(t *testing.T) {
+	server := fake.New()
+	defer server.Close()
+	defer server.Install()()
+	ResetSyncState()
+
+	created := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	server.AddUser(&fake.User{Id: 9501, ScreenName: "skipped_author", Name: "Author"})
+	bad := &fake.Tweet{Id: 95011, Author: 9501, CreatedAt: created.Add(time.Hour), Malformed: true, Media: []*fake.Media{{Type: "photo", Key: "b", Data: []byte("b")}}}
+	server.AddTweet(
+		&fake.Tweet{Id: 95010, Author: 9501, CreatedAt: created, Media: []*fake.Media{{Type: "photo", Key: "a", Data: []byte("a")}}},
+		bad,
+		&fake.Tweet{Id: 95012, Author: 9501, CreatedAt: created.Add(2 * time.Hour), Media: []*fake.Media{{Type: "photo", Key: "c", Data: []byte("c")}}},
+	)
+
+	ctx := context.Background()
+	client, _, err := twitter.Login(ctx, "token", "ct0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := twitter.GetUserById(ctx, client, 9501)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usersDir := filepath.Join(t.TempDir(), "users")
+	download := func() []string {
+		ResetSyncState()
+		todump, err := BatchDownloadAny(ctx, client, db, nil, []*twitter.User{user}, filepath.Dir(usersDir), usersDir, &BatchOptions{}, nil)
+		if err != nil || len(todump) != 0 {
+			t.Fatalf("failed tweets: %v, %v", todump, err)
+		}
+		files, _ := filepath.Glob(filepath.Join(usersDir, "Author(skipped_author)", "*.jpg"))
+		contents := []string{}
+		for _, file := range files {
+			data, _ := os.ReadFile(file)
+			contents = append(contents, string(data))
+		}
+		slices.Sort(contents)
+		return contents
+	}
+
+	// 无法解析的推文被跳过，最新发布时间不被推进
+	if got := download(); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("downloaded %q", got)
+	}
+	record, err := database.LocateUserEntity(db, 9501, usersDir)
+	if err != nil || record == nil {
+		t.Fatal(record, err)
+	}
+	if record.LatestReleaseTime.Valid && !record.LatestReleaseTime.Time.IsZero() {
+		t.Errorf("latest release time is advanced to %v", record.LatestReleaseTime.Time)
+	}
+
+	// 可以解析后，下次同步时获取被跳过的推文
+	bad.Malformed = false
+	if got := download(); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("downloaded %q after the tweet can be parsed", got)
+	}
+}
+
+func TestDownloadLikesMarkRemoved(t *testing.T) {
+	server := fake.New()
+	defer server.Close()
+	defer server.Install()()
+	ResetSyncState()
+
+	created := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	liker := &fake.User{Id: 9201, ScreenName: "likes_liker", Name: "Liker", Likes: []uint64{92011, 92010}}
+	server.AddUser(liker, &fake.User{Id: 9202, ScreenName: "likes_author", Name: "Author"})
+	for i, key := range []string{"a", "b", "c"} {
+		server.AddTweet(&fake.Tweet{Id: uint64(92010 + i), Author: 9202, CreatedAt: created.Add(time.Duration(i) * time.Hour), Media: []*fake.Media{{Type: "photo", Key: key, Data: []byte(key)}}})
+	}
+
+	ctx := context.Background()
+	client, _, err := twitter.Login(ctx, "token", "ct0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := twitter.GetUserById(ctx, client, 9201)
+	if err != nil {
+		t.Fatal(err)
+	}
+	likesDir := t.TempDir()
+	dir := filepath.Join(likesDir, "Liker(likes_liker)")
+	download := func() []string {
+		if todump, err := DownloadLikes(ctx, client, db, user, "", likesDir, nil); err != nil || len(todump) != 0 {
+			t.Fatalf("failed tweets: %v, %v", todump, err)
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, "*.jpg"))
+		contents := []string{}
+		for _, file := range files {
+			data, _ := os.ReadFile(file)
+			contents = append(contents, string(data))
+		}
+		slices.Sort(contents)
+		return contents
+	}
+
+	if got := download(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("first run: %q", got)
+	}
+	// 最近一次喜欢的推文被取消喜欢，且已下载的 a 被删除：应在 a 处停止而不是重新遍历全部
+	liker.Likes = []uint64{92012, 92010}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jpg"))
+	for _, file := range files {
+		if data, _ := os.ReadFile(file); string(data) == "a" {
+			os.Remove(file)
+		}
+	}
+	if got := download(); !reflect.DeepEqual(got, []string{"b", "c"}) {
+		t.Errorf("second run: %q", got)
+	}
+}
+
+func TestBackfillAfterDedupe(t *testing.T) {
+	server := fake.New()
+	defer server.Close()
+	defer server.Install()()
+	defer func() { Dedupe = DM_NONE }()
+
+	ctx := context.Background()
+	client, _, err := twitter.Login(ctx, "token", "ct0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, mode := range []DedupeMode{DM_HARDLINK, DM_SYMLINK} {
+		uid := uint64(9601 + i)
+		data := []byte(fmt.Sprintf("same content %d", mode))
+		server.AddUser(&fake.User{Id: uid, ScreenName: fmt.Sprintf("dedupe_%d", uid), Name: "Dedupe"})
+		// 同名的两个推文内容相同，第二个被替换为指向第一个的链接
+		server.AddTweet(
+			&fake.Tweet{Id: uid * 10, Author: uid, Text: "same", CreatedAt: created, Media: []*fake.Media{{Type: "photo", Key: fmt.Sprint(uid * 10), Data: data}}},
+			&fake.Tweet{Id: uid*10 + 1, Author: uid, Text: "same", CreatedAt: created.Add(time.Hour), Media: []*fake.Media{{Type: "photo", Key: fmt.Sprint(uid*10 + 1), Data: data}}},
+		)
+		user, err := twitter.GetUserById(ctx, client, uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		usersDir := filepath.Join(t.TempDir(), "users")
+		dir := filepath.Join(usersDir, fmt.Sprintf("Dedupe(dedupe_%d)", uid))
+		Dedupe = mode
+		download := func(opts *BatchOptions) []string {
+			ResetSyncState()
+			todump, err := BatchDownloadAny(ctx, client, db, nil, []*twitter.User{user}, filepath.Dir(usersDir), usersDir, opts, nil)
+			if err != nil || len(todump) != 0 {
+				t.Fatalf("failed tweets: %v, %v", todump, err)
+			}
+			files, _ := filepath.Glob(filepath.Join(dir, "*.jpg"))
+			return files
+		}
+
+		first := download(&BatchOptions{})
+		if len(first) != 2 {
+			t.Fatalf("mode %d: downloaded %v", mode, first)
+		}
+		a, _ := os.Stat(first[0])
+		b, _ := os.Stat(first[1])
+		if !os.SameFile(a, b) {
+			t.Fatalf("mode %d: duplicate is not linked", mode)
+		}
+
+		// 模拟没有下载记录的旧存档：只能依文件的修改时间识别已下载的媒体
+		record, err := database.LocateUserEntity(db, uid, usersDir)
+		if err != nil || record == nil {
+			t.Fatal(record, err)
+		}
+		if _, err := db.Exec(`DELETE FROM tweet_media WHERE entity_id=?`, record.Id.Int32); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := download(&BatchOptions{Backfill: true}); !reflect.DeepEqual(got, first) {
+			t.Errorf("mode %d: backfill re-downloaded deduped media: %v", mode, got)
+		}
+	}
+}
+
+// 最新发布时间为 latest 的已创建的实体，不写入数据库
+func entityWithLatest(latest time.Time) *UserEntity {
+	record := &database.UserEntity{Uid: 1, Name: "user"}
+	if !latest.IsZero() {
+		record.LatestReleaseTime = sql.NullTime{Time: latest, Valid: true}
+	}
+	return &UserEntity{record: record, created: true}
+}
+
+func TestTimeRange(t *testing.T) {
+	watermark := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	before := watermark.Add(-24 * time.Hour)
+	after := watermark.Add(24 * time.Hour)
+
+	tests := []struct {
+		name   string
+		latest time.Time
+		opts   BatchOptions
+		want   *utils.TimeRange
+	}{
+		{"new entity", time.Time{}, BatchOptions{}, nil},
+		{"backfill new entity", time.Time{}, BatchOptions{Backfill: true}, nil},
+		{"incremental", watermark, BatchOptions{}, &utils.TimeRange{Min: watermark}},
+		{"backfill ignores watermark", watermark, BatchOptions{Backfill: true}, nil},
+		{"backfill with window", watermark, BatchOptions{Backfill: true, Since: before, Until: after}, &utils.TimeRange{Min: before, Max: after}},
+		{"since before watermark", watermark, BatchOptions{Since: before}, &utils.TimeRange{Min: watermark}},
+		{"since after watermark", watermark, BatchOptions{Since: after}, &utils.TimeRange{Min: after}},
+		{"until only", time.Time{}, BatchOptions{Until: before}, &utils.TimeRange{Max: before}},
+	}
+	for _, test := range tests {
+		got := test.opts.timeRange(entityWithLatest(test.latest))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: time range %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLatestReleaseTime(t *testing.T) {
+	watermark := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	older := []*twitter.Tweet{{Id: 2, CreatedAt: watermark.Add(-time.Hour)}, {Id: 1, CreatedAt: watermark.Add(-2 * time.Hour)}}
+	newer := []*twitter.Tweet{{Id: 4, CreatedAt: watermark.Add(2 * time.Hour)}, {Id: 3, CreatedAt: watermark.Add(time.Hour)}}
+
+	tests := []struct {
+		name   string
+		latest time.Time
+		tweets []*twitter.Tweet
+		want   time.Time
+	}{
+		// 补档获取的全部推文都早于最新发布时间，不应回退
+		{"backfill with watermark", watermark, older, watermark},
+		{"backfill new entity", time.Time{}, older, older[0].CreatedAt},
+		{"newer tweets", watermark, newer, newer[0].CreatedAt},
+		{"no tweets", watermark, nil, watermark},
+		{"no tweets on new entity", time.Time{}, nil, time.Time{}},
+	}
+	for _, test := range tests {
+		got := latestReleaseTime(entityWithLatest(test.latest), test.tweets)
+		if !got.Equal(test.want) {
+			t.Errorf("%s: latest release time %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestContinuous(t *testing.T) {
+	watermark := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		latest time.Time
+		since  time.Time
+		want   bool
+	}{
+		{time.Time{}, time.Time{}, true},
+		{watermark, time.Time{}, true},
+		{watermark, watermark, true},
+		{watermark, watermark.Add(-time.Hour), true},
+		{watermark, watermark.Add(time.Hour), false},
+		{time.Time{}, watermark, false},
+	}
+	for _, test := range tests {
+		opts := BatchOptions{Since: test.since}
+		if got := opts.continuous(entityWithLatest(test.latest)); got != test.want {
+			t.Errorf("latest %v, since %v: continuous = %v, want %v", test.latest, test.since, got, test.want)
+		}
+	}
+}
+
+func TestUpdateTweetStat(t *testing.T) {
+	tempdir := t.TempDir()
+	watermark := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	newer := []*twitter.Tweet{{Id: 2, CreatedAt: watermark.Add(2 * time.Hour)}, {Id: 1, CreatedAt: watermark.Add(time.Hour)}}
+
+	tests := []struct {
+		name       string
+		opts       BatchOptions
+		tweets     []*twitter.Tweet
+		wantLatest time.Time
+		wantCount  int32
+	}{
+		// 窗口与最新发布时间之间存在未获取的推文
+		{"since after watermark", BatchOptions{Since: watermark.Add(time.Hour)}, newer, watermark, 5},
+		// 没有获取 until 之后的推文，媒体数量不完整
+		{"until", BatchOptions{Until: watermark.Add(3 * time.Hour)}, newer, newer[0].CreatedAt, 5},
+		{"window joins watermark", BatchOptions{Since: watermark.Add(-time.Hour)}, newer, newer[0].CreatedAt, 8},
+		{"no window", BatchOptions{}, newer, newer[0].CreatedAt, 8},
+		{"zero tweets", BatchOptions{}, nil, watermark, 8},
+		{"zero tweets with until", BatchOptions{Until: watermark.Add(3 * time.Hour)}, nil, watermark, 5},
+	}
+	for i, test := range tests {
+		user := &twitter.User{Id: uint64(9700 + i), Name: "stat", ScreenName: fmt.Sprintf("stat_%d", i)}
+		entity, err := syncUserAndEntity(db, user, tempdir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := entity.SetLatestReleaseTime(watermark); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.UpdateUserEntityMediCount(db, entity.Id(), 5); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := updateTweetStat(db, entity, test.tweets, 8, &test.opts); err != nil {
+			t.Fatal(err)
+		}
+		record, err := database.LocateUserEntity(db, user.Id, tempdir)
+		if err != nil || record == nil {
+			t.Fatal(record, err)
+		}
+		if !record.LatestReleaseTime.Time.Equal(test.wantLatest) || record.MediaCount.Int32 != test.wantCount {
+			t.Errorf("%s: latest release time %v, media count %d, want %v, %d", test.name,
+				record.LatestReleaseTime.Time, record.MediaCount.Int32, test.wantLatest, test.wantCount)
+		}
+	}
+}
+
+func TestDownloadBookmarks(t *testing.T) {
+	server := fake.New()
+	defer server.Close()
+	defer server.Install()()
+	ResetSyncState()
+
+	created := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	owner := &fake.User{Id: 9801, ScreenName: "bookmarks_owner", Name: "Owner"}
+	server.AddUser(owner, &fake.User{Id: 9802, ScreenName: "bookmarks_author", Name: "Author"})
+	for i, key := range []string{"a", "b", "c"} {
+		server.AddTweet(&fake.Tweet{Id: uint64(98010 + i), Author: 9802, CreatedAt: created.Add(time.Duration(i) * time.Hour), Media: []*fake.Media{{Type: "photo", Key: key, Data: []byte(key)}}})
+	}
+	// 没有媒体的书签也被记录
+	server.AddTweet(&fake.Tweet{Id: 98013, Author: 9802, Text: "text only", CreatedAt: created})
+	server.Bookmarks = []uint64{98013, 98011, 98010}
+
+	ctx := context.Background()
+	client, _, err := twitter.Login(ctx, "token", "ct0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := twitter.GetUserById(ctx, client, 9801)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usersDir := t.TempDir()
+	dir := filepath.Join(usersDir, "Author(bookmarks_author)")
+	download := func() []string {
+		if todump, err := DownloadBookmarks(ctx, client, db, user, usersDir, nil); err != nil || len(todump) != 0 {
+			t.Fatalf("failed tweets: %v, %v", todump, err)
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, "*.jpg"))
+		contents := []string{}
+		for _, file := range files {
+			data, _ := os.ReadFile(file)
+			contents = append(contents, string(data))
+		}
+		slices.Sort(contents)
+		return contents
+	}
+
+	if got := download(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("first run: %q", got)
+	}
+	for _, id := range server.Bookmarks {
+		if recorded, err := database.HasBookmark(db, 9801, id); err != nil || !recorded {
+			t.Errorf("bookmark %d is not recorded: %v", id, err)
+		}
+	}
+
+	// 新的书签在前，在首个已记录的书签处停止：已删除的 a 不被重新下载，也不再请求后续页面
+	server.Bookmarks = append([]uint64{98012}, server.Bookmarks...)
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jpg"))
+	for _, file := range files {
+		if data, _ := os.ReadFile(file); string(data) == "a" {
+			os.Remove(file)
+		}
+	}
+	requests := server.Requests("Bookmarks")
+	if got := download(); !reflect.DeepEqual(got, []string{"b", "c"}) {
+		t.Errorf("second run: %q", got)
+	}
+	if n := server.Requests("Bookmarks") - requests; n != 1 {
+		t.Errorf("second run requested %d pages, want 1", n)
+	}
+}
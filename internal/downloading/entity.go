@@ -101,6 +101,9 @@ func (ue *UserEntity) Rename(title string) error {
 	if err != nil && !os.IsExist(err) {
 		return err
 	}
+	if err := database.MoveDedupedFiles(ue.db, old, newPath); err != nil {
+		return err
+	}
 
 	ue.record.Name = title
 	return database.UpdateUserEntity(ue.db, ue.record)
@@ -244,6 +247,23 @@ func (le *ListEntity) Recorded() bool {
 	return le.created
 }
 
+// 创建指向 target 的符号链接。已存在指向别处的符号链接时将其替换，
+// 如用户目录被移至其他存储根目录；已存在的不是符号链接的文件被保留
+func ensureSymlink(target string, linkpath string) error {
+	err := os.Symlink(target, linkpath)
+	if !os.IsExist(err) {
+		return err
+	}
+	current, err := os.Readlink(linkpath)
+	if err != nil || current == target {
+		return nil
+	}
+	if err := os.Remove(linkpath); err != nil {
+		return err
+	}
+	return os.Symlink(target, linkpath)
+}
+
 func updateUserLink(lnk *database.UserLink, db *sqlx.DB, path string) error {
 	name := filepath.Base(path)
 
@@ -257,12 +277,8 @@ func updateUserLink(lnk *database.UserLink, db *sqlx.DB, path string) error {
 	}
 
 	if lnk.Name == name {
-		// 用户未改名，但仍应确保链接存在
-		err = os.Symlink(path, linkpath)
-		if os.IsExist(err) {
-			err = nil
-		}
-		return err
+		// 用户未改名，但仍应确保链接存在且指向用户目录
+		return ensureSymlink(path, linkpath)
 	}
 
 	newlinkpath := filepath.Join(filepath.Dir(linkpath), name)
@@ -270,7 +286,7 @@ func updateUserLink(lnk *database.UserLink, db *sqlx.DB, path string) error {
 	if err = os.RemoveAll(linkpath); err != nil {
 		return err
 	}
-	if err = os.Symlink(path, newlinkpath); err != nil && !os.IsExist(err) {
+	if err = ensureSymlink(path, newlinkpath); err != nil {
 		return err
 	}
 
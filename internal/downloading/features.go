@@ -4,9 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -17,6 +22,7 @@ import (
 	"github.com/panjf2000/ants/v2"
 	log "github.com/sirupsen/logrus"
 	"github.com/unkmonster/tmd/internal/database"
+	"github.com/unkmonster/tmd/internal/hls"
 	"github.com/unkmonster/tmd/internal/twitter"
 	"github.com/unkmonster/tmd/internal/utils"
 )
@@ -41,44 +47,476 @@ func (pt TweetInDir) GetPath() string {
 
 var mutex sync.Mutex
 
-// 任何一个 url 下载失败直接返回
-// TODO: 要么全做，要么不做
-func downloadTweetMedia(ctx context.Context, client *resty.Client, dir string, tweet *twitter.Tweet) error {
-	text := utils.WinFileName(tweet.Text)
+// 推文所属用户实体的 id，不属于任何实体返回 0
+func entityIdOf(pt PackgedTweet) int {
+	switch v := pt.(type) {
+	case TweetInEntity:
+		return v.Entity.Id()
+	case *TweetInEntity:
+		return v.Entity.Id()
+	}
+	return 0
+}
 
-	for _, u := range tweet.Urls {
-		ext, err := utils.GetExtFromUrl(u)
+// 在数据库中记录推文
+func recordTweet(db *sqlx.DB, tweet *twitter.Tweet) error {
+	record := &database.Tweet{Id: tweet.Id, Text: tweet.Text, CreatedAt: tweet.CreatedAt}
+	if tweet.Creator != nil {
+		record.Uid = tweet.Creator.Id
+	}
+	return database.RecordTweet(db, record)
+}
+
+// 在数据库中记录媒体已下载至实体下的 path
+func recordTweetMedia(db *sqlx.DB, eid int, tweet *twitter.Tweet, url string, path string, size int) error {
+	record := &database.TweetMedia{TweetId: tweet.Id, EntityId: int32(eid), Url: url, Status: database.MS_DOWNLOADED}
+	record.Path.Scan(path)
+	record.Size.Scan(int64(size))
+	record.DownloadedAt.Scan(time.Now())
+	return database.RecordTweetMedia(db, record)
+}
+
+// 在数据库中记录媒体在实体下正在下载或下载失败
+func markTweetMedia(db *sqlx.DB, eid int, tweet *twitter.Tweet, url string, status database.MediaStatus) {
+	record := &database.TweetMedia{TweetId: tweet.Id, EntityId: int32(eid), Url: url, Status: status}
+	if err := database.RecordTweetMedia(db, record); err != nil {
+		log.WithField("tweet", tweet.Id).Warnln("failed to record media:", err)
+	}
+}
+
+// 根据数据库中的记录查找已下载至实体的媒体，返回其路径，未下载或文件已丢失返回空串
+func findRecordedMedia(db *sqlx.DB, eid int, tweet *twitter.Tweet, url string) (string, error) {
+	record, err := database.LocateTweetMedia(db, tweet.Id, eid, url)
+	if err != nil || record == nil || record.Status != database.MS_DOWNLOADED {
+		return "", err
+	}
+	exist, err := utils.PathExists(record.Path.String)
+	if err != nil || !exist {
+		return "", err
+	}
+	return record.Path.String, nil
+}
+
+// 查找以 path 或 path(n) 命名，且修改时间等于推文发布时间的文件，返回其路径，未找到返回空串
+// nth 是此媒体在推文中同扩展名媒体间的序号，用于区分同一推文的多个媒体。
+// 被去重替换为链接的文件使用 db 中记录的原修改时间，db 可为 nil
+func findMediaFile(db *sqlx.DB, tweet *twitter.Tweet, path string, nth int) (string, error) {
+	dir := filepath.Dir(path)
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(filepath.Base(path), ext)
+	for i := 0; ; i++ {
+		candidate := path
+		if i != 0 {
+			candidate = filepath.Join(dir, fmt.Sprintf("%s(%d)%s", stem, i, ext))
+		}
+		info, err := os.Stat(candidate)
+		if os.IsNotExist(err) {
+			return "", nil
+		}
 		if err != nil {
-			return err
+			return "", err
+		}
+		modTime := info.ModTime()
+		if modTime.Unix() != tweet.CreatedAt.Unix() && db != nil {
+			if recorded, err := database.GetDedupedFileTime(db, candidate); err != nil {
+				return "", err
+			} else if !recorded.IsZero() {
+				modTime = recorded
+			}
+		}
+		if modTime.Unix() != tweet.CreatedAt.Unix() {
+			continue
 		}
+		if nth == 0 {
+			return candidate, nil
+		}
+		nth--
+	}
+}
+
+func fileSize(path string) int {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return int(info.Size())
+}
 
-		// 请求
-		resp, err := client.R().SetContext(ctx).SetQueryParam("name", "4096x4096").Get(u)
+// 下载推文的媒体时的选项，为 nil 或零值时下载全部媒体
+type MediaOptions struct {
+	// 需要下载的媒体类型，为空则下载全部类型
+	Types []twitter.MediaType
+	// 媒体文件名模板，为 nil 使用 MediaNameTemplate
+	NameTemplate *utils.NameTemplate
+}
+
+func (opts *MediaOptions) want(media *twitter.Media) bool {
+	return opts == nil || len(opts.Types) == 0 || slices.Contains(opts.Types, media.Type)
+}
+
+func (opts *MediaOptions) nameTemplate() *utils.NameTemplate {
+	if opts == nil || opts.NameTemplate == nil {
+		return MediaNameTemplate
+	}
+	return opts.NameTemplate
+}
+
+// 下载视频时选择变体的画质策略
+var VideoQuality twitter.VideoQuality
+
+// 媒体的下载链接，视频按画质策略选择变体
+func mediaUrl(media *twitter.Media) string {
+	if variant := media.SelectVariant(VideoQuality); variant != nil {
+		return variant.Url
+	}
+	return media.Url
+}
+
+// 同时下载的 HLS 分段数
+const hlsConcurrency = 8
+
+// 媒体的下载方式，HLS 媒体需要先获取播放列表才能确定扩展名
+type mediaSource struct {
+	ext string
+	// 下载至临时文件，返回文件的大小
+	fetch func(part string) (int64, error)
+	// 视频的独立音轨，与视频同名保存
+	audio *mediaSource
+}
+
+// 下载 HLS 媒体播放列表的全部分段至临时文件
+func hlsSource(ctx context.Context, client *resty.Client, pl *hls.Playlist, ext string) *mediaSource {
+	fetch := func(part string) (int64, error) {
+		file, err := os.Create(part)
 		if err != nil {
-			return err
+			return 0, err
+		}
+		defer file.Close()
+
+		size, err := pl.Download(ctx, client, file, hlsConcurrency)
+		if err != nil {
+			return 0, err
+		}
+		return size, file.Sync()
+	}
+	return &mediaSource{ext: ext, fetch: fetch}
+}
+
+func openMedia(ctx context.Context, client *resty.Client, media *twitter.Media, u string) (*mediaSource, error) {
+	ext, err := utils.GetExtFromUrl(u)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist := u
+	if media.Type == twitter.MT_SPACE {
+		playlist, err = twitter.GetSpacePlaylist(ctx, client, media.SpaceId())
+		if err != nil {
+			return nil, err
+		}
+	} else if ext != ".m3u8" {
+		fetch := func(part string) (int64, error) {
+			return downloadFile(ctx, client, u, part)
+		}
+		return &mediaSource{ext: ext, fetch: fetch}, nil
+	}
+
+	pl, err := hls.Fetch(ctx, client, playlist)
+	if err != nil {
+		return nil, err
+	}
+	ext = pl.Ext()
+	if media.Type == twitter.MT_SPACE && ext == ".mp4" {
+		ext = ".m4a"
+	}
+	src := hlsSource(ctx, client, pl, ext)
+	if pl.Audio != nil {
+		ext := pl.Audio.Ext()
+		if ext == ".mp4" {
+			ext = ".m4a"
+		}
+		src.audio = hlsSource(ctx, client, pl.Audio, ext)
+	}
+	return src, nil
+}
+
+// 流式下载 u 至临时文件 part，part 已存在时使用 Range 请求续传，服务器不支持续传时重新下载
+func downloadFile(ctx context.Context, client *resty.Client, u string, part string) (int64, error) {
+	file, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	req := client.R().SetContext(ctx).SetQueryParam("name", "4096x4096").SetDoNotParseResponse(true)
+	if offset > 0 {
+		req.SetHeader("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := req.Get(u)
+	if resp != nil && resp.RawBody() != nil {
+		defer resp.RawBody().Close()
+	}
+	if err == nil {
+		err = utils.CheckRespStatus(resp)
+	}
+	if offset > 0 && utils.IsStatusCode(err, http.StatusRequestedRangeNotSatisfiable) {
+		// 临时文件不小于媒体，无法确定其是否完整
+		if err := file.Truncate(0); err != nil {
+			return 0, err
+		}
+		return downloadFile(ctx, client, u, part)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	resumed := resp.StatusCode() == http.StatusPartialContent &&
+		strings.HasPrefix(resp.Header().Get("Content-Range"), fmt.Sprintf("bytes %d-", offset))
+	if !resumed && offset > 0 {
+		offset = 0
+		if err := file.Truncate(0); err != nil {
+			return 0, err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := io.Copy(file, resp.RawBody())
+	if err != nil {
+		return 0, err
+	}
+	return offset + n, file.Sync()
+}
+
+// 媒体的临时文件，由推文 id 和链接确定，中断后再次下载同一媒体时可以续传
+func partPath(dir string, tweet *twitter.Tweet, u string) string {
+	h := fnv.New32a()
+	h.Write([]byte(u))
+	return filepath.Join(dir, fmt.Sprintf(".%d-%08x.part", tweet.Id, h.Sum32()))
+}
+
+// 已下载至临时文件，等待移动到最终路径的媒体
+type fetchedMedia struct {
+	url  string
+	part string
+	name string
+	size int64
+}
+
+// 视频独立音轨的临时文件
+func audioPartPath(part string) string {
+	return strings.TrimSuffix(part, ".part") + ".audio.part"
+}
+
+// 下载媒体至临时文件，视频有独立音轨时音轨作为第二个媒体返回
+func fetchMedia(ctx context.Context, client *resty.Client, media *twitter.Media, u string, part string, nameOf func(ext string) string) ([]*fetchedMedia, error) {
+	src, err := openMedia(ctx, client, media, u)
+	if err != nil {
+		return nil, err
+	}
+	size, err := src.fetch(part)
+	if err != nil {
+		return nil, err
+	}
+	fetched := []*fetchedMedia{{url: u, part: part, name: nameOf(src.ext), size: size}}
+
+	if src.audio != nil {
+		audioPart := audioPartPath(part)
+		size, err := src.audio.fetch(audioPart)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download audio: %v", err)
+		}
+		fetched = append(fetched, &fetchedMedia{url: u + "#audio", part: audioPart, name: nameOf(src.audio.ext), size: size})
+	}
+	return fetched, nil
+}
+
+// 测试中替换以模拟移动失败
+var rename = os.Rename
+
+// 将临时文件依次移动到 dir 下不重复的最终路径，修改时间设为 mtime，返回最终路径。
+// 任何一个失败时已移动的文件被移回临时文件（无法移回则删除），不会留下部分媒体
+func commitMedia(dir string, fetched []*fetchedMedia, mtime time.Time) ([]string, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// 先设置全部临时文件的修改时间，findMediaFile 依此识别已下载的媒体
+	for _, f := range fetched {
+		if err := os.Chtimes(f.part, time.Time{}, mtime); err != nil {
+			return nil, err
 		}
+	}
 
-		mutex.Lock()
-		path, err := utils.UniquePath(filepath.Join(dir, text+ext))
+	paths := make([]string, 0, len(fetched))
+	rollback := func() {
+		for i, path := range paths {
+			if err := rename(path, fetched[i].part); err != nil {
+				os.Remove(path)
+			}
+		}
+	}
+	for _, f := range fetched {
+		path, err := utils.UniquePath(filepath.Join(dir, f.name))
 		if err != nil {
-			mutex.Unlock()
+			rollback()
+			return nil, err
+		}
+		if err := rename(f.part, path); err != nil {
+			rollback()
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// 不会被重试的下载错误，403 通常是媒体被 DMCA
+func isPermanent(err error) bool {
+	return utils.IsStatusCode(err, 404) || utils.IsStatusCode(err, 403)
+}
+
+// 删除媒体的临时文件
+func removeParts(parts ...string) {
+	for _, part := range parts {
+		if err := os.Remove(part); err != nil && !os.IsNotExist(err) {
+			log.WithField("part", part).Warnln("failed to remove part file:", err)
+		}
+	}
+}
+
+// 推文的媒体要么全部下载，要么都不下载：所有媒体先下载至临时文件，全部成功后才移动到最终路径。
+// 任何一个媒体下载或提交失败直接返回，不写入元数据文件和下载记录；临时文件被保留以便续传，
+// 错误不会被重试时则被删除。已下载的媒体将被跳过
+// 下载情况记录至数据库，db 为空或 eid 为 0 时不记录
+func downloadTweetMedia(ctx context.Context, client *resty.Client, db *sqlx.DB, eid int, dir string, tweet *twitter.Tweet, opts *MediaOptions) error {
+	text := utils.WinFileName(tweet.Text)
+	ledger := db != nil && eid != 0
+	nthByName := make(map[string]int)
+	fetched := []*fetchedMedia{}
+
+	if ledger {
+		if err := recordTweet(db, tweet); err != nil {
 			return err
 		}
-		file, err := os.Create(path)
-		mutex.Unlock()
+	}
+
+	for i := range tweet.Media {
+		u := mediaUrl(&tweet.Media[i])
+		ext, err := utils.GetExtFromUrl(u)
 		if err != nil {
 			return err
 		}
+		nameOf := func(ext string) string {
+			return mediaName(opts.nameTemplate(), tweet, i, ext)
+		}
+		// 同名媒体间的序号
+		name := nameOf(ext)
+		nth := nthByName[name]
+		nthByName[name]++
+		if !opts.want(&tweet.Media[i]) {
+			continue
+		}
 
-		defer os.Chtimes(path, time.Time{}, tweet.CreatedAt)
-		defer file.Close()
+		// 跳过已存在的媒体
+		if ledger {
+			exist, err := findRecordedMedia(db, eid, tweet, u)
+			if err != nil {
+				return err
+			}
+			if exist != "" {
+				continue
+			}
+		}
+		exist, err := findMediaFile(db, tweet, filepath.Join(dir, name), nth)
+		if err != nil {
+			return err
+		}
+		if exist != "" {
+			if ledger {
+				if err := recordTweetMedia(db, eid, tweet, u, exist, fileSize(exist)); err != nil {
+					log.WithField("tweet", tweet.Id).Warnln("failed to record media:", err)
+				}
+			}
+			continue
+		}
 
-		_, err = file.Write(resp.Body())
+		if ledger {
+			markTweetMedia(db, eid, tweet, u, database.MS_PENDING)
+		}
+		part := partPath(dir, tweet, u)
+		f, err := fetchMedia(ctx, client, &tweet.Media[i], u, part, nameOf)
 		if err != nil {
+			if isPermanent(err) {
+				removeParts(part, audioPartPath(part))
+				for _, f := range fetched {
+					removeParts(f.part)
+				}
+			}
+			if ledger {
+				markTweetMedia(db, eid, tweet, u, database.MS_FAILED)
+				// 已下载至临时文件的媒体没有被提交
+				for _, f := range fetched {
+					markTweetMedia(db, eid, tweet, f.url, database.MS_FAILED)
+				}
+			}
 			return err
 		}
+		fetched = append(fetched, f...)
+	}
+
+	if len(fetched) == 0 {
+		if Sidecar {
+			if err := writeSidecar(dir, tweet, false); err != nil {
+				log.WithField("tweet", tweet.Id).Warnln("failed to write sidecar:", err)
+			}
+		}
+		return nil
+	}
+	paths, err := commitMedia(dir, fetched, tweet.CreatedAt)
+	if err != nil {
+		if ledger {
+			for _, f := range fetched {
+				markTweetMedia(db, eid, tweet, f.url, database.MS_FAILED)
+			}
+		}
+		return err
+	}
+
+	if Sidecar {
+		if err := writeSidecar(dir, tweet, true); err != nil {
+			log.WithField("tweet", tweet.Id).Warnln("failed to write sidecar:", err)
+		}
+	}
+	if EmbedMetadata {
+		for i, path := range paths {
+			if err := embedMetadata(path, tweet); err != nil {
+				log.WithField("tweet", tweet.Id).Warnln("failed to embed metadata:", err)
+				continue
+			}
+			fetched[i].size = int64(fileSize(path))
+		}
+	}
+	if ledger {
+		for i, path := range paths {
+			if err := recordTweetMedia(db, eid, tweet, fetched[i].url, path, int(fetched[i].size)); err != nil {
+				log.WithField("tweet", tweet.Id).Warnln("failed to record media:", err)
+			}
+		}
 	}
 
+	if Dedupe != DM_NONE && db != nil {
+		for _, path := range paths {
+			if _, err := dedupeFile(db, path, Dedupe); err != nil {
+				log.WithField("tweet", tweet.Id).Warnln("failed to dedupe media:", err)
+			}
+		}
+	}
 	fmt.Printf("%s %s\n", color.FgLightMagenta.Render("["+tweet.Creator.Title()+"]"), text)
 	return nil
 }
@@ -92,10 +530,22 @@ func init() {
 	MaxDownloadRoutine = min(100, runtime.GOMAXPROCS(0)*10)
 }
 
+// 忘记本次运行已同步过的用户，列表成员和作者目录，常驻运行时在每轮同步前调用
+func ResetSyncState() {
+	for _, m := range []*sync.Map{&syncedUsers, &syncedListUsers, &authorEntities} {
+		m.Range(func(key, value any) bool {
+			m.Delete(key)
+			return true
+		})
+	}
+}
+
 type workerConfig struct {
 	ctx    context.Context
 	wg     *sync.WaitGroup
 	cancel context.CancelCauseFunc
+	db     *sqlx.DB
+	media  *MediaOptions
 }
 
 // 负责下载推文，保证 tweet chan 内的推文要么下载成功，要么推送至 error chan
@@ -137,9 +587,8 @@ func tweetDownloader(client *resty.Client, config *workerConfig, errch chan<- Pa
 			errch <- pt
 			continue
 		}
-		err := downloadTweetMedia(config.ctx, client, path, pt.GetTweet())
-		// 403: Dmcaed
-		if err != nil && !utils.IsStatusCode(err, 404) && !utils.IsStatusCode(err, 403) {
+		err := downloadTweetMedia(config.ctx, client, config.db, entityIdOf(pt), path, pt.GetTweet(), config.media)
+		if err != nil && !isPermanent(err) {
 			errch <- pt
 		}
 
@@ -151,7 +600,7 @@ func tweetDownloader(client *resty.Client, config *workerConfig, errch chan<- Pa
 }
 
 // 批量下载推文并返回下载失败的推文，可以保证推文被成功下载或被返回
-func BatchDownloadTweet(ctx context.Context, client *resty.Client, pts ...PackgedTweet) []PackgedTweet {
+func BatchDownloadTweet(ctx context.Context, client *resty.Client, db *sqlx.DB, opts *MediaOptions, pts ...PackgedTweet) []PackgedTweet {
 	if len(pts) == 0 {
 		return nil
 	}
@@ -172,6 +621,8 @@ func BatchDownloadTweet(ctx context.Context, client *resty.Client, pts ...Packge
 		ctx:    ctx,
 		cancel: cancel,
 		wg:     &wg,
+		db:     db,
+		media:  opts,
 	}
 	for i := 0; i < numRoutine; i++ {
 		wg.Add(1)
@@ -226,52 +677,205 @@ func syncUser(db *sqlx.DB, user *twitter.User) error {
 	return err
 }
 
-func getTweetAndUpdateLatestReleaseTime(ctx context.Context, client *resty.Client, user *twitter.User, entity *UserEntity) ([]*twitter.Tweet, error) {
-	tweets, err := user.GetMeidas(ctx, client, &utils.TimeRange{Min: entity.LatestReleaseTime()})
+// 转推和引用推文的处理方式
+type RepostPolicy int
+
+const (
+	RP_DEFAULT RepostPolicy = iota // 转推保存至转推者的目录，跳过引用的推文，与命令行的默认值一致
+	RP_SELF                        // 保存至转推者或引用者的目录
+	RP_SKIP                        // 跳过
+	RP_AUTHOR                      // 保存至原作者的目录
+)
+
+// 解析处理方式 skip, author 或 retweeter/quoter (self)
+func ParseRepostPolicy(str string) (RepostPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(str)) {
+	case "self", "retweeter", "quoter":
+		return RP_SELF, nil
+	case "skip":
+		return RP_SKIP, nil
+	case "author":
+		return RP_AUTHOR, nil
+	}
+	return RP_SKIP, fmt.Errorf("invalid retweet/quote policy: %s", str)
+}
+
+// 批量下载用户时的可选行为
+type BatchOptions struct {
+	// 自动关注受保护的用户
+	AutoFollow bool
+	// 忽略用户的最新发布时间，重新获取用户的完整时间线，已下载的媒体将被跳过
+	Backfill bool
+	// 仅获取在此时间范围内发布的推文，零值表示不限制
+	Since time.Time
+	Until time.Time
+	// 用户时间线上的转推和引用的推文的处理方式
+	Retweets RepostPolicy
+	Quotes   RepostPolicy
+	// 下载推文媒体时的选项
+	Media MediaOptions
+}
+
+// map[dir/user_id]*UserEntity 本次运行中因转推或引用同步过的原作者实体
+var authorEntities sync.Map
+var authorMutex sync.Mutex
+
+func authorEntity(db *sqlx.DB, author *twitter.User, dir string) (*UserEntity, error) {
+	key := fmt.Sprintf("%s/%d", dir, author.Id)
+	if entity, ok := authorEntities.Load(key); ok {
+		return entity.(*UserEntity), nil
+	}
+
+	authorMutex.Lock()
+	defer authorMutex.Unlock()
+	if entity, ok := authorEntities.Load(key); ok {
+		return entity.(*UserEntity), nil
+	}
+	entity, err := syncRoutedUserEntity(db, author, dir)
+	if err != nil {
+		return nil, err
+	}
+	authorEntities.Store(key, entity)
+	return entity, nil
+}
+
+// 按转推和引用的处理方式将用户时间线上的推文打包，原作者的实体位于 dir 下
+func (opts *BatchOptions) packTweets(db *sqlx.DB, tweets []*twitter.Tweet, entity *UserEntity, dir string) []*TweetInEntity {
+	pts := make([]*TweetInEntity, 0, len(tweets))
+	pack := func(tw *twitter.Tweet, policy RepostPolicy) {
+		if tw == nil || len(tw.Media) == 0 || policy == RP_SKIP {
+			return
+		}
+		if policy == RP_SELF || tw.Creator == nil || tw.Creator.Id == entity.Uid() {
+			pts = append(pts, &TweetInEntity{Tweet: tw, Entity: entity})
+			return
+		}
+
+		author, err := authorEntity(db, tw.Creator, dir)
+		if err != nil {
+			log.WithField("user", tw.Creator.Title()).Warnln("failed to update user or entity", err)
+			return
+		}
+		pts = append(pts, &TweetInEntity{Tweet: tw, Entity: author})
+	}
+
+	retweets, quotes := opts.Retweets, opts.Quotes
+	if retweets == RP_DEFAULT {
+		retweets = RP_SELF
+	}
+	if quotes == RP_DEFAULT {
+		quotes = RP_SKIP
+	}
+	for _, tw := range tweets {
+		if tw.Retweeted != nil {
+			pack(tw.Retweeted, retweets)
+			continue
+		}
+		pack(tw, RP_SELF)
+		pack(tw.Quoted, quotes)
+	}
+	return pts
+}
+
+// 获取用户推文时使用的时间范围
+func (opts *BatchOptions) timeRange(entity *UserEntity) *utils.TimeRange {
+	tr := utils.TimeRange{Min: opts.Since, Max: opts.Until}
+	if !opts.Backfill && entity.LatestReleaseTime().After(tr.Min) {
+		tr.Min = entity.LatestReleaseTime()
+	}
+	if tr.Min.IsZero() && tr.Max.IsZero() {
+		return nil
+	}
+	return &tr
+}
+
+// 获取的推文是否紧接实体已记录的最新发布时间。
+// 否则两者之间存在未获取的推文，不能更新实体的最新发布时间
+func (opts *BatchOptions) continuous(entity *UserEntity) bool {
+	return !opts.Since.After(entity.LatestReleaseTime())
+}
+
+// 获取推文后更新实体记录的推文状态，有时间窗口时保证不会遗漏窗口之外的推文
+func updateTweetStat(db *sqlx.DB, entity *UserEntity, tweets []*twitter.Tweet, mediaCount int, opts *BatchOptions) error {
+	if !opts.continuous(entity) {
+		return nil
+	}
+	if !opts.Until.IsZero() {
+		// 没有获取 until 之后的推文，仅推进最新发布时间
+		return entity.SetLatestReleaseTime(latestReleaseTime(entity, tweets))
+	}
+
+	if len(tweets) == 0 {
+		return database.UpdateUserEntityMediCount(db, entity.Id(), mediaCount)
+	}
+	return database.UpdateUserEntityTweetStat(db, entity.Id(), latestReleaseTime(entity, tweets), mediaCount)
+}
+
+// 获取推文后实体应记录的最新发布时间，保证不会回退
+func latestReleaseTime(entity *UserEntity, tweets []*twitter.Tweet) time.Time {
+	latest := entity.LatestReleaseTime()
+	if len(tweets) != 0 && tweets[0].CreatedAt.After(latest) {
+		latest = tweets[0].CreatedAt
+	}
+	return latest
+}
+
+func getTweetAndUpdateLatestReleaseTime(ctx context.Context, client *resty.Client, user *twitter.User, entity *UserEntity, opts *BatchOptions) ([]*twitter.Tweet, error) {
+	tweets, skipped, err := user.GetMeidas(ctx, client, opts.timeRange(entity))
 	if err != nil || len(tweets) == 0 {
 		return nil, err
 	}
-	if err := entity.SetLatestReleaseTime(tweets[0].CreatedAt); err != nil {
+	if !opts.continuous(entity) {
+		return tweets, nil
+	}
+	if skipped != 0 {
+		log.WithField("user", user.Title()).Warnf("%d tweets are skipped, keep the latest release time", skipped)
+		return tweets, nil
+	}
+	if err := entity.SetLatestReleaseTime(latestReleaseTime(entity, tweets)); err != nil {
 		return nil, err
 	}
 	return tweets, nil
 }
 
-func DownloadUser(ctx context.Context, db *sqlx.DB, client *resty.Client, user *twitter.User, dir string) ([]PackgedTweet, error) {
+func DownloadUser(ctx context.Context, db *sqlx.DB, client *resty.Client, user *twitter.User, dir string, opts *BatchOptions) ([]PackgedTweet, error) {
 	if user.Blocking || user.Muting {
 		return nil, nil
 	}
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
 
 	_, loaded := syncedUsers.Load(user.Id)
 	if loaded {
 		log.WithField("user", user.Title()).Debugln("skiped downloaded user")
 		return nil, nil
 	}
-	entity, err := syncUserAndEntity(db, user, dir)
+	entity, err := syncRoutedUserEntity(db, user, dir)
 	if err != nil {
 		return nil, err
 	}
 
 	syncedUsers.Store(user.Id, entity)
-	tweets, err := getTweetAndUpdateLatestReleaseTime(ctx, client, user, entity)
+	tweets, err := getTweetAndUpdateLatestReleaseTime(ctx, client, user, entity, opts)
 	if err != nil || len(tweets) == 0 {
 		return nil, err
 	}
 
 	// 打包推文
 	pts := make([]PackgedTweet, 0, len(tweets))
-	for _, tw := range tweets {
-		pts = append(pts, TweetInEntity{Tweet: tw, Entity: entity})
+	for _, pt := range opts.packTweets(db, tweets, entity, dir) {
+		pts = append(pts, pt)
 	}
 
-	return BatchDownloadTweet(ctx, client, pts...), nil
+	return BatchDownloadTweet(ctx, client, db, &opts.Media, pts...), nil
 }
 
 func syncUserAndEntity(db *sqlx.DB, user *twitter.User, dir string) (*UserEntity, error) {
 	if err := syncUser(db, user); err != nil {
 		return nil, err
 	}
-	expectedTitle := utils.WinFileName(user.Title())
+	expectedTitle := userDirName(user)
 
 	entity, err := NewUserEntity(db, user.Id, dir)
 	if err != nil {
@@ -283,6 +887,139 @@ func syncUserAndEntity(db *sqlx.DB, user *twitter.User, dir string) (*UserEntity
 	return entity, nil
 }
 
+// 将推文打包至其作者在 dir 下的实体，忽略没有媒体或无法确定作者的推文
+func packTweetsByCreator(db *sqlx.DB, tweets []*twitter.Tweet, dir string) []PackgedTweet {
+	entities := make(map[uint64]*UserEntity)
+	pts := make([]PackgedTweet, 0, len(tweets))
+
+	for _, tw := range tweets {
+		if len(tw.Media) == 0 {
+			continue
+		}
+		if tw.Creator == nil {
+			log.WithField("tweet", tw.Id).Warnln("unable to determine the creator of tweet")
+			continue
+		}
+
+		entity, ok := entities[tw.Creator.Id]
+		if !ok {
+			var err error
+			entity, err = syncRoutedUserEntity(db, tw.Creator, dir)
+			if err != nil {
+				log.WithField("user", tw.Creator.Title()).Warnln("failed to update user or entity", err)
+				continue
+			}
+			entities[tw.Creator.Id] = entity
+		}
+		pts = append(pts, &TweetInEntity{Tweet: tw, Entity: entity})
+	}
+	return pts
+}
+
+// 记录的最近喜欢的推文数。只要其中任一推文仍在喜欢列表中，就不必重新遍历全部喜欢的推文
+const likeMarkCount = 20
+
+// 下载用户自上次下载后喜欢的推文。
+// likesDir 为空时推文存放至各自作者在 dir 下的目录，否则存放至 likesDir 下以喜欢者命名的目录
+func DownloadLikes(ctx context.Context, client *resty.Client, db *sqlx.DB, liker *twitter.User, dir string, likesDir string, opts *MediaOptions) ([]*TweetInEntity, error) {
+	if err := syncUser(db, liker); err != nil {
+		return nil, err
+	}
+	marks, err := database.GetLikeMarks(db, liker.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	tweets, skipped, err := liker.GetLikes(ctx, client, marks)
+	if err != nil || len(tweets) == 0 {
+		return nil, err
+	}
+
+	var pts []PackgedTweet
+	if likesDir == "" {
+		pts = packTweetsByCreator(db, tweets, dir)
+	} else {
+		entity, err := syncUserAndEntity(db, liker, likesDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, tw := range tweets {
+			if len(tw.Media) != 0 {
+				pts = append(pts, &TweetInEntity{Tweet: tw, Entity: entity})
+			}
+		}
+	}
+
+	// 新的推文在前，不足时保留旧的记录。有条目无法解析时保留旧的记录，下次重新获取这些条目
+	if skipped == 0 {
+		newMarks := make([]uint64, 0, likeMarkCount)
+		for _, tw := range tweets[:min(len(tweets), likeMarkCount)] {
+			newMarks = append(newMarks, tw.Id)
+		}
+		for _, id := range marks {
+			if len(newMarks) == likeMarkCount {
+				break
+			}
+			if !slices.Contains(newMarks, id) {
+				newMarks = append(newMarks, id)
+			}
+		}
+		if err := database.SetLikeMarks(db, liker.Id, newMarks); err != nil {
+			return nil, err
+		}
+	} else {
+		log.WithField("user", liker.Title()).Warnf("%d liked tweets are skipped, keep the last marks", skipped)
+	}
+	log.WithField("user", liker.Title()).Debugln("new liked tweets:", len(pts))
+	return batchDownloadTweetInEntity(ctx, client, db, pts, opts), nil
+}
+
+// 下载 owner 的书签中未被记录过的推文，推文存放至各自作者在 dir 下的目录
+func DownloadBookmarks(ctx context.Context, client *resty.Client, db *sqlx.DB, owner *twitter.User, dir string, opts *MediaOptions) ([]*TweetInEntity, error) {
+	if err := syncUser(db, owner); err != nil {
+		return nil, err
+	}
+
+	// 书签按添加的时间逆序，在首个已记录的书签处停止
+	recorded := func(tw *twitter.Tweet) (bool, error) {
+		return database.HasBookmark(db, owner.Id, tw.Id)
+	}
+	tweets, skipped, err := twitter.GetBookmarks(ctx, client, recorded)
+	if err != nil || len(tweets) == 0 {
+		return nil, err
+	}
+
+	// 记录获取到的全部书签，下载失败的推文会被转储，无需再次获取。
+	// 有条目无法解析时不记录，否则下次会在更新的书签处停止而遗漏这些条目
+	if skipped == 0 {
+		for _, tw := range tweets {
+			if err := database.CreateBookmark(db, owner.Id, tw.Id); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		log.WithField("user", owner.Title()).Warnf("%d bookmarks are skipped, do not record the new bookmarks", skipped)
+	}
+	pts := packTweetsByCreator(db, tweets, dir)
+	log.WithField("user", owner.Title()).Debugln("new bookmarks:", len(pts))
+	return batchDownloadTweetInEntity(ctx, client, db, pts, opts), nil
+}
+
+// 下载指定的推文，推文存放至各自作者在 dir 下的目录
+func DownloadTweets(ctx context.Context, client *resty.Client, db *sqlx.DB, tweets []*twitter.Tweet, dir string, opts *MediaOptions) []*TweetInEntity {
+	pts := packTweetsByCreator(db, tweets, dir)
+	return batchDownloadTweetInEntity(ctx, client, db, pts, opts)
+}
+
+// 下载打包至用户实体的推文，返回下载失败的推文
+func batchDownloadTweetInEntity(ctx context.Context, client *resty.Client, db *sqlx.DB, pts []PackgedTweet, opts *MediaOptions) []*TweetInEntity {
+	fails := []*TweetInEntity{}
+	for _, pt := range BatchDownloadTweet(ctx, client, db, opts, pts...) {
+		fails = append(fails, pt.(*TweetInEntity))
+	}
+	return fails
+}
+
 type TweetInEntity struct {
 	Tweet  *twitter.Tweet
 	Entity *UserEntity
@@ -326,16 +1063,20 @@ func calcUserDepth(exist int, total int) int {
 type userInLstEntity struct {
 	user *twitter.User
 	leid *int
+	dir  string // 用户目录所在的目录，为空使用 BatchUserDownload 的 dir
 }
 
 func shouldIngoreUser(user *twitter.User) bool {
 	return user.Blocking || user.Muting
 }
 
-func BatchUserDownload(ctx context.Context, client *resty.Client, db *sqlx.DB, users []userInLstEntity, dir string, autoFollow bool, additional []*resty.Client) ([]*TweetInEntity, error) {
+func BatchUserDownload(ctx context.Context, client *resty.Client, db *sqlx.DB, users []userInLstEntity, dir string, opts *BatchOptions, additional []*resty.Client) ([]*TweetInEntity, error) {
 	if len(users) == 0 {
 		return nil, nil
 	}
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
 
 	uidToUser := make(map[uint64]*twitter.User)
 	for _, u := range users {
@@ -396,9 +1137,14 @@ func BatchUserDownload(ctx context.Context, client *resty.Client, db *sqlx.DB, u
 				continue
 			}
 
+			// 用户同时属于多个列表时，其目录位于首次出现时所在的目录
 			pe, loaded := syncedUsers.Load(user.Id)
 			if !loaded {
-				pathEntity, err = syncUserAndEntity(db, user, dir)
+				userDir := dir
+				if userInLST.dir != "" {
+					userDir = userInLST.dir
+				}
+				pathEntity, err = syncRoutedUserEntity(db, user, userDir, dir)
 				if err != nil {
 					updaterLogger.WithField("user", user.Title()).Warnln("failed to update user or entity", err)
 					continue
@@ -422,14 +1168,18 @@ func BatchUserDownload(ctx context.Context, client *resty.Client, db *sqlx.DB, u
 
 				// 计算深度
 				if user.MediaCount != 0 && user.IsVisiable() {
-					missingTweets += max(0, user.MediaCount-int(pathEntity.record.MediaCount.Int32))
-					depthByEntity[pathEntity] = calcUserDepth(int(pathEntity.record.MediaCount.Int32), user.MediaCount)
+					exist := int(pathEntity.record.MediaCount.Int32)
+					if opts.Backfill {
+						exist = 0
+					}
+					missingTweets += max(0, user.MediaCount-exist)
+					depthByEntity[pathEntity] = calcUserDepth(exist, user.MediaCount)
 					userEntityHeap.Push(pathEntity)
 					deepest = max(deepest, depthByEntity[pathEntity])
 				}
 
 				// 自动关注
-				if user.IsProtected && user.Followstate == twitter.FS_UNFOLLOW && autoFollow {
+				if user.IsProtected && user.Followstate == twitter.FS_UNFOLLOW && opts.AutoFollow {
 					if err := twitter.FollowUser(ctx, client, user); err != nil {
 						log.WithField("user", user.Title()).Warnln("failed to follow user:", err)
 					} else {
@@ -464,7 +1214,7 @@ func BatchUserDownload(ctx context.Context, client *resty.Client, db *sqlx.DB, u
 
 			linkpath, err := curlink.Path(db)
 			if err == nil {
-				if err = os.Symlink(upath, linkpath); err == nil || os.IsExist(err) {
+				if err = ensureSymlink(upath, linkpath); err == nil {
 					err = database.CreateUserLink(db, curlink)
 				}
 			}
@@ -502,7 +1252,7 @@ func BatchUserDownload(ctx context.Context, client *resty.Client, db *sqlx.DB, u
 			return
 		}
 
-		tweets, err := user.GetMeidas(ctx, cli, &utils.TimeRange{Min: entity.LatestReleaseTime()})
+		tweets, skipped, err := user.GetMeidas(ctx, cli, opts.timeRange(entity))
 		if err == twitter.ErrWouldBlock {
 			userEntityHeap.Push(entity)
 			return
@@ -529,23 +1279,28 @@ func BatchUserDownload(ctx context.Context, client *resty.Client, db *sqlx.DB, u
 		}
 
 		if len(tweets) == 0 {
-			if err := database.UpdateUserEntityMediCount(db, entity.Id(), user.MediaCount); err != nil {
+			if err := updateTweetStat(db, entity, nil, user.MediaCount, opts); err != nil {
 				getterLogger.WithField("user", entity.Name()).Panicln("failed to update user medias count:", err)
 			}
 			return
 		}
 
 		// 确保该用户所有推文已推送并更新用户推文状态
-		for _, tw := range tweets {
-			pt := TweetInEntity{Tweet: tw, Entity: entity}
+		for _, pt := range opts.packTweets(db, tweets, entity, dir) {
 			select {
-			case tweetChan <- &pt:
+			case tweetChan <- pt:
 			case <-ctx.Done():
 				return // 防止无消费者导致死锁
 			}
 		}
 
-		if err := database.UpdateUserEntityTweetStat(db, entity.Id(), tweets[0].CreatedAt, user.MediaCount); err != nil {
+		// 有推文无法解析时不推进最新发布时间，下次重新获取这些推文
+		stated := tweets
+		if skipped != 0 {
+			getterLogger.WithField("user", entity.Name()).Warnf("%d tweets are skipped, keep the latest release time", skipped)
+			stated = nil
+		}
+		if err := updateTweetStat(db, entity, stated, user.MediaCount, opts); err != nil {
 			// 影响程序的正确性，必须 Panic
 			getterLogger.WithField("user", entity.Name()).Panicln("failed to update user tweets stat:", err)
 		}
@@ -556,6 +1311,8 @@ func BatchUserDownload(ctx context.Context, client *resty.Client, db *sqlx.DB, u
 		ctx:    ctx,
 		wg:     &conswg,
 		cancel: cancel,
+		db:     db,
+		media:  &opts.Media,
 	}
 	for i := 0; i < MaxDownloadRoutine; i++ {
 		conswg.Add(1)
@@ -621,8 +1378,8 @@ func BatchUserDownload(ctx context.Context, client *resty.Client, db *sqlx.DB, u
 	return fails, context.Cause(ctx)
 }
 
-func downloadList(ctx context.Context, client *resty.Client, db *sqlx.DB, list twitter.ListBase, dir string, realDir string, autoFollow bool, additional []*resty.Client) ([]*TweetInEntity, error) {
-	expectedTitle := utils.WinFileName(list.Title())
+func downloadList(ctx context.Context, client *resty.Client, db *sqlx.DB, list twitter.ListBase, dir string, realDir string, opts *BatchOptions, additional []*resty.Client) ([]*TweetInEntity, error) {
+	expectedTitle := listDirName(list)
 	entity, err := NewListEntity(db, list.GetId(), dir)
 	if err != nil {
 		return nil, err
@@ -642,7 +1399,7 @@ func downloadList(ctx context.Context, client *resty.Client, db *sqlx.DB, list t
 	for i, user := range members {
 		packgedUsers[i] = userInLstEntity{user: user, leid: &eid}
 	}
-	return BatchUserDownload(ctx, client, db, packgedUsers, realDir, autoFollow, additional)
+	return BatchUserDownload(ctx, client, db, packgedUsers, realDir, opts, additional)
 }
 
 func syncList(db *sqlx.DB, list *twitter.List) error {
@@ -656,17 +1413,18 @@ func syncList(db *sqlx.DB, list *twitter.List) error {
 	return database.UpdateLst(db, &database.Lst{Id: list.Id, Name: list.Name, OwnerId: list.Creator.Id})
 }
 
-func DownloadList(ctx context.Context, client *resty.Client, db *sqlx.DB, list twitter.ListBase, dir string, realDir string, autoFollow bool, additional []*resty.Client) ([]*TweetInEntity, error) {
+func DownloadList(ctx context.Context, client *resty.Client, db *sqlx.DB, list twitter.ListBase, dir string, realDir string, opts *BatchOptions, additional []*resty.Client) ([]*TweetInEntity, error) {
 	tlist, ok := list.(*twitter.List)
 	if ok {
 		if err := syncList(db, tlist); err != nil {
 			return nil, err
 		}
 	}
-	return downloadList(ctx, client, db, list, dir, realDir, autoFollow, additional)
+	return downloadList(ctx, client, db, list, dir, realDir, opts, additional)
 }
 
-func syncLstAndGetMembers(ctx context.Context, client *resty.Client, db *sqlx.DB, lst twitter.ListBase, dir string) ([]userInLstEntity, error) {
+// 同步列表实体并获取其成员，列表目录位于 dir，成员的目录位于 usersDir
+func syncLstAndGetMembers(ctx context.Context, client *resty.Client, db *sqlx.DB, lst twitter.ListBase, dir string, usersDir string) ([]userInLstEntity, error) {
 	if v, ok := lst.(*twitter.List); ok {
 		if err := syncList(db, v); err != nil {
 			return nil, err
@@ -674,7 +1432,7 @@ func syncLstAndGetMembers(ctx context.Context, client *resty.Client, db *sqlx.DB
 	}
 
 	// update lst path and record
-	expectedTitle := utils.WinFileName(lst.Title())
+	expectedTitle := listDirName(lst)
 	entity, err := NewListEntity(db, lst.GetId(), dir)
 	if err != nil {
 		return nil, err
@@ -693,42 +1451,51 @@ func syncLstAndGetMembers(ctx context.Context, client *resty.Client, db *sqlx.DB
 	packgedUsers := make([]userInLstEntity, 0, len(members))
 	eid := entity.Id()
 	for _, user := range members {
-		packgedUsers = append(packgedUsers, userInLstEntity{user: user, leid: &eid})
+		packgedUsers = append(packgedUsers, userInLstEntity{user: user, leid: &eid, dir: usersDir})
 	}
 	return packgedUsers, nil
 }
 
-func BatchDownloadAny(ctx context.Context, client *resty.Client, db *sqlx.DB, lists []twitter.ListBase, users []*twitter.User, dir string, realDir string, autoFollow bool, additional []*resty.Client) ([]*TweetInEntity, error) {
+func BatchDownloadAny(ctx context.Context, client *resty.Client, db *sqlx.DB, lists []twitter.ListBase, users []*twitter.User, dir string, realDir string, opts *BatchOptions, additional []*resty.Client) ([]*TweetInEntity, error) {
 	log.Debugln("start collecting users")
 	packgedUsers := make([]userInLstEntity, 0)
 	wg := sync.WaitGroup{}
-	mtx := sync.Mutex{}
 	ctx, cancel := context.WithCancelCause(ctx)
 	defer cancel(nil)
 
-	for _, lst := range lists {
+	// 按列表的顺序收集成员，使同时属于多个列表的用户的目录位置是确定的
+	members := make([][]userInLstEntity, len(lists))
+	for i, lst := range lists {
 		wg.Add(1)
-		go func(lst twitter.ListBase) {
+		go func(i int, lst twitter.ListBase) {
 			defer wg.Done()
-			res, err := syncLstAndGetMembers(ctx, client, db, lst, dir)
+			lstDir, usersDir := routeList(lst, dir, realDir)
+			res, err := syncLstAndGetMembers(ctx, client, db, lst, lstDir, usersDir)
+			var pe *twitter.ParseError
+			if errors.As(err, &pe) {
+				// 跳过无法解析的列表，其余列表照常下载
+				log.WithField("list", lst.Title()).Warnln("failed to get members:", err)
+				return
+			}
 			if err != nil {
 				cancel(err)
 			}
 			log.Debugf("members of %s: %d", lst.Title(), len(res))
-			mtx.Lock()
-			defer mtx.Unlock()
-			packgedUsers = append(packgedUsers, res...)
-		}(lst)
+			members[i] = res
+		}(i, lst)
 	}
 	wg.Wait()
 	if err := context.Cause(ctx); err != nil {
 		return nil, err
 	}
+	for _, res := range members {
+		packgedUsers = append(packgedUsers, res...)
+	}
 
 	for _, usr := range users {
 		packgedUsers = append(packgedUsers, userInLstEntity{user: usr, leid: nil})
 	}
 
 	log.Debugln("collected users:", len(packgedUsers))
-	return BatchUserDownload(ctx, client, db, packgedUsers, realDir, autoFollow, additional)
+	return BatchUserDownload(ctx, client, db, packgedUsers, realDir, opts, additional)
 }
@@ -0,0 +1,28 @@
+package downloading
+
+import (
+	"errors"
+
+	"github.com/unkmonster/tmd/internal/metadata"
+	"github.com/unkmonster/tmd/internal/twitter"
+)
+
+// 是否将推文的链接、作者、发布时间和内容写入下载的媒体文件
+var EmbedMetadata bool
+
+// 将推文信息写入媒体文件，跳过不支持的格式
+func embedMetadata(path string, tweet *twitter.Tweet) error {
+	info := metadata.Info{
+		Url:  tweet.Url(),
+		Date: tweet.CreatedAt,
+		Text: tweet.Text,
+	}
+	if tweet.Creator != nil {
+		info.Author = tweet.Creator.Title()
+	}
+	err := metadata.Embed(path, &info)
+	if errors.Is(err, metadata.ErrUnsupported) {
+		return nil
+	}
+	return err
+}
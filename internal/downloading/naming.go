@@ -0,0 +1,115 @@
+package downloading
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unkmonster/tmd/internal/twitter"
+	"github.com/unkmonster/tmd/internal/utils"
+)
+
+var (
+	mediaNameFields = []string{"id", "user", "name", "index", "type", "date", "text"}
+	userDirFields   = []string{"id", "name", "screen_name"}
+	listDirFields   = []string{"id", "name"}
+)
+
+const (
+	defaultMediaNameTemplate = "{text}"
+	defaultUserDirTemplate   = "{name}({screen_name})"
+	defaultListDirTemplate   = "{name}({id})"
+)
+
+// 媒体文件名（不含扩展名）、用户目录名和列表目录名的模板
+var (
+	MediaNameTemplate = utils.MustParseNameTemplate(defaultMediaNameTemplate, mediaNameFields...)
+	UserDirTemplate   = utils.MustParseNameTemplate(defaultUserDirTemplate, userDirFields...)
+	ListDirTemplate   = utils.MustParseNameTemplate(defaultListDirTemplate, listDirFields...)
+)
+
+// 解析媒体文件名模板，为空使用默认模板。扩展名总是位于文件名末尾，末尾的 {ext} 可以省略
+func ParseMediaNameTemplate(str string) (*utils.NameTemplate, error) {
+	str = strings.TrimSuffix(str, "{ext}")
+	if str == "" {
+		str = defaultMediaNameTemplate
+	}
+	return utils.ParseNameTemplate(str, mediaNameFields...)
+}
+
+// 解析用户目录名模板，为空使用默认模板
+func ParseUserDirTemplate(str string) (*utils.NameTemplate, error) {
+	if str == "" {
+		str = defaultUserDirTemplate
+	}
+	return utils.ParseNameTemplate(str, userDirFields...)
+}
+
+// 解析列表目录名模板，为空使用默认模板
+func ParseListDirTemplate(str string) (*utils.NameTemplate, error) {
+	if str == "" {
+		str = defaultListDirTemplate
+	}
+	return utils.ParseNameTemplate(str, listDirFields...)
+}
+
+// 按模板 tmpl 生成推文的第 index 个媒体的文件名
+func mediaName(tmpl *utils.NameTemplate, tweet *twitter.Tweet, index int, ext string) string {
+	return tmpl.Execute(func(field string, arg string) string {
+		switch field {
+		case "id":
+			return strconv.FormatUint(tweet.Id, 10)
+		case "user", "name":
+			if tweet.Creator == nil {
+				return ""
+			}
+			if field == "user" {
+				return tweet.Creator.ScreenName
+			}
+			return tweet.Creator.Name
+		case "index":
+			return strconv.Itoa(index + 1)
+		case "type":
+			return string(tweet.Media[index].Type)
+		case "date":
+			if arg == "" {
+				arg = time.DateOnly
+			}
+			return tweet.CreatedAt.Local().Format(arg)
+		case "text":
+			return utils.TruncateRunes(tweet.Text, arg)
+		}
+		return ""
+	}) + ext
+}
+
+func userDirName(user *twitter.User) string {
+	return UserDirTemplate.Execute(func(field string, arg string) string {
+		switch field {
+		case "id":
+			return strconv.FormatUint(user.Id, 10)
+		case "name":
+			return user.Name
+		case "screen_name":
+			return user.ScreenName
+		}
+		return ""
+	})
+}
+
+// 列表的目录名，模板仅用于列表，关注使用其标题
+func listDirName(list twitter.ListBase) string {
+	lst, ok := list.(*twitter.List)
+	if !ok {
+		return utils.WinFileName(list.Title())
+	}
+	return ListDirTemplate.Execute(func(field string, arg string) string {
+		switch field {
+		case "id":
+			return strconv.FormatUint(lst.Id, 10)
+		case "name":
+			return lst.Name
+		}
+		return ""
+	})
+}
@@ -0,0 +1,112 @@
+package downloading
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"github.com/unkmonster/tmd/internal/database"
+	"github.com/unkmonster/tmd/internal/twitter"
+)
+
+// 额外的存储根目录：匹配的列表的目录及其成员的目录，和匹配的用户的目录位于此目录下，
+// 而非默认的存储目录
+type StoreRoot struct {
+	Path  string
+	Lists []uint64
+	Users []string // user_id 或 screen_name
+	All   bool     // 匹配全部列表和用户，如设置了 root 的任务
+}
+
+// 额外的存储根目录，按顺序匹配，用户的匹配优先于其所在列表的匹配
+var StoreRoots []*StoreRoot
+
+// 根目录下存放用户目录的目录
+func (r *StoreRoot) UsersDir() string {
+	return filepath.Join(r.Path, "users")
+}
+
+func (r *StoreRoot) matchUser(user *twitter.User) bool {
+	if r.All {
+		return true
+	}
+	id := strconv.FormatUint(user.Id, 10)
+	for _, u := range r.Users {
+		if u == id || strings.EqualFold(strings.TrimPrefix(u, "@"), user.ScreenName) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *StoreRoot) matchList(list twitter.ListBase) bool {
+	if r.All {
+		return true
+	}
+	lst, ok := list.(*twitter.List)
+	return ok && slices.Contains(r.Lists, lst.Id)
+}
+
+// 列表目录所在的目录和其成员目录所在的目录，列表未被路由时返回 dir 和 usersDir
+func routeList(list twitter.ListBase, dir string, usersDir string) (string, string) {
+	for _, r := range StoreRoots {
+		if r.matchList(list) {
+			return r.Path, r.UsersDir()
+		}
+	}
+	return dir, usersDir
+}
+
+// 用户目录所在的目录，用户未被路由时返回 dir
+func routeUser(user *twitter.User, dir string) string {
+	for _, r := range StoreRoots {
+		if r.matchUser(user) {
+			return r.UsersDir()
+		}
+	}
+	return dir
+}
+
+// 同步用户及其在 dir 或被路由至的根目录下的实体。用户被路由至另一根目录而首次在此创建实体时，
+// 沿用其在 dir、otherDirs 或其他根目录下的实体的最新发布时间，不重新下载已下载至旧目录的推文
+func syncRoutedUserEntity(db *sqlx.DB, user *twitter.User, dir string, otherDirs ...string) (*UserEntity, error) {
+	target := routeUser(user, dir)
+	record, err := database.LocateUserEntity(db, user.Id, target)
+	if err != nil {
+		return nil, err
+	}
+	entity, err := syncUserAndEntity(db, user, target)
+	if err != nil || record != nil {
+		return entity, err
+	}
+
+	var latest time.Time
+	dirs := append([]string{dir}, otherDirs...)
+	for _, d := range append(dirs, rootUsersDirs()...) {
+		old, err := database.LocateUserEntity(db, user.Id, d)
+		if err != nil {
+			return nil, err
+		}
+		if old != nil && old.LatestReleaseTime.Valid && old.LatestReleaseTime.Time.After(latest) {
+			latest = old.LatestReleaseTime.Time
+		}
+	}
+	if latest.IsZero() {
+		return entity, nil
+	}
+	log.WithField("user", user.Title()).Infoln("user is moved to another root, continue from", latest.Local().Format(time.DateTime))
+	return entity, entity.SetLatestReleaseTime(latest)
+}
+
+// 各额外根目录下存放用户目录的目录
+func rootUsersDirs() []string {
+	dirs := make([]string, 0, len(StoreRoots))
+	for _, r := range StoreRoots {
+		dirs = append(dirs, r.UsersDir())
+	}
+	return dirs
+}
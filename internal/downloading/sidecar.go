@@ -0,0 +1,99 @@
+package downloading
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/unkmonster/tmd/internal/twitter"
+	"github.com/unkmonster/tmd/internal/utils"
+)
+
+// 是否在媒体旁写入 <id>.json 保存推文的元数据
+var Sidecar bool
+
+type tweetSidecar struct {
+	Id        uint64         `json:"id"`
+	Text      string         `json:"text"`
+	CreatedAt time.Time      `json:"created_at"`
+	Creator   *sidecarUser   `json:"creator"`
+	Media     []sidecarMedia `json:"media"`
+	*twitter.TweetMetadata
+	Legacy json.RawMessage `json:"legacy"`
+}
+
+type sidecarUser struct {
+	Id         uint64 `json:"id"`
+	Name       string `json:"name"`
+	ScreenName string `json:"screen_name"`
+}
+
+type sidecarMedia struct {
+	Type     twitter.MediaType `json:"type"`
+	Url      string            `json:"url"`
+	Width    int               `json:"width,omitempty"`
+	Height   int               `json:"height,omitempty"`
+	Duration float64           `json:"duration,omitempty"` // 秒
+	Variants []sidecarVariant  `json:"variants,omitempty"`
+}
+
+type sidecarVariant struct {
+	Bitrate     int    `json:"bitrate,omitempty"`
+	ContentType string `json:"content_type"`
+	Url         string `json:"url"`
+}
+
+func sidecarPath(dir string, tweet *twitter.Tweet) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.json", tweet.Id))
+}
+
+// 写入推文的元数据，force 为 false 时不覆盖已存在的文件
+func writeSidecar(dir string, tweet *twitter.Tweet, force bool) error {
+	path := sidecarPath(dir, tweet)
+	if !force {
+		exist, err := utils.PathExists(path)
+		if err != nil || exist {
+			return err
+		}
+	}
+
+	sidecar := tweetSidecar{
+		Id:            tweet.Id,
+		Text:          tweet.Text,
+		CreatedAt:     tweet.CreatedAt,
+		Media:         []sidecarMedia{},
+		TweetMetadata: tweet.Metadata(),
+		Legacy:        tweet.Legacy,
+	}
+	if tweet.Creator != nil {
+		sidecar.Creator = &sidecarUser{tweet.Creator.Id, tweet.Creator.Name, tweet.Creator.ScreenName}
+	}
+	for _, m := range tweet.Media {
+		sm := sidecarMedia{
+			Type:     m.Type,
+			Url:      m.Url,
+			Width:    m.Width,
+			Height:   m.Height,
+			Duration: m.Duration.Seconds(),
+		}
+		for _, v := range m.Variants {
+			sm.Variants = append(sm.Variants, sidecarVariant{v.Bitrate, v.ContentType, v.Url})
+		}
+		sidecar.Media = append(sidecar.Media, sm)
+	}
+	if len(sidecar.Legacy) == 0 {
+		sidecar.Legacy = json.RawMessage("null")
+	}
+	data, err := json.MarshalIndent(&sidecar, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".part"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
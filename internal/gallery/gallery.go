@@ -0,0 +1,275 @@
+package gallery
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/unkmonster/tmd/internal/database"
+)
+
+type mediaItem struct {
+	Src      template.URL // 相对于页面的链接
+	Kind     string       // image, video, audio 或 file
+	Name     string
+	Date     time.Time
+	TweetUrl string // 未记录推文时为空
+	Text     string
+}
+
+type userPage struct {
+	Id    int32
+	Title string
+	Dir   string // 相对于存储目录，位于额外的根目录下时为绝对路径
+	Media []*mediaItem
+}
+
+type listPage struct {
+	Id    int32
+	Title string
+	Dir   string
+	Users []*userPage
+}
+
+type Stats struct {
+	Users int
+	Lists int
+	Media int
+}
+
+var mediaKinds = map[string]string{
+	".jpg": "image", ".jpeg": "image", ".png": "image", ".gif": "image", ".webp": "image",
+	".mp4": "video", ".mov": "video", ".webm": "video",
+	".m4a": "audio", ".aac": "audio", ".mp3": "audio",
+}
+
+// 在 out 目录下生成浏览存储目录 root 的静态页面：index.html 列出所有列表和用户，
+// lists/<id>.html 为列表的用户，users/<id>.html 为用户的媒体。页面通过相对路径引用媒体，可离线浏览。
+// 位于额外的根目录下的用户同样通过相对路径引用
+func Generate(db *sqlx.DB, root string, out string) (*Stats, error) {
+	for _, dir := range []string{out, filepath.Join(out, "users"), filepath.Join(out, "lists")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	entities, err := database.GetUserEntities(db)
+	if err != nil {
+		return nil, err
+	}
+	users := []*userPage{}
+	usersByUid := make(map[uint64][]*userPage)
+	stats := Stats{}
+	for _, entity := range entities {
+		page, err := buildUserPage(db, entity, root, filepath.Join(out, "users"))
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, page)
+		usersByUid[entity.Uid] = append(usersByUid[entity.Uid], page)
+		stats.Media += len(page.Media)
+		if err := render(filepath.Join(out, "users", fmt.Sprintf("%d.html", page.Id)), "user", page); err != nil {
+			return nil, err
+		}
+	}
+
+	lstEntities, err := database.GetLstEntities(db)
+	if err != nil {
+		return nil, err
+	}
+	lists := []*listPage{}
+	for _, entity := range lstEntities {
+		page := &listPage{Id: entity.Id.Int32, Title: entity.Name, Dir: relPath(root, entity.Path())}
+		links, err := database.GetLstEntityUserLinks(db, entity.Id.Int32)
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range links {
+			page.Users = append(page.Users, usersByUid[link.Uid]...)
+		}
+		lists = append(lists, page)
+		if err := render(filepath.Join(out, "lists", fmt.Sprintf("%d.html", page.Id)), "list", page); err != nil {
+			return nil, err
+		}
+	}
+
+	index := struct {
+		Lists []*listPage
+		Users []*userPage
+	}{lists, users}
+	if err := render(filepath.Join(out, "index.html"), "index", &index); err != nil {
+		return nil, err
+	}
+	stats.Users = len(users)
+	stats.Lists = len(lists)
+	return &stats, nil
+}
+
+// 列出用户目录下的媒体，有下载记录的媒体使用推文的发布时间和内容，
+// 否则使用文件的修改时间（下载时被设为推文的发布时间）
+func buildUserPage(db *sqlx.DB, entity *database.UserEntity, root string, pageDir string) (*userPage, error) {
+	page := &userPage{Id: entity.Id.Int32, Title: entity.Name, Dir: relPath(root, entity.Path())}
+	records, err := database.GetEntityDownloadedMedia(db, int(entity.Id.Int32))
+	if err != nil {
+		return nil, err
+	}
+	recordByName := make(map[string]*database.EntityMedia)
+	for _, record := range records {
+		recordByName[filepath.Base(record.Path.String)] = record
+	}
+
+	entries, err := os.ReadDir(entity.Path())
+	if os.IsNotExist(err) {
+		return page, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if entry.IsDir() || strings.HasPrefix(name, ".") || ext == ".json" || ext == ".part" || ext == ".meta" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		item := &mediaItem{
+			Src:  relUrl(pageDir, filepath.Join(entity.Path(), name)),
+			Kind: mediaKinds[ext],
+			Name: name,
+			Date: info.ModTime(),
+		}
+		if item.Kind == "" {
+			item.Kind = "file"
+		}
+		if record := recordByName[name]; record != nil {
+			item.Date = record.CreatedAt
+			item.Text = record.Text
+			item.TweetUrl = fmt.Sprintf("https://x.com/i/status/%d", record.TweetId)
+		}
+		page.Media = append(page.Media, item)
+	}
+	slices.SortStableFunc(page.Media, func(a, b *mediaItem) int {
+		return b.Date.Compare(a.Date)
+	})
+	return page, nil
+}
+
+// 相对于存储目录 root 的路径，位于额外的根目录等其他位置时返回绝对路径
+func relPath(root string, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		abs, _ := filepath.Abs(path)
+		return abs
+	}
+	return rel
+}
+
+// 从 base 目录指向 path 的相对链接，如额外的根目录位于另一个卷上而无法表示为相对路径时使用 file 链接。
+// 返回值被模板视为可信的链接，以免 file 链接被替换为 #ZgotmplZ
+func relUrl(base string, path string) template.URL {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		abs, _ := filepath.Abs(path)
+		abs = filepath.ToSlash(abs)
+		if !strings.HasPrefix(abs, "/") {
+			abs = "/" + abs // windows 的盘符
+		}
+		return template.URL((&url.URL{Scheme: "file", Path: abs}).String())
+	}
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	// 首段含有 : 时会被解析为 scheme
+	if strings.Contains(segments[0], ":") {
+		segments = append([]string{"."}, segments...)
+	}
+	return template.URL(strings.Join(segments, "/"))
+}
+
+func render(path string, name string, data any) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return templates.ExecuteTemplate(file, name, data)
+}
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Local().Format(time.DateTime) },
+}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; background: #fafafa; }
+a { color: #1d9bf0; text-decoration: none; }
+ul.entries { column-width: 20em; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(200px, 1fr)); gap: 12px; }
+.media { background: #fff; border: 1px solid #ddd; border-radius: 6px; padding: 6px; font-size: 12px; overflow: hidden; }
+.media img, .media video { width: 100%; height: 200px; object-fit: cover; background: #000; }
+.media audio { width: 100%; }
+.media p { margin: 4px 0; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+.date { color: #666; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "index"}}{{template "head" "tmd"}}
+<h1>tmd</h1>
+<h2>Lists ({{len .Lists}})</h2>
+<ul class="entries">
+{{range .Lists}}<li><a href="lists/{{.Id}}.html">{{.Title}}</a> ({{len .Users}})</li>
+{{end}}</ul>
+<h2>Users ({{len .Users}})</h2>
+<ul class="entries">
+{{range .Users}}<li><a href="users/{{.Id}}.html">{{.Title}}</a> ({{len .Media}}) <span class="date">{{.Dir}}</span></li>
+{{end}}</ul>
+</body>
+</html>
+{{end}}
+
+{{define "list"}}{{template "head" .Title}}
+<p><a href="../index.html">index</a></p>
+<h1>{{.Title}}</h1>
+<p class="date">{{.Dir}}</p>
+<ul class="entries">
+{{range .Users}}<li><a href="../users/{{.Id}}.html">{{.Title}}</a> ({{len .Media}})</li>
+{{end}}</ul>
+</body>
+</html>
+{{end}}
+
+{{define "user"}}{{template "head" .Title}}
+<p><a href="../index.html">index</a></p>
+<h1>{{.Title}}</h1>
+<p class="date">{{.Dir}} · {{len .Media}} media</p>
+<div class="grid">
+{{range .Media}}<div class="media">
+{{if eq .Kind "image"}}<a href="{{.Src}}"><img src="{{.Src}}" loading="lazy" alt="{{.Name}}"></a>
+{{else if eq .Kind "video"}}<video src="{{.Src}}" controls preload="metadata"></video>
+{{else if eq .Kind "audio"}}<audio src="{{.Src}}" controls preload="none"></audio>
+{{end}}<p title="{{.Name}}"><a href="{{.Src}}">{{.Name}}</a></p>
+<p class="date">{{date .Date}}{{if .TweetUrl}} · <a href="{{.TweetUrl}}">tweet</a>{{end}}</p>
+{{if .Text}}<p title="{{.Text}}">{{.Text}}</p>{{end}}
+</div>
+{{end}}</div>
+</body>
+</html>
+{{end}}
+`))
@@ -0,0 +1,145 @@
+package gallery
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/unkmonster/tmd/internal/database"
+)
+
+func TestGenerate(t *testing.T) {
+	root := t.TempDir()
+	db, err := sqlx.Connect("sqlite3", fmt.Sprintf("file:%s", filepath.Join(root, "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	database.CreateTables(db)
+
+	users := filepath.Join(root, "users")
+	entity := &database.UserEntity{Uid: 1, Name: "name(screen)", ParentDir: users}
+	if err := database.CreateUserEntity(db, entity); err != nil {
+		t.Fatal(err)
+	}
+	dir := entity.Path()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// 有下载记录的较旧的图片，无记录的较新的视频，以及应被忽略的文件
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	newer := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	files := map[string]time.Time{"a #1.jpg": older, "b.mp4": newer, "2.json": newer, ".2-abc.part": newer}
+	for name, mtime := range files {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(name), 0644)
+		os.Chtimes(path, time.Time{}, mtime)
+	}
+	if err := database.RecordTweet(db, &database.Tweet{Id: 2, Uid: 1, Text: "<tweet>", CreatedAt: older}); err != nil {
+		t.Fatal(err)
+	}
+	media := &database.TweetMedia{TweetId: 2, EntityId: entity.Id.Int32, Url: "u", Status: database.MS_DOWNLOADED}
+	media.Path = sql.NullString{String: filepath.Join(dir, "a #1.jpg"), Valid: true}
+	if err := database.RecordTweetMedia(db, media); err != nil {
+		t.Fatal(err)
+	}
+
+	lstEntity := &database.LstEntity{LstId: 3, Name: "list", ParentDir: root}
+	if err := database.CreateLstEntity(db, lstEntity); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.CreateUserLink(db, &database.UserLink{Uid: 1, Name: "name(screen)", ParentLstEntityId: lstEntity.Id.Int32}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 位于额外根目录下的用户
+	extra := t.TempDir()
+	other := &database.UserEntity{Uid: 4, Name: "other", ParentDir: filepath.Join(extra, "users")}
+	if err := database.CreateUserEntity(db, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(other.Path(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(other.Path(), "c.jpg"), []byte("c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(root, "gallery")
+	stats, err := Generate(db, root, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *stats != (Stats{Users: 2, Lists: 1, Media: 3}) {
+		t.Errorf("stats = %+v", *stats)
+	}
+
+	read := func(path string) string {
+		data, err := os.ReadFile(filepath.Join(out, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	index := read("index.html")
+	for _, want := range []string{fmt.Sprintf(`href="users/%d.html"`, entity.Id.Int32), fmt.Sprintf(`href="lists/%d.html"`, lstEntity.Id.Int32)} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index does not contain %s", want)
+		}
+	}
+	list := read(fmt.Sprintf("lists/%d.html", lstEntity.Id.Int32))
+	if !strings.Contains(list, fmt.Sprintf(`href="../users/%d.html"`, entity.Id.Int32)) {
+		t.Error("list page does not link to user")
+	}
+
+	page := read(fmt.Sprintf("users/%d.html", entity.Id.Int32))
+	video := strings.Index(page, `src="../../users/name%28screen%29/b.mp4"`)
+	image := strings.Index(page, `src="../../users/name%28screen%29/a%20%231.jpg"`)
+	if video < 0 || image < 0 || video > image {
+		t.Errorf("media are missing or not ordered by date:\n%s", page)
+	}
+	for _, want := range []string{"https://x.com/i/status/2", "&lt;tweet&gt;"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("user page does not contain %s", want)
+		}
+	}
+	if strings.Contains(page, "2.json") || strings.Contains(page, ".part") {
+		t.Error("user page contains non-media files")
+	}
+
+	page = read(fmt.Sprintf("users/%d.html", other.Id.Int32))
+	src, err := filepath.Rel(filepath.Join(out, "users"), filepath.Join(other.Path(), "c.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(page, fmt.Sprintf(`src="%s"`, filepath.ToSlash(src))) || !strings.Contains(page, other.Path()) {
+		t.Errorf("user page in extra root:\n%s", page)
+	}
+}
+
+func TestFileUrl(t *testing.T) {
+	abs, err := filepath.Abs("c.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 相对的 base 与绝对路径之间无法表示为相对路径
+	item := &mediaItem{Src: relUrl("users", abs), Kind: "image", Name: "c.jpg"}
+	buf := strings.Builder{}
+	if err := templates.ExecuteTemplate(&buf, "user", &userPage{Title: "user", Media: []*mediaItem{item}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `src="file:///`) || strings.Contains(buf.String(), "ZgotmplZ") {
+		t.Errorf("file url is not rendered:\n%s", buf.String())
+	}
+
+	if got := relUrl("users", "users/a:b.jpg"); got != "./a:b.jpg" {
+		t.Errorf("relUrl with colon = %s", got)
+	}
+}
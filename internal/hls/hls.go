@@ -0,0 +1,283 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/unkmonster/tmd/internal/utils"
+)
+
+// 主播放列表中的一路流
+type Stream struct {
+	Bandwidth  int
+	Resolution string
+	Audio      string // 独立音轨的分组，为空表示音频已混流
+	Uri        string
+}
+
+// 主播放列表中由 EXT-X-MEDIA 声明的再现，如独立的音轨
+type Rendition struct {
+	Type    string // AUDIO, SUBTITLES 等
+	GroupId string
+	Name    string
+	Default bool
+	Uri     string // 为空表示此再现已混流在引用它的流中
+}
+
+type Playlist struct {
+	Streams    []Stream    // 主播放列表
+	Renditions []Rendition // 主播放列表
+	Map        string      // 媒体播放列表的初始化分段（fMP4）
+	Segments   []string    // 媒体播放列表
+	Audio      *Playlist   // 媒体播放列表的独立音轨，由 Fetch 获取
+}
+
+func (p *Playlist) IsMaster() bool {
+	return len(p.Streams) != 0
+}
+
+// 拼接后文件应有的扩展名
+func (p *Playlist) Ext() string {
+	if p.Map != "" {
+		return ".mp4"
+	}
+	if len(p.Segments) != 0 {
+		if u, err := url.Parse(p.Segments[0]); err == nil && path.Ext(u.Path) != "" {
+			return path.Ext(u.Path)
+		}
+	}
+	return ".ts"
+}
+
+// 带宽最高的流，优先选择已混流音频的流
+func (p *Playlist) BestStream() *Stream {
+	var best *Stream
+	for i := range p.Streams {
+		s := &p.Streams[i]
+		if best == nil ||
+			(best.Audio != "" && s.Audio == "") ||
+			((best.Audio == "") == (s.Audio == "") && s.Bandwidth > best.Bandwidth) {
+			best = s
+		}
+	}
+	return best
+}
+
+// 流的独立音轨，优先选择默认的音轨，音频已混流返回 nil
+func (p *Playlist) AudioOf(s *Stream) *Rendition {
+	var found *Rendition
+	for i := range p.Renditions {
+		r := &p.Renditions[i]
+		if r.Type != "AUDIO" || r.GroupId != s.Audio || r.Uri == "" {
+			continue
+		}
+		if found == nil || (r.Default && !found.Default) {
+			found = r
+		}
+	}
+	return found
+}
+
+// 解析播放列表，其中的相对地址基于 base 解析为绝对地址
+func Parse(data []byte, base *url.URL) (*Playlist, error) {
+	scan := bufio.NewScanner(bytes.NewReader(data))
+	if !scan.Scan() || strings.TrimSpace(scan.Text()) != "#EXTM3U" {
+		return nil, fmt.Errorf("not a m3u8 playlist")
+	}
+
+	pl := Playlist{}
+	var stream *Stream // 等待 uri 的流
+	segment := false   // 下一个 uri 是分段
+
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "#") {
+			uri, err := base.Parse(line)
+			if err != nil {
+				return nil, err
+			}
+			if stream != nil {
+				stream.Uri = uri.String()
+				pl.Streams = append(pl.Streams, *stream)
+				stream = nil
+			} else if segment {
+				pl.Segments = append(pl.Segments, uri.String())
+				segment = false
+			}
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+		switch tag {
+		case "#EXT-X-STREAM-INF":
+			attrs := parseAttributes(value)
+			bandwidth, _ := strconv.Atoi(attrs["BANDWIDTH"])
+			stream = &Stream{Bandwidth: bandwidth, Resolution: attrs["RESOLUTION"], Audio: attrs["AUDIO"]}
+		case "#EXT-X-MEDIA":
+			attrs := parseAttributes(value)
+			rendition := Rendition{Type: attrs["TYPE"], GroupId: attrs["GROUP-ID"], Name: attrs["NAME"], Default: attrs["DEFAULT"] == "YES"}
+			if attrs["URI"] != "" {
+				uri, err := base.Parse(attrs["URI"])
+				if err != nil {
+					return nil, err
+				}
+				rendition.Uri = uri.String()
+			}
+			pl.Renditions = append(pl.Renditions, rendition)
+		case "#EXTINF":
+			segment = true
+		case "#EXT-X-MAP":
+			uri, err := base.Parse(parseAttributes(value)["URI"])
+			if err != nil {
+				return nil, err
+			}
+			pl.Map = uri.String()
+		case "#EXT-X-KEY":
+			if method := parseAttributes(value)["METHOD"]; method != "NONE" {
+				return nil, fmt.Errorf("encrypted playlist is not supported: %s", method)
+			}
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	if !pl.IsMaster() && len(pl.Segments) == 0 {
+		return nil, fmt.Errorf("empty playlist")
+	}
+	return &pl, nil
+}
+
+// 解析形如 KEY=VALUE,KEY="VALUE" 的属性列表
+func parseAttributes(str string) map[string]string {
+	attrs := make(map[string]string)
+	for len(str) != 0 {
+		key, rest, ok := strings.Cut(str, "=")
+		if !ok {
+			break
+		}
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			value = rest[1 : end+1]
+			rest = rest[min(end+2, len(rest)):]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+		attrs[strings.TrimSpace(key)] = value
+
+		str = strings.TrimPrefix(rest, ",")
+	}
+	return attrs
+}
+
+func get(ctx context.Context, client *resty.Client, u string) ([]byte, error) {
+	resp, err := client.R().SetContext(ctx).Get(u)
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.CheckRespStatus(resp); err != nil {
+		return nil, err
+	}
+	return resp.Body(), nil
+}
+
+func fetch(ctx context.Context, client *resty.Client, playlistUrl string) (*Playlist, error) {
+	base, err := url.Parse(playlistUrl)
+	if err != nil {
+		return nil, err
+	}
+	data, err := get(ctx, client, playlistUrl)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, base)
+}
+
+// 获取媒体播放列表，主播放列表将跟随至 BestStream 的媒体播放列表，
+// 此流有独立音轨时一并获取音轨的媒体播放列表，存放在 Audio 中
+func Fetch(ctx context.Context, client *resty.Client, playlistUrl string) (*Playlist, error) {
+	pl, err := fetch(ctx, client, playlistUrl)
+	if err != nil || !pl.IsMaster() {
+		return pl, err
+	}
+
+	stream := pl.BestStream()
+	media, err := fetch(ctx, client, stream.Uri)
+	if err != nil {
+		return nil, err
+	}
+	if media.IsMaster() {
+		return nil, fmt.Errorf("nested master playlist")
+	}
+	if audio := pl.AudioOf(stream); audio != nil {
+		if media.Audio, err = fetch(ctx, client, audio.Uri); err != nil {
+			return nil, fmt.Errorf("failed to get audio playlist: %v", err)
+		}
+		if media.Audio.IsMaster() {
+			return nil, fmt.Errorf("nested master playlist")
+		}
+	}
+	return media, nil
+}
+
+// 并发下载媒体播放列表的全部分段，按顺序写入 w，返回写入的字节数。
+// 同时最多持有 concurrency 个分段在内存中
+func (p *Playlist) Download(ctx context.Context, client *resty.Client, w io.Writer, concurrency int) (int64, error) {
+	if p.IsMaster() {
+		return 0, fmt.Errorf("unable to download a master playlist")
+	}
+	concurrency = max(concurrency, 1)
+
+	uris := p.Segments
+	if p.Map != "" {
+		uris = append([]string{p.Map}, uris...)
+	}
+
+	var written int64
+	bodies := make([][]byte, concurrency)
+	errs := make([]error, concurrency)
+
+	for begin := 0; begin < len(uris); begin += concurrency {
+		window := uris[begin:min(begin+concurrency, len(uris))]
+
+		wg := sync.WaitGroup{}
+		for i, u := range window {
+			wg.Add(1)
+			go func(i int, u string) {
+				defer wg.Done()
+				bodies[i], errs[i] = get(ctx, client, u)
+			}(i, u)
+		}
+		wg.Wait()
+
+		for i := range window {
+			if errs[i] != nil {
+				return written, fmt.Errorf("failed to download segment %s: %v", window[i], errs[i])
+			}
+			n, err := w.Write(bodies[i])
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,219 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const master = `#EXTM3U
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-MEDIA:NAME="Audio",TYPE=AUDIO,GROUP-ID="audio-128000",AUTOSELECT=YES,URI="/pl/mp4a/128000/audio.m3u8"
+#EXT-X-STREAM-INF:AVERAGE-BANDWIDTH=2000000,BANDWIDTH=2500000,RESOLUTION=1280x720,CODECS="mp4a.40.2,avc1.4d001f",AUDIO="audio-128000"
+/pl/avc1/1280x720/video.m3u8
+#EXT-X-STREAM-INF:AVERAGE-BANDWIDTH=600000,BANDWIDTH=800000,RESOLUTION=480x270,CODECS="mp4a.40.2,avc1.4d0015"
+low/video.m3u8
+#EXT-X-STREAM-INF:AVERAGE-BANDWIDTH=1000000,BANDWIDTH=1200000,RESOLUTION=640x360,CODECS="mp4a.40.2,avc1.4d001e"
+mid/video.m3u8
+`
+
+const media = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:3
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:3.000,
+seg0.ts
+#EXTINF:3.000,
+seg1.ts?token=1
+#EXTINF:1.500,
+/abs/seg2.ts
+#EXT-X-ENDLIST
+`
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("https://video.example.com/ext_tw_video/1/pu/pl/master.m3u8?tag=12")
+
+	pl, err := Parse([]byte(master), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pl.IsMaster() || len(pl.Streams) != 3 {
+		t.Fatalf("streams: %+v", pl.Streams)
+	}
+	first := pl.Streams[0]
+	if first.Bandwidth != 2500000 || first.Resolution != "1280x720" || first.Audio != "audio-128000" || first.Uri != "https://video.example.com/pl/avc1/1280x720/video.m3u8" {
+		t.Errorf("streams[0] = %+v", first)
+	}
+	if best := pl.BestStream(); best.Uri != "https://video.example.com/ext_tw_video/1/pu/pl/mid/video.m3u8" {
+		t.Errorf("best stream = %+v, want the muxed one with the highest bandwidth", best)
+	}
+	if audio := pl.AudioOf(&first); audio == nil || audio.Type != "AUDIO" || audio.Uri != "https://video.example.com/pl/mp4a/128000/audio.m3u8" {
+		t.Errorf("audio of streams[0] = %+v", audio)
+	}
+	if audio := pl.AudioOf(&pl.Streams[1]); audio != nil {
+		t.Errorf("audio of muxed stream = %+v", audio)
+	}
+
+	pl, err = Parse([]byte(media), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"https://video.example.com/ext_tw_video/1/pu/pl/seg0.ts",
+		"https://video.example.com/ext_tw_video/1/pu/pl/seg1.ts?token=1",
+		"https://video.example.com/abs/seg2.ts",
+	}
+	if pl.IsMaster() || len(pl.Segments) != len(want) {
+		t.Fatalf("segments: %v", pl.Segments)
+	}
+	for i := range want {
+		if pl.Segments[i] != want[i] {
+			t.Errorf("segments[%d] = %s, want %s", i, pl.Segments[i], want[i])
+		}
+	}
+	if pl.Ext() != ".ts" {
+		t.Errorf("ext = %s, want .ts", pl.Ext())
+	}
+
+	fmp4 := "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:2.0,\n0.m4s\n"
+	pl, err = Parse([]byte(fmp4), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.Map != "https://video.example.com/ext_tw_video/1/pu/pl/init.mp4" || pl.Ext() != ".mp4" {
+		t.Errorf("map = %s, ext = %s", pl.Map, pl.Ext())
+	}
+
+	bad := []string{
+		"",
+		"seg0.ts\n",
+		"#EXTM3U\n#EXT-X-ENDLIST\n",
+		"#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key\"\n#EXTINF:3.0,\nseg0.ts\n",
+	}
+	for _, data := range bad {
+		if _, err := Parse([]byte(data), base); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", data)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	segments := map[string]string{
+		"/hls/seg0.ts": "segment 0;",
+		"/hls/seg1.ts": "segment 1;",
+		"/abs/seg2.ts": "segment 2;",
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hls/master.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\nlow.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=1200000\nhigh.m3u8\n"))
+	})
+	mux.HandleFunc("/hls/high.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(media))
+	})
+	mux.HandleFunc("/hls/low.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "wrong stream", http.StatusNotFound)
+	})
+	for path, body := range segments {
+		body := body
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		})
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	client := resty.New()
+	pl, err := Fetch(ctx, client, server.URL+"/hls/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, concurrency := range []int{0, 1, 2, 8} {
+		buf := bytes.Buffer{}
+		n, err := pl.Download(ctx, client, &buf, concurrency)
+		if err != nil {
+			t.Errorf("concurrency %d: %v", concurrency, err)
+			continue
+		}
+		want := "segment 0;segment 1;segment 2;"
+		if buf.String() != want || n != int64(len(want)) {
+			t.Errorf("concurrency %d: got %q (%d bytes), want %q", concurrency, buf.String(), n, want)
+		}
+	}
+
+	if pl.Audio != nil {
+		t.Errorf("muxed stream has audio playlist %+v", pl.Audio)
+	}
+
+	// 分段丢失
+	pl.Segments = append(pl.Segments, server.URL+"/hls/missing.ts")
+	if _, err := pl.Download(ctx, client, &bytes.Buffer{}, 2); err == nil {
+		t.Errorf("download succeeded with a missing segment")
+	}
+}
+
+// X 的主播放列表中每路流都引用独立的音轨分组
+func TestFetchSeparateAudio(t *testing.T) {
+	playlists := map[string]string{
+		"/pl/master.m3u8": `#EXTM3U
+#EXT-X-MEDIA:NAME="Audio",TYPE=AUDIO,GROUP-ID="audio-64000",AUTOSELECT=YES,URI="/pl/mp4a/64000/audio.m3u8"
+#EXT-X-MEDIA:NAME="Audio",TYPE=AUDIO,GROUP-ID="audio-128000",AUTOSELECT=YES,URI="/pl/mp4a/128000/other.m3u8"
+#EXT-X-MEDIA:NAME="Audio",TYPE=AUDIO,GROUP-ID="audio-128000",AUTOSELECT=YES,DEFAULT=YES,URI="/pl/mp4a/128000/audio.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=480x270,AUDIO="audio-64000"
+/pl/avc1/480x270/video.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720,AUDIO="audio-128000"
+/pl/avc1/1280x720/video.m3u8
+`,
+		"/pl/avc1/1280x720/video.m3u8": "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:3.0,\n0.m4s\n#EXT-X-ENDLIST\n",
+		"/pl/mp4a/128000/audio.m3u8":   "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:3.0,\n0.m4s\n#EXT-X-ENDLIST\n",
+		"/pl/avc1/1280x720/init.mp4":   "video init;",
+		"/pl/avc1/1280x720/0.m4s":      "video 0;",
+		"/pl/mp4a/128000/init.mp4":     "audio init;",
+		"/pl/mp4a/128000/0.m4s":        "audio 0;",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := playlists[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client := resty.New()
+	pl, err := Fetch(ctx, client, server.URL+"/pl/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.Audio == nil {
+		t.Fatal("audio playlist is not fetched")
+	}
+
+	for _, test := range []struct {
+		pl   *Playlist
+		want string
+	}{{pl, "video init;video 0;"}, {pl.Audio, "audio init;audio 0;"}} {
+		buf := bytes.Buffer{}
+		if _, err := test.pl.Download(ctx, client, &buf, 2); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("downloaded %q, want %q", buf.String(), test.want)
+		}
+	}
+
+	// 音轨的播放列表丢失
+	delete(playlists, "/pl/mp4a/128000/audio.m3u8")
+	if _, err := Fetch(ctx, client, server.URL+"/pl/master.m3u8"); err == nil {
+		t.Error("fetch succeeded without audio playlist")
+	}
+}
@@ -0,0 +1,208 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	exifHeader = "Exif\x00\x00"
+	xmpHeader  = "http://ns.adobe.com/xap/1.0/\x00"
+
+	maxSegmentLen = 0xFFFF - 2
+	maxExifText   = 4096
+)
+
+// 在 JFIF/APP0 之后插入 EXIF 与 XMP 段。已有的 XMP 段被替换，
+// 已有 EXIF 段时保留原 EXIF，不再写入
+func embedJpeg(src io.ReaderAt, size int64, dst io.Writer, info *Info) error {
+	data, err := readAll(src, size)
+	if err != nil {
+		return err
+	}
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return fmt.Errorf("invalid jpeg: missing SOI")
+	}
+
+	head := [][]byte{} // 位于插入点之前的段
+	tail := [][]byte{} // 位于插入点之后的段
+	hasExif := false
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return fmt.Errorf("invalid jpeg: bad marker at %d", pos)
+		}
+		marker := data[pos+1]
+		if marker == 0xDA { // SOS 之后为图像数据
+			tail = append(tail, data[pos:])
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return fmt.Errorf("invalid jpeg: bad segment length at %d", pos)
+		}
+		segment := data[pos:end]
+		payload := segment[4:]
+		pos = end
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, []byte(xmpHeader)):
+			continue
+		case marker == 0xE1 && bytes.HasPrefix(payload, []byte(exifHeader)):
+			hasExif = true
+		}
+		if marker == 0xE0 && len(tail) == 0 {
+			head = append(head, segment)
+		} else {
+			tail = append(tail, segment)
+		}
+	}
+
+	segments := [][]byte{{0xFF, 0xD8}}
+	segments = append(segments, head...)
+	if !hasExif {
+		segments = append(segments, jpegSegment(0xE1, append([]byte(exifHeader), buildExif(info)...)))
+	}
+	segments = append(segments, jpegSegment(0xE1, append([]byte(xmpHeader), buildXmp(info, maxSegmentLen-len(xmpHeader))...)))
+	segments = append(segments, tail...)
+	for _, segment := range segments {
+		if _, err := dst.Write(segment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	if len(payload) > maxSegmentLen {
+		payload = payload[:maxSegmentLen]
+	}
+	segment := []byte{0xFF, marker}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+const (
+	tiffAscii     = 2
+	tiffLong      = 4
+	tiffUndefined = 7
+)
+
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	value []byte
+}
+
+func asciiValue(str string) []byte {
+	return append([]byte(str), 0)
+}
+
+func longValue(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+// 编码位于 offset 处的 IFD，超过 4 字节的值紧随其后
+func buildIfd(entries []ifdEntry, offset uint32) []byte {
+	dataOffset := offset + 2 + 12*uint32(len(entries)) + 4
+	ifd := binary.BigEndian.AppendUint16(nil, uint16(len(entries)))
+	data := []byte{}
+	for _, e := range entries {
+		count := uint32(len(e.value))
+		if e.typ == tiffLong {
+			count /= 4
+		}
+		ifd = binary.BigEndian.AppendUint16(ifd, e.tag)
+		ifd = binary.BigEndian.AppendUint16(ifd, e.typ)
+		ifd = binary.BigEndian.AppendUint32(ifd, count)
+		if len(e.value) <= 4 {
+			ifd = append(ifd, e.value...)
+			ifd = append(ifd, make([]byte, 4-len(e.value))...)
+			continue
+		}
+		ifd = binary.BigEndian.AppendUint32(ifd, dataOffset+uint32(len(data)))
+		data = append(data, e.value...)
+		if len(data)%2 != 0 {
+			data = append(data, 0)
+		}
+	}
+	ifd = binary.BigEndian.AppendUint32(ifd, 0)
+	return append(ifd, data...)
+}
+
+// 大端序的 TIFF 结构：IFD0 记录描述、作者和时间，Exif IFD 记录拍摄时间和推文链接
+func buildExif(info *Info) []byte {
+	date := info.Date.Local().Format("2006:01:02 15:04:05")
+	text := truncateBytes(info.Text, maxExifText)
+	exifIfd := []ifdEntry{
+		{0x9003, tiffAscii, asciiValue(date)},                                     // DateTimeOriginal
+		{0x9286, tiffUndefined, append([]byte("ASCII\x00\x00\x00"), info.Url...)}, // UserComment
+	}
+	ifd0 := func(exifOffset uint32) []ifdEntry {
+		return []ifdEntry{
+			{0x010E, tiffAscii, asciiValue(text)},        // ImageDescription
+			{0x0132, tiffAscii, asciiValue(date)},        // DateTime
+			{0x013B, tiffAscii, asciiValue(info.Author)}, // Artist
+			{0x8769, tiffLong, longValue(exifOffset)},    // ExifIFD
+		}
+	}
+
+	tiff := []byte("MM\x00\x2A\x00\x00\x00\x08")
+	exifOffset := uint32(len(tiff) + len(buildIfd(ifd0(0), 8)))
+	tiff = append(tiff, buildIfd(ifd0(exifOffset), 8)...)
+	return append(tiff, buildIfd(exifIfd, exifOffset)...)
+}
+
+// 截取字符串不超过 n 字节的前缀，不拆分字符
+func truncateBytes(str string, n int) string {
+	if len(str) <= n {
+		return str
+	}
+	for n > 0 && !utf8.RuneStart(str[n]) {
+		n--
+	}
+	return str[:n]
+}
+
+func escapeXml(str string) string {
+	buf := bytes.Buffer{}
+	xml.EscapeText(&buf, []byte(str))
+	return buf.String()
+}
+
+const xmpTemplate = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/">
+   <dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>
+   <dc:description><rdf:Alt><rdf:li xml:lang="x-default">%s</rdf:li></rdf:Alt></dc:description>
+   <dc:source>%s</dc:source>
+   <xmp:CreateDate>%s</xmp:CreateDate>
+   <photoshop:DateCreated>%s</photoshop:DateCreated>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+// 生成 XMP 数据包，超过 limit 字节时截短推文内容
+func buildXmp(info *Info, limit int) []byte {
+	date := info.Date.Format(time.RFC3339)
+	text := info.Text
+	for {
+		packet := fmt.Sprintf(xmpTemplate, escapeXml(info.Author), escapeXml(text), escapeXml(info.Url), date, date)
+		if len(packet) <= limit || text == "" {
+			return []byte(packet)
+		}
+		n := utf8.RuneCountInString(text)
+		text = string([]rune(text)[:n/2])
+	}
+}
@@ -0,0 +1,81 @@
+package metadata
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 写入媒体文件的推文信息
+type Info struct {
+	Url    string // 推文链接
+	Author string
+	Date   time.Time // 推文发布时间
+	Text   string
+}
+
+var ErrUnsupported = errors.New("unsupported media format")
+
+type embedder func(src io.ReaderAt, size int64, dst io.Writer, info *Info) error
+
+// 将推文信息写入媒体文件，支持 jpg, png, mp4, m4a，其他格式返回 ErrUnsupported。
+// 写入临时文件后替换原文件，修改时间保持不变
+func Embed(path string, info *Info) error {
+	var embed embedder
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		embed = embedJpeg
+	case ".png":
+		embed = embedPng
+	case ".mp4", ".m4a", ".m4v", ".mov":
+		embed = embedMp4
+	default:
+		return ErrUnsupported
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".meta"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = embed(src, stat.Size(), dst, info)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if e := dst.Close(); err == nil {
+		err = e
+	}
+	src.Close()
+	if err == nil {
+		err = os.Chtimes(tmp, time.Time{}, stat.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func readAll(src io.ReaderAt, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := src.ReadAt(data, 0)
+	if err == io.EOF {
+		err = nil
+	}
+	return data, err
+}
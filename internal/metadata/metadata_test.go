@@ -0,0 +1,139 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testInfo = Info{
+	Url:    "https://x.com/screen/status/1",
+	Author: "name(screen)",
+	Date:   time.Date(2024, 7, 1, 8, 0, 0, 0, time.UTC),
+	Text:   "测试 <tweet> & text",
+}
+
+// 将 testdata 中的文件复制到临时目录，写入两次元数据后返回其内容
+func embedFixture(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 7, 1, 8, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, time.Time{}, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := Embed(path, &testInfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("mtime = %v, want %v", info.ModTime(), mtime)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("temporary files are left: %v", entries)
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestEmbedJpeg(t *testing.T) {
+	data := embedFixture(t, "sample.jpg")
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte(xmpHeader)); n != 1 {
+		t.Errorf("%d xmp segments, want 1", n)
+	}
+	if n := bytes.Count(data, []byte(exifHeader)); n != 1 {
+		t.Errorf("%d exif segments, want 1", n)
+	}
+	for _, want := range []string{testInfo.Url, "测试 &lt;tweet&gt; &amp; text", "2024-07-01T08:00:00Z", "name(screen)\x00"} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("%q is not embedded", want)
+		}
+	}
+}
+
+func TestEmbedPng(t *testing.T) {
+	data := embedFixture(t, "sample.png")
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	chunks := map[string]int{}
+	for pos := len(pngSignature); pos < len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		if typ == "tEXt" || typ == "iTXt" {
+			keyword, _, _ := bytes.Cut(data[pos+8:], []byte{0})
+			chunks[typ+":"+string(keyword)]++
+		}
+		pos += 12 + length
+	}
+	want := map[string]int{"tEXt:URL": 1, "tEXt:Author": 1, "tEXt:Creation Time": 1, "iTXt:Description": 1}
+	if len(chunks) != len(want) {
+		t.Errorf("text chunks = %v, want %v", chunks, want)
+	}
+	for k, v := range want {
+		if chunks[k] != v {
+			t.Errorf("text chunks = %v, want %v", chunks, want)
+			break
+		}
+	}
+}
+
+func TestEmbedMp4(t *testing.T) {
+	data := embedFixture(t, "sample.mp4")
+	if n := bytes.Count(data, []byte("\xa9cmt")); n != 1 {
+		t.Errorf("%d comment atoms, want 1", n)
+	}
+	for _, want := range []string{testInfo.Url, testInfo.Text, "2024-07-01T08:00:00Z"} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("%q is not embedded", want)
+		}
+	}
+
+	// 块偏移仍然指向原来的数据
+	pos := bytes.Index(data, []byte("stco"))
+	if pos < 0 {
+		t.Fatal("stco not found")
+	}
+	count := int(binary.BigEndian.Uint32(data[pos+8:]))
+	chunks := []string{"chunk-one", "chunk-two"}
+	if count != len(chunks) {
+		t.Fatalf("stco has %d entries, want %d", count, len(chunks))
+	}
+	for i, chunk := range chunks {
+		offset := int(binary.BigEndian.Uint32(data[pos+12+i*4:]))
+		if offset+len(chunk) > len(data) || string(data[offset:offset+len(chunk)]) != chunk {
+			t.Errorf("chunk %d at %d does not point to %q", i, offset, chunk)
+		}
+	}
+}
+
+func TestEmbedUnsupported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.ts")
+	os.WriteFile(path, []byte("ts"), 0644)
+	if err := Embed(path, &testInfo); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("err = %v, want ErrUnsupported", err)
+	}
+}
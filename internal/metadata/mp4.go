@@ -0,0 +1,225 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// 以 moov 之前的数据，新的 moov，moov 之后的数据的顺序重写文件。
+// 元数据写入 moov/udta/meta/ilst，moov 变长后后移位于其后的块偏移（stco/co64）
+func embedMp4(src io.ReaderAt, size int64, dst io.Writer, info *Info) error {
+	moovOffset, moovSize := int64(-1), int64(0)
+	for pos := int64(0); pos < size; {
+		boxSize, typ, err := readBoxHeader(src, pos, size)
+		if err != nil {
+			return err
+		}
+		if typ == "moov" {
+			moovOffset, moovSize = pos, boxSize
+			break
+		}
+		pos += boxSize
+	}
+	if moovOffset < 0 {
+		return fmt.Errorf("invalid mp4: moov not found")
+	}
+
+	moov := make([]byte, moovSize)
+	if _, err := src.ReadAt(moov, moovOffset); err != nil {
+		return err
+	}
+	children, err := parseBoxes(moov[boxHeaderSize(moov):])
+	if err != nil {
+		return err
+	}
+
+	meta := buildMp4Meta(info)
+	udta := -1
+	for i, child := range children {
+		if boxType(child) == "udta" {
+			udta = i
+		}
+	}
+	if udta < 0 {
+		children = append(children, mp4Box("udta", meta))
+	} else {
+		items, err := parseBoxes(children[udta][boxHeaderSize(children[udta]):])
+		if err != nil {
+			return err
+		}
+		kept := [][]byte{}
+		for _, item := range items {
+			if boxType(item) != "meta" {
+				kept = append(kept, item)
+			}
+		}
+		children[udta] = mp4Box("udta", append(kept, meta)...)
+	}
+	newMoov := mp4Box("moov", children...)
+
+	delta := int64(len(newMoov)) - moovSize
+	if err := shiftChunkOffsets(newMoov[boxHeaderSize(newMoov):], moovOffset+moovSize, delta); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, io.NewSectionReader(src, 0, moovOffset)); err != nil {
+		return err
+	}
+	if _, err := dst.Write(newMoov); err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, io.NewSectionReader(src, moovOffset+moovSize, size-moovOffset-moovSize))
+	return err
+}
+
+// 读取 pos 处的盒子头，返回盒子（包括头部）的长度和类型
+func readBoxHeader(src io.ReaderAt, pos int64, size int64) (int64, string, error) {
+	header := make([]byte, 16)
+	n, err := src.ReadAt(header, pos)
+	if n < 8 {
+		if err == nil || err == io.EOF {
+			err = fmt.Errorf("invalid mp4: truncated box at %d", pos)
+		}
+		return 0, "", err
+	}
+
+	boxSize, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+	switch boxSize {
+	case 0:
+		boxSize = size - pos
+	case 1:
+		if n < 16 {
+			return 0, "", fmt.Errorf("invalid mp4: truncated box at %d", pos)
+		}
+		boxSize, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+	}
+	if boxSize < headerSize || pos+boxSize > size {
+		return 0, "", fmt.Errorf("invalid mp4: bad box size at %d", pos)
+	}
+	return boxSize, string(header[4:8]), nil
+}
+
+func boxType(box []byte) string {
+	return string(box[4:8])
+}
+
+func boxHeaderSize(box []byte) int {
+	if binary.BigEndian.Uint32(box) == 1 {
+		return 16
+	}
+	return 8
+}
+
+// 拆分连续的盒子
+func parseBoxes(data []byte) ([][]byte, error) {
+	boxes := [][]byte{}
+	for pos := 0; pos < len(data); {
+		boxSize, _, err := readBoxHeader(byteReaderAt(data), int64(pos), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		boxes = append(boxes, data[pos:pos+int(boxSize)])
+		pos += int(boxSize)
+	}
+	return boxes, nil
+}
+
+type byteReaderAt []byte
+
+func (b byteReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func mp4Box(typ string, children ...[]byte) []byte {
+	size := 8
+	for _, child := range children {
+		size += len(child)
+	}
+	box := binary.BigEndian.AppendUint32(nil, uint32(size))
+	box = append(box, typ...)
+	for _, child := range children {
+		box = append(box, child...)
+	}
+	return box
+}
+
+// iTunes 风格的元数据：©cmt 推文链接，©day 发布时间，©ART 作者，©des 推文内容
+func buildMp4Meta(info *Info) []byte {
+	item := func(typ string, value string) []byte {
+		data := []byte{0, 0, 0, 1, 0, 0, 0, 0} // UTF-8，默认语言
+		return mp4Box(typ, mp4Box("data", append(data, value...)))
+	}
+	ilst := mp4Box("ilst",
+		item("\xa9cmt", info.Url),
+		item("\xa9day", info.Date.Format(time.RFC3339)),
+		item("\xa9ART", info.Author),
+		item("\xa9des", info.Text),
+	)
+
+	hdlr := []byte{0, 0, 0, 0, 0, 0, 0, 0} // version/flags, pre_defined
+	hdlr = append(hdlr, "mdir"...)
+	hdlr = append(hdlr, "appl"...)
+	hdlr = append(hdlr, make([]byte, 8+1)...) // reserved, 空名称
+	return mp4Box("meta", []byte{0, 0, 0, 0}, mp4Box("hdlr", hdlr), ilst)
+}
+
+// 将 data 中所有轨道内不小于 threshold 的块偏移加上 delta
+func shiftChunkOffsets(data []byte, threshold int64, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	boxes, err := parseBoxes(data)
+	if err != nil {
+		return err
+	}
+	for _, box := range boxes {
+		payload := box[boxHeaderSize(box):]
+		switch boxType(box) {
+		case "trak", "mdia", "minf", "stbl":
+			if err := shiftChunkOffsets(payload, threshold, delta); err != nil {
+				return err
+			}
+		case "stco", "co64":
+			width := 4
+			if boxType(box) == "co64" {
+				width = 8
+			}
+			if len(payload) < 8 {
+				return fmt.Errorf("invalid mp4: truncated %s", boxType(box))
+			}
+			count := int(binary.BigEndian.Uint32(payload[4:]))
+			entries := payload[8:]
+			if count*width > len(entries) {
+				return fmt.Errorf("invalid mp4: truncated %s", boxType(box))
+			}
+			for i := 0; i < count; i++ {
+				entry := entries[i*width:]
+				if width == 8 {
+					if offset := int64(binary.BigEndian.Uint64(entry)); offset >= threshold {
+						binary.BigEndian.PutUint64(entry, uint64(offset+delta))
+					}
+					continue
+				}
+				offset := int64(binary.BigEndian.Uint32(entry))
+				if offset < threshold {
+					continue
+				}
+				if offset+delta > math.MaxUint32 {
+					return fmt.Errorf("chunk offset overflows stco")
+				}
+				binary.BigEndian.PutUint32(entry, uint32(offset+delta))
+			}
+		}
+	}
+	return nil
+}
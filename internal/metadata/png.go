@@ -0,0 +1,100 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"time"
+)
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// 在 IHDR 之后插入文本块，内容为 ASCII 时使用 tEXt，否则使用 UTF-8 编码的 iTXt。
+// 已有的同名文本块被替换
+func embedPng(src io.ReaderAt, size int64, dst io.Writer, info *Info) error {
+	data, err := readAll(src, size)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return fmt.Errorf("invalid png: missing signature")
+	}
+
+	texts := [][2]string{
+		{"URL", info.Url},
+		{"Author", info.Author},
+		{"Creation Time", info.Date.Format(time.RFC1123Z)},
+		{"Description", info.Text},
+	}
+	keywords := []string{}
+	for _, text := range texts {
+		keywords = append(keywords, text[0])
+	}
+
+	if _, err := dst.Write([]byte(pngSignature)); err != nil {
+		return err
+	}
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if pos+12 > len(data) {
+			return fmt.Errorf("invalid png: truncated chunk at %d", pos)
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		end := pos + 12 + length
+		if end > len(data) {
+			return fmt.Errorf("invalid png: bad chunk length at %d", pos)
+		}
+		chunk := data[pos:end]
+		pos = end
+
+		if typ == "tEXt" || typ == "iTXt" || typ == "zTXt" {
+			keyword, _, _ := bytes.Cut(chunk[8:8+length], []byte{0})
+			if slices.Contains(keywords, string(keyword)) {
+				continue
+			}
+		}
+		if _, err := dst.Write(chunk); err != nil {
+			return err
+		}
+		if typ != "IHDR" {
+			continue
+		}
+		for _, text := range texts {
+			if _, err := dst.Write(pngTextChunk(text[0], text[1])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isAscii(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if str[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func pngTextChunk(keyword string, text string) []byte {
+	typ := "tEXt"
+	data := append([]byte(keyword), 0)
+	if !isAscii(text) {
+		typ = "iTXt"
+		data = append(data, 0, 0, 0, 0) // 未压缩，语言标签和译名为空
+	}
+	data = append(data, text...)
+	return pngChunk(typ, data)
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
@@ -0,0 +1,456 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"github.com/unkmonster/tmd/internal/database"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 200
+)
+
+type userJson struct {
+	Id          uint64 `json:"id"`
+	ScreenName  string `json:"screen_name"`
+	Name        string `json:"name"`
+	IsProtected bool   `json:"protected"`
+}
+
+type userEntityJson struct {
+	Id  int32  `json:"id"`
+	Dir string `json:"dir"` // 相对于存储目录，位于额外的根目录下时为绝对路径
+}
+
+type previousNameJson struct {
+	ScreenName string    `json:"screen_name"`
+	Name       string    `json:"name"`
+	RecordDate time.Time `json:"record_date"`
+}
+
+type listJson struct {
+	Id    int32  `json:"id"`
+	LstId int64  `json:"list_id"`
+	Name  string `json:"name"`
+	Dir   string `json:"dir"`
+}
+
+type mediaJson struct {
+	TweetId   uint64    `json:"tweet_id"`
+	Url       string    `json:"url"`            // 媒体的原始链接
+	File      string    `json:"file,omitempty"` // 本地文件的链接，文件不在任何根目录下时为空
+	Size      int64     `json:"size"`
+	Text      string    `json:"text,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type tweetJson struct {
+	Id        uint64       `json:"id"`
+	UserId    uint64       `json:"user_id"`
+	Text      string       `json:"text"`
+	CreatedAt time.Time    `json:"created_at"`
+	Url       string       `json:"url"`
+	Media     []*mediaJson `json:"media"`
+}
+
+type pageJson struct {
+	Total int `json:"total"`
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+	Items any `json:"items"`
+}
+
+type server struct {
+	db    *sqlx.DB
+	roots []string // 首个为存储目录，其余为额外的根目录
+}
+
+// 创建只读的 HTTP 服务：/api 下为 JSON 接口，/files 下为存储目录 root 中的文件，
+// /roots/<n> 下为第 n 个（从 1 开始）额外的根目录中的文件，/ 为搜索页面
+//
+//	GET /api/users                    用户，分页
+//	GET /api/users/{id}               用户及其实体和曾用名
+//	GET /api/users/{id}/names         曾用名
+//	GET /api/users/{id}/tweets?q=     用户的推文，分页，q 过滤推文内容
+//	GET /api/users/{id}/media         用户已下载的媒体，分页
+//	GET /api/lists                    列表
+//	GET /api/lists/{id}/users         列表中的用户
+//	GET /api/search?q=                搜索推文内容，分页
+//
+// 分页参数为 page（从 1 开始）和 limit
+func New(db *sqlx.DB, root string, extraRoots ...string) http.Handler {
+	s := &server{db: db, roots: append([]string{root}, extraRoots...)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/users", s.handleUsers)
+	mux.HandleFunc("GET /api/users/{id}", s.handleUser)
+	mux.HandleFunc("GET /api/users/{id}/names", s.handleUserNames)
+	mux.HandleFunc("GET /api/users/{id}/tweets", s.handleUserTweets)
+	mux.HandleFunc("GET /api/users/{id}/media", s.handleUserMedia)
+	mux.HandleFunc("GET /api/lists", s.handleLists)
+	mux.HandleFunc("GET /api/lists/{id}/users", s.handleListUsers)
+	mux.HandleFunc("GET /api/search", s.handleSearch)
+	for i, root := range s.roots {
+		prefix := s.rootPrefix(i)
+		mux.Handle("GET "+prefix+"/", http.StripPrefix(prefix, s.fileHandler(root)))
+	}
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(indexHtml))
+	})
+	return mux
+}
+
+func writeJson(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithField("server", "json").Warnln("failed to write response:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	if code == http.StatusInternalServerError {
+		log.WithField("server", "api").Errorln(err)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// 解析分页参数，返回页码，每页数量和偏移
+func pagination(r *http.Request) (int, int, int) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultLimit
+	}
+	limit = min(limit, maxLimit)
+	return page, limit, (page - 1) * limit
+}
+
+func pathId(r *http.Request) (uint64, error) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid id: " + r.PathValue("id"))
+	}
+	return id, nil
+}
+
+// 第 i 个根目录中的文件的链接前缀
+func (s *server) rootPrefix(i int) string {
+	if i == 0 {
+		return "/files"
+	}
+	return "/roots/" + strconv.Itoa(i)
+}
+
+// 包含 p 的根目录的序号及 p 相对于它的路径，不在任何根目录下时返回 -1
+func (s *server) locate(p string) (int, string) {
+	for i, root := range s.roots {
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return i, filepath.ToSlash(rel)
+	}
+	return -1, ""
+}
+
+// 相对于存储目录的路径，位于额外的根目录下时返回绝对路径，不在任何根目录下时返回空串
+func (s *server) relPath(p string) string {
+	i, rel := s.locate(p)
+	switch {
+	case i < 0:
+		return ""
+	case i > 0:
+		return filepath.ToSlash(p)
+	}
+	return rel
+}
+
+func (s *server) fileUrl(p string) string {
+	i, rel := s.locate(p)
+	if i < 0 {
+		return ""
+	}
+	return (&url.URL{Path: s.rootPrefix(i) + "/" + rel}).EscapedPath()
+}
+
+func (s *server) mediaJson(m *database.TweetMedia) *mediaJson {
+	return &mediaJson{TweetId: m.TweetId, Url: m.Url, File: s.fileUrl(m.Path.String), Size: m.Size.Int64}
+}
+
+func (s *server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	page, limit, offset := pagination(r)
+	users, total, err := database.GetUsers(s.db, offset, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	items := []*userJson{}
+	for _, u := range users {
+		items = append(items, &userJson{u.Id, u.ScreenName, u.Name, u.IsProtected})
+	}
+	writeJson(w, &pageJson{total, page, limit, items})
+}
+
+func (s *server) previousNames(uid uint64) ([]*previousNameJson, error) {
+	names, err := database.GetUserPreviousNames(s.db, uid)
+	if err != nil {
+		return nil, err
+	}
+	items := []*previousNameJson{}
+	for _, n := range names {
+		items = append(items, &previousNameJson{n.ScreenName, n.Name, n.RecordDate})
+	}
+	return items, nil
+}
+
+func (s *server) handleUser(w http.ResponseWriter, r *http.Request) {
+	uid, err := pathId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	usr, err := database.GetUserById(s.db, uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if usr == nil {
+		writeError(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+	entities, err := database.GetUserEntitiesByUid(s.db, uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	names, err := s.previousNames(uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	result := struct {
+		*userJson
+		Entities      []*userEntityJson   `json:"entities"`
+		PreviousNames []*previousNameJson `json:"previous_names"`
+	}{&userJson{usr.Id, usr.ScreenName, usr.Name, usr.IsProtected}, []*userEntityJson{}, names}
+	for _, e := range entities {
+		result.Entities = append(result.Entities, &userEntityJson{e.Id.Int32, s.relPath(e.Path())})
+	}
+	writeJson(w, &result)
+}
+
+func (s *server) handleUserNames(w http.ResponseWriter, r *http.Request) {
+	uid, err := pathId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	names, err := s.previousNames(uid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJson(w, names)
+}
+
+// 分页搜索推文，附带推文已下载的媒体
+func (s *server) searchTweets(w http.ResponseWriter, r *http.Request, uid uint64) {
+	page, limit, offset := pagination(r)
+	tweets, total, err := database.SearchTweets(s.db, uid, r.URL.Query().Get("q"), offset, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	items := []*tweetJson{}
+	for _, tw := range tweets {
+		item := &tweetJson{
+			Id:        tw.Id,
+			UserId:    tw.Uid,
+			Text:      tw.Text,
+			CreatedAt: tw.CreatedAt,
+			Url:       "https://x.com/i/status/" + strconv.FormatUint(tw.Id, 10),
+			Media:     []*mediaJson{},
+		}
+		media, err := database.GetTweetMedia(s.db, tw.Id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		for _, m := range media {
+			if m.Status == database.MS_DOWNLOADED {
+				item.Media = append(item.Media, s.mediaJson(m))
+			}
+		}
+		items = append(items, item)
+	}
+	writeJson(w, &pageJson{total, page, limit, items})
+}
+
+func (s *server) handleUserTweets(w http.ResponseWriter, r *http.Request) {
+	uid, err := pathId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.searchTweets(w, r, uid)
+}
+
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	s.searchTweets(w, r, 0)
+}
+
+func (s *server) handleUserMedia(w http.ResponseWriter, r *http.Request) {
+	uid, err := pathId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	page, limit, offset := pagination(r)
+	media, total, err := database.GetUserDownloadedMedia(s.db, uid, offset, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	items := []*mediaJson{}
+	for _, m := range media {
+		item := s.mediaJson(&m.TweetMedia)
+		item.Text = m.Text
+		item.CreatedAt = m.CreatedAt
+		items = append(items, item)
+	}
+	writeJson(w, &pageJson{total, page, limit, items})
+}
+
+func (s *server) handleLists(w http.ResponseWriter, r *http.Request) {
+	entities, err := database.GetLstEntities(s.db)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	items := []*listJson{}
+	for _, e := range entities {
+		items = append(items, &listJson{e.Id.Int32, e.LstId, e.Name, s.relPath(e.Path())})
+	}
+	writeJson(w, items)
+}
+
+func (s *server) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	links, err := database.GetLstEntityUserLinks(s.db, int32(id))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	items := []*userJson{}
+	for _, link := range links {
+		usr, err := database.GetUserById(s.db, link.Uid)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if usr != nil {
+			items = append(items, &userJson{usr.Id, usr.ScreenName, usr.Name, usr.IsProtected})
+		}
+	}
+	writeJson(w, items)
+}
+
+// 提供根目录 root 中的文件，不提供目录和以 . 开头的文件
+func (s *server) fileHandler(root string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		for _, segment := range strings.Split(name, "/") {
+			if strings.HasPrefix(segment, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		p := filepath.Join(root, filepath.FromSlash(name))
+		info, err := os.Stat(p)
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		http.ServeFile(w, r, p)
+	})
+}
+
+const indexHtml = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>tmd</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+.tweet { border-bottom: 1px solid #ddd; padding: 8px 0; }
+.tweet img, .tweet video { max-height: 200px; margin: 4px 4px 0 0; }
+.date { color: #666; font-size: 12px; }
+</style>
+</head>
+<body>
+<form id="search"><input name="q" placeholder="search tweets" autofocus> <button>search</button></form>
+<p id="summary"></p>
+<div id="results"></div>
+<script>
+const form = document.getElementById("search");
+form.addEventListener("submit", async (e) => {
+  e.preventDefault();
+  const q = new FormData(form).get("q");
+  const resp = await fetch("/api/search?limit=100&q=" + encodeURIComponent(q));
+  const page = await resp.json();
+  document.getElementById("summary").textContent = page.total + " tweets";
+  const results = document.getElementById("results");
+  results.replaceChildren();
+  for (const tweet of page.items) {
+    const div = document.createElement("div");
+    div.className = "tweet";
+    const text = document.createElement("p");
+    text.textContent = tweet.text;
+    const meta = document.createElement("a");
+    meta.className = "date";
+    meta.href = tweet.url;
+    meta.textContent = new Date(tweet.created_at).toLocaleString();
+    div.append(text, meta, document.createElement("br"));
+    for (const m of tweet.media) {
+      if (!m.file) continue;
+      const el = document.createElement(/\.(mp4|mov)$/i.test(m.file) ? "video" : /\.(m4a|aac|mp3)$/i.test(m.file) ? "audio" : "img");
+      el.src = m.file;
+      if (el.tagName === "IMG") { el.loading = "lazy"; } else { el.controls = true; el.preload = "metadata"; }
+      div.append(el);
+    }
+    results.append(div);
+  }
+});
+</script>
+</body>
+</html>
+`
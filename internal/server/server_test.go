@@ -0,0 +1,217 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/unkmonster/tmd/internal/database"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, string) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, ".data"), 0755)
+	db, err := sqlx.Connect("sqlite3", fmt.Sprintf("file:%s", filepath.Join(root, ".data", "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	database.CreateTables(db)
+
+	must := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(database.CreateUser(db, &database.User{Id: 1, ScreenName: "alice", Name: "Alice"}))
+	must(database.CreateUser(db, &database.User{Id: 2, ScreenName: "bob", Name: "Bob"}))
+	must(database.RecordUserPreviousName(db, 1, "Old Alice", "old_alice"))
+
+	entity := &database.UserEntity{Uid: 1, Name: "Alice(alice)", ParentDir: filepath.Join(root, "users")}
+	must(database.CreateUserEntity(db, entity))
+	must(os.MkdirAll(entity.Path(), 0755))
+	must(os.WriteFile(filepath.Join(entity.Path(), "a b.jpg"), []byte("jpg"), 0644))
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 5; i++ {
+		text := fmt.Sprintf("tweet %d", i)
+		if i == 3 {
+			text = "100% cat"
+		}
+		must(database.RecordTweet(db, &database.Tweet{Id: uint64(i), Uid: 1, Text: text, CreatedAt: base.Add(time.Duration(i) * time.Hour)}))
+	}
+	must(database.RecordTweet(db, &database.Tweet{Id: 10, Uid: 2, Text: "bob's cat", CreatedAt: base}))
+	must(database.RecordTweetMedia(db, &database.TweetMedia{
+		TweetId:  3,
+		EntityId: entity.Id.Int32,
+		Url:      "https://pbs.twimg.com/media/a.jpg",
+		Path:     sql.NullString{String: filepath.Join(entity.Path(), "a b.jpg"), Valid: true},
+		Size:     sql.NullInt64{Int64: 3, Valid: true},
+		Status:   database.MS_DOWNLOADED,
+	}))
+
+	lst := &database.LstEntity{LstId: 7, Name: "cats", ParentDir: root}
+	must(database.CreateLstEntity(db, lst))
+	must(database.CreateUserLink(db, &database.UserLink{Uid: 2, Name: "Bob(bob)", ParentLstEntityId: lst.Id.Int32}))
+
+	// 位于额外根目录下的用户
+	extra := t.TempDir()
+	bob := &database.UserEntity{Uid: 2, Name: "Bob(bob)", ParentDir: filepath.Join(extra, "users")}
+	must(database.CreateUserEntity(db, bob))
+	must(os.MkdirAll(bob.Path(), 0755))
+	must(os.WriteFile(filepath.Join(bob.Path(), "c.jpg"), []byte("bob"), 0644))
+	must(database.RecordTweetMedia(db, &database.TweetMedia{
+		TweetId:  10,
+		EntityId: bob.Id.Int32,
+		Url:      "https://pbs.twimg.com/media/c.jpg",
+		Path:     sql.NullString{String: filepath.Join(bob.Path(), "c.jpg"), Valid: true},
+		Size:     sql.NullInt64{Int64: 3, Valid: true},
+		Status:   database.MS_DOWNLOADED,
+	}))
+
+	server := httptest.NewServer(New(db, root, extra))
+	t.Cleanup(server.Close)
+	return server, root
+}
+
+func getJson(t *testing.T, url string, v any) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("%s: %v", url, err)
+	}
+	return resp.StatusCode
+}
+
+type testPage[T any] struct {
+	Total int `json:"total"`
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+	Items []T `json:"items"`
+}
+
+func TestApi(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	users := testPage[userJson]{}
+	getJson(t, server.URL+"/api/users?limit=1&page=2", &users)
+	if users.Total != 2 || len(users.Items) != 1 || users.Items[0].ScreenName != "bob" {
+		t.Errorf("users = %+v", users)
+	}
+
+	user := struct {
+		ScreenName    string             `json:"screen_name"`
+		Entities      []userEntityJson   `json:"entities"`
+		PreviousNames []previousNameJson `json:"previous_names"`
+	}{}
+	getJson(t, server.URL+"/api/users/1", &user)
+	if user.ScreenName != "alice" || len(user.Entities) != 1 || user.Entities[0].Dir != "users/Alice(alice)" ||
+		len(user.PreviousNames) != 1 || user.PreviousNames[0].ScreenName != "old_alice" {
+		t.Errorf("user = %+v", user)
+	}
+	if code := getJson(t, server.URL+"/api/users/100", &map[string]string{}); code != http.StatusNotFound {
+		t.Errorf("status of missing user = %d", code)
+	}
+	if code := getJson(t, server.URL+"/api/users/abc", &map[string]string{}); code != http.StatusBadRequest {
+		t.Errorf("status of invalid id = %d", code)
+	}
+
+	// 从新到旧分页
+	tweets := testPage[tweetJson]{}
+	getJson(t, server.URL+"/api/users/1/tweets?limit=2&page=2", &tweets)
+	if tweets.Total != 5 || len(tweets.Items) != 2 || tweets.Items[0].Id != 3 || tweets.Items[1].Id != 2 {
+		t.Errorf("tweets = %+v", tweets)
+	}
+	if len(tweets.Items) != 0 && (len(tweets.Items[0].Media) != 1 || tweets.Items[0].Media[0].File != "/files/users/Alice%28alice%29/a%20b.jpg") {
+		t.Errorf("media of tweet 3 = %+v", tweets.Items[0].Media)
+	}
+
+	// % 按字面匹配
+	search := testPage[tweetJson]{}
+	getJson(t, server.URL+"/api/search?q=%25", &search)
+	if search.Total != 1 || search.Items[0].Id != 3 {
+		t.Errorf("search %% = %+v", search)
+	}
+	getJson(t, server.URL+"/api/search?q=cat", &search)
+	if search.Total != 2 {
+		t.Errorf("search cat = %+v", search)
+	}
+
+	media := testPage[mediaJson]{}
+	getJson(t, server.URL+"/api/users/1/media", &media)
+	if media.Total != 1 || media.Items[0].TweetId != 3 || media.Items[0].Text != "100% cat" {
+		t.Errorf("media = %+v", media)
+	}
+
+	lists := []listJson{}
+	getJson(t, server.URL+"/api/lists", &lists)
+	if len(lists) != 1 || lists[0].Name != "cats" {
+		t.Fatalf("lists = %+v", lists)
+	}
+	members := []userJson{}
+	getJson(t, server.URL+fmt.Sprintf("/api/lists/%d/users", lists[0].Id), &members)
+	if len(members) != 1 || members[0].ScreenName != "bob" {
+		t.Errorf("list users = %+v", members)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	resp, err := http.Get(server.URL + "/files/users/Alice%28alice%29/a%20b.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "jpg" {
+		t.Errorf("file: %d %q", resp.StatusCode, data)
+	}
+
+	for _, path := range []string{"/files/.data/test.db", "/files/users/../.data/test.db", "/files/users"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, resp.StatusCode)
+		}
+	}
+
+	media := testPage[mediaJson]{}
+	getJson(t, server.URL+"/api/users/2/media", &media)
+	if len(media.Items) != 1 || media.Items[0].File != "/roots/1/users/Bob%28bob%29/c.jpg" {
+		t.Fatalf("media in extra root = %+v", media)
+	}
+	resp, err = http.Get(server.URL + media.Items[0].File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "bob" {
+		t.Errorf("file in extra root: %d %q", resp.StatusCode, data)
+	}
+
+	resp, err = http.Post(server.URL+"/api/users", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d, want 405", resp.StatusCode)
+	}
+}
@@ -3,11 +3,26 @@ package twitter
 import (
 	"fmt"
 	"net/url"
+	"strings"
 )
 
-const HOST = "https://x.com"
+// API 服务器的地址，测试时可指向本地的服务器
+var HOST = "https://x.com"
+
+// 媒体服务器的域名后缀，请求媒体时不进行速率限制和计数
+var MediaHost = "twimg.com"
+
 const AvgTweetsPerPage = 70
 
+func isApiHost(u *url.URL) bool {
+	host, _ := url.Parse(HOST)
+	return u.Host == host.Host
+}
+
+func isMediaHost(u *url.URL) bool {
+	return strings.HasSuffix(u.Host, MediaHost)
+}
+
 type api interface {
 	Path() string
 	QueryParam() url.Values
@@ -18,10 +33,26 @@ type timelineApi interface {
 	api
 }
 
+// API 的路径和参数，已发现的操作使用发现的 query id 和功能开关
+func resolveApi(api api) (string, url.Values) {
+	path, params := api.Path(), api.QueryParam()
+	if d := discovered.Load(); d != nil {
+		path = d.apply(path, params)
+	}
+	return path, params
+}
+
+// API 的路径，用于区分速率限制
+func apiPath(api api) string {
+	path, _ := resolveApi(api)
+	return path
+}
+
 func makeUrl(api api) string {
+	path, params := resolveApi(api)
 	u, _ := url.Parse(HOST) // 这里绝对不会出错
-	u = u.JoinPath(api.Path())
-	u.RawQuery = api.QueryParam().Encode()
+	u = u.JoinPath(path)
+	u.RawQuery = params.Encode()
 	return u.String()
 }
 
@@ -90,6 +121,72 @@ func (a *userMedia) SetCursor(cursor string) {
 	a.cursor = cursor
 }
 
+type tweetResultByRestId struct {
+	id uint64
+}
+
+func (*tweetResultByRestId) Path() string {
+	return "/i/api/graphql/Xl5pC_lBk_gcO2ItU39DQw/TweetResultByRestId"
+}
+
+func (a *tweetResultByRestId) QueryParam() url.Values {
+	v := url.Values{}
+
+	variables := `{"tweetId":"%d","withCommunity":false,"includePromotedContent":false,"withVoice":true}`
+	features := `{"creator_subscriptions_tweet_preview_api_enabled":true,"communities_web_enable_tweet_community_results_fetch":true,"c9s_tweet_anatomy_moderator_badge_enabled":true,"articles_preview_enabled":true,"tweetypie_unmention_optimization_enabled":true,"responsive_web_edit_tweet_api_enabled":true,"graphql_is_translatable_rweb_tweet_is_translatable_enabled":true,"view_counts_everywhere_api_enabled":true,"longform_notetweets_consumption_enabled":true,"responsive_web_twitter_article_tweet_consumption_enabled":true,"tweet_awards_web_tipping_enabled":false,"creator_subscriptions_quote_tweet_preview_enabled":false,"freedom_of_speech_not_reach_fetch_enabled":true,"standardized_nudges_misinfo":true,"tweet_with_visibility_results_prefer_gql_limited_actions_policy_enabled":true,"rweb_video_timestamps_enabled":true,"longform_notetweets_rich_text_read_enabled":true,"longform_notetweets_inline_media_enabled":true,"rweb_tipjar_consumption_enabled":true,"responsive_web_graphql_exclude_directive_enabled":true,"verified_phone_label_enabled":false,"responsive_web_graphql_skip_user_profile_image_extensions_enabled":false,"responsive_web_graphql_timeline_navigation_enabled":true,"responsive_web_enhance_cards_enabled":false}`
+	fieldToggles := `{"withArticleRichContentState":false,"withArticlePlainText":false}`
+
+	v.Set("variables", fmt.Sprintf(variables, a.id))
+	v.Set("features", features)
+	v.Set("fieldToggles", fieldToggles)
+	return v
+}
+
+// 按 id 批量获取推文，用于重新获取时间线中缺少 legacy 的推文
+type tweetResultsByRestIds struct {
+	ids []uint64
+}
+
+func (*tweetResultsByRestIds) Path() string {
+	return "/i/api/graphql/BWy5aoI-WvwbkT7B3SU3lA/TweetResultsByRestIds"
+}
+
+func (a *tweetResultsByRestIds) QueryParam() url.Values {
+	v := url.Values{}
+
+	ids := make([]string, 0, len(a.ids))
+	for _, id := range a.ids {
+		ids = append(ids, fmt.Sprintf(`"%d"`, id))
+	}
+	variables := `{"tweetIds":[%s],"includePromotedContent":false,"withBirdwatchNotes":false,"withVoice":true,"withCommunity":false}`
+	features := (&tweetResultByRestId{}).QueryParam().Get("features")
+	fieldToggles := `{"withArticleRichContentState":false,"withArticlePlainText":false}`
+
+	v.Set("variables", fmt.Sprintf(variables, strings.Join(ids, ",")))
+	v.Set("features", features)
+	v.Set("fieldToggles", fieldToggles)
+	return v
+}
+
+type audioSpaceById struct {
+	id string
+}
+
+func (*audioSpaceById) Path() string {
+	return "/i/api/graphql/Uv5R_-Chxbn1FEkyUkSW2w/AudioSpaceById"
+}
+
+func (a *audioSpaceById) QueryParam() url.Values {
+	v := url.Values{}
+
+	variables := `{"id":"%s","isMetatagsQuery":false,"withReplays":true,"withListeners":true}`
+	features := `{"spaces_2022_h2_spaces_communities":true,"spaces_2022_h2_clipping":true,"creator_subscriptions_tweet_preview_api_enabled":true,"communities_web_enable_tweet_community_results_fetch":true,"c9s_tweet_anatomy_moderator_badge_enabled":true,"articles_preview_enabled":true,"tweetypie_unmention_optimization_enabled":true,"responsive_web_edit_tweet_api_enabled":true,"graphql_is_translatable_rweb_tweet_is_translatable_enabled":true,"view_counts_everywhere_api_enabled":true,"longform_notetweets_consumption_enabled":true,"responsive_web_twitter_article_tweet_consumption_enabled":true,"tweet_awards_web_tipping_enabled":false,"creator_subscriptions_quote_tweet_preview_enabled":false,"freedom_of_speech_not_reach_fetch_enabled":true,"standardized_nudges_misinfo":true,"tweet_with_visibility_results_prefer_gql_limited_actions_policy_enabled":true,"rweb_video_timestamps_enabled":true,"longform_notetweets_rich_text_read_enabled":true,"longform_notetweets_inline_media_enabled":true,"rweb_tipjar_consumption_enabled":true,"responsive_web_graphql_exclude_directive_enabled":true,"verified_phone_label_enabled":false,"responsive_web_graphql_skip_user_profile_image_extensions_enabled":false,"responsive_web_graphql_timeline_navigation_enabled":true,"responsive_web_enhance_cards_enabled":false}`
+
+	v.Set("variables", fmt.Sprintf(variables, a.id))
+	v.Set("features", features)
+	return v
+}
+
 type listByRestId struct {
 	id uint64
 }
@@ -182,3 +279,26 @@ func (l *likes) QueryParam() url.Values {
 func (l *likes) SetCursor(cursor string) {
 	l.cursor = cursor
 }
+
+type bookmarks struct {
+	count  int
+	cursor string
+}
+
+func (*bookmarks) Path() string {
+	return "/i/api/graphql/QUjXply7fA7fk05FRyajEg/Bookmarks"
+}
+
+func (a *bookmarks) QueryParam() url.Values {
+	v := url.Values{}
+	variables := `{"count":%d,"includePromotedContent":false, "cursor":"%s"}`
+	features := `{"graphql_timeline_v2_bookmark_timeline":true,"rweb_tipjar_consumption_enabled":true,"responsive_web_graphql_exclude_directive_enabled":true,"verified_phone_label_enabled":false,"creator_subscriptions_tweet_preview_api_enabled":true,"responsive_web_graphql_timeline_navigation_enabled":true,"responsive_web_graphql_skip_user_profile_image_extensions_enabled":false,"communities_web_enable_tweet_community_results_fetch":true,"c9s_tweet_anatomy_moderator_badge_enabled":true,"articles_preview_enabled":true,"responsive_web_edit_tweet_api_enabled":true,"graphql_is_translatable_rweb_tweet_is_translatable_enabled":true,"view_counts_everywhere_api_enabled":true,"longform_notetweets_consumption_enabled":true,"responsive_web_twitter_article_tweet_consumption_enabled":true,"tweet_awards_web_tipping_enabled":false,"creator_subscriptions_quote_tweet_preview_enabled":false,"freedom_of_speech_not_reach_fetch_enabled":true,"standardized_nudges_misinfo":true,"tweet_with_visibility_results_prefer_gql_limited_actions_policy_enabled":true,"rweb_video_timestamps_enabled":true,"longform_notetweets_rich_text_read_enabled":true,"longform_notetweets_inline_media_enabled":true,"responsive_web_enhance_cards_enabled":false}`
+
+	v.Set("variables", fmt.Sprintf(variables, a.count, a.cursor))
+	v.Set("features", features)
+	return v
+}
+
+func (a *bookmarks) SetCursor(cursor string) {
+	a.cursor = cursor
+}
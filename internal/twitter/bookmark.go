@@ -0,0 +1,43 @@
+package twitter
+
+import (
+	"context"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// 获取客户端登录账号的书签（按添加的时间逆序），遇到 until 返回 true 的推文时停止，until 为 nil 则获取全部。
+// 同时返回无法解析而被跳过的条目数
+func GetBookmarks(ctx context.Context, client *resty.Client, until func(*Tweet) (bool, error)) ([]*Tweet, int, error) {
+	api := bookmarks{}
+	api.count = 100
+
+	results := make([]*Tweet, 0)
+	skipped := 0
+	for {
+		itemContents, next, n, err := getTimelineItemContents(ctx, &api, client, "data.bookmark_timeline_v2.timeline.instructions")
+		if err != nil {
+			return nil, 0, withSubject(err, "bookmarks")
+		}
+		if len(itemContents) == 0 {
+			break // empty page
+		}
+
+		tweets, m := collectTweets(ctx, client, itemContents)
+		skipped += n + m
+		for _, tw := range tweets {
+			if until != nil {
+				stop, err := until(tw)
+				if err != nil {
+					return nil, 0, err
+				}
+				if stop {
+					return results, skipped, nil
+				}
+			}
+			results = append(results, tw)
+		}
+		api.SetCursor(next)
+	}
+	return results, skipped, nil
+}
@@ -8,13 +8,13 @@ import (
 	"path/filepath"
 	"regexp"
 	"strconv"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	log "github.com/sirupsen/logrus"
+	"github.com/unkmonster/tmd/internal/cassette"
 	"github.com/unkmonster/tmd/internal/utils"
 )
 
@@ -25,6 +25,9 @@ var clientErrors sync.Map
 var clientRateLimiters sync.Map
 var apiCounts sync.Map
 
+// 不为 nil 时，登录的客户端的请求经过此磁带录制或回放
+var Cassette *cassette.Cassette
+
 func SetClientAuth(client *resty.Client, authToken string, ct0 string) {
 	client.SetAuthToken(bearer)
 	client.SetCookie(&http.Cookie{
@@ -69,22 +72,27 @@ func Login(ctx context.Context, authToken string, ct0 string) (*resty.Client, st
 	client.AddRetryCondition(func(r *resty.Response, err error) bool {
 		// For Twitter API Error
 		v, ok := err.(*TwitterApiError)
-		return ok && r.Request.RawRequest.Host == "x.com" && (v.Code == ErrTimeout || v.Code == ErrOverCapacity || v.Code == ErrDependency)
+		return ok && isApiHost(r.Request.RawRequest.URL) && (v.Code == ErrTimeout || v.Code == ErrOverCapacity || v.Code == ErrDependency)
 	})
 	client.AddRetryCondition(func(r *resty.Response, err error) bool {
 		// For Http 429
 		v, ok := err.(*utils.HttpStatusError)
-		return ok && r.Request.RawRequest.Host == "x.com" && v.Code == 429
+		return ok && isApiHost(r.Request.RawRequest.URL) && v.Code == 429
 	})
 
-	client.SetTransport(&http.Transport{
+	var transport http.RoundTripper = &http.Transport{
 		MaxIdleConns:          0,
 		MaxIdleConnsPerHost:   100,             // 每个主机最大并发连接数
 		IdleConnTimeout:       5 * time.Second, // 连接空闲 n 秒后断开它
 		TLSHandshakeTimeout:   5 * time.Second,
 		ResponseHeaderTimeout: 5 * time.Second,
 		Proxy:                 http.ProxyFromEnvironment,
-	})
+	}
+	if Cassette != nil {
+		// 媒体不经过磁带
+		transport = &cassetteTransport{api: Cassette.Wrap(transport), media: transport}
+	}
+	client.SetTransport(transport)
 
 	screenName, err := GetSelfScreenName(ctx, client)
 	if err != nil {
@@ -95,6 +103,18 @@ func Login(ctx context.Context, authToken string, ct0 string) (*resty.Client, st
 	return client, screenName, nil
 }
 
+type cassetteTransport struct {
+	api   http.RoundTripper
+	media http.RoundTripper
+}
+
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isMediaHost(req.URL) {
+		return t.media.RoundTrip(req)
+	}
+	return t.api.RoundTrip(req)
+}
+
 func GetClientScreenName(client *resty.Client) string {
 	if v, ok := clientScreenNames.Load(client); ok {
 		return v.(string)
@@ -304,7 +324,7 @@ func (rateLimiter *rateLimiter) reset(url *url.URL, resp *resty.Response) {
 }
 
 func (*rateLimiter) shouldWork(url *url.URL) bool {
-	return !strings.HasSuffix(url.Host, "twimg.com")
+	return !isMediaHost(url)
 }
 
 func (rl *rateLimiter) wouldBlock(path string) bool {
@@ -361,7 +381,7 @@ func EnableRequestCounting(client *resty.Client) {
 			return err
 		}
 
-		if strings.HasSuffix(url.Host, "twimg.com") {
+		if isMediaHost(url) {
 			return nil
 		}
 
@@ -397,7 +417,7 @@ func GetSelfScreenName(ctx context.Context, client *resty.Client) (string, error
 	req := client.R().SetContext(ctx).SetHeaders(map[string]string{
 		"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
 	})
-	resp, err := req.Get("https://x.com/home")
+	resp, err := req.Get(HOST + "/home")
 
 	if err != nil {
 		return "", err
@@ -475,5 +495,5 @@ func SelectClient(ctx context.Context, clients []*resty.Client, path string) *re
 }
 
 func SelectUserMediaClient(ctx context.Context, clients []*resty.Client) *resty.Client {
-	return SelectClient(ctx, clients, (&userMedia{}).Path())
+	return SelectClient(ctx, clients, apiPath(&userMedia{}))
 }
@@ -0,0 +1,184 @@
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/unkmonster/tmd/internal/utils"
+)
+
+// 网页客户端中的一个 GraphQL 操作
+type Operation struct {
+	QueryId      string   `json:"query_id"`
+	Features     []string `json:"features"`
+	FieldToggles []string `json:"field_toggles"`
+}
+
+// 从网页客户端发现的 GraphQL 操作和功能开关的值
+type Discovered struct {
+	Operations    map[string]*Operation `json:"operations"`
+	FeatureValues map[string]bool       `json:"feature_values"`
+	UpdatedAt     time.Time             `json:"updated_at"`
+}
+
+var discovered atomic.Pointer[Discovered]
+
+// 使 API 请求使用发现的 query id 和功能开关，为 nil 时使用硬编码的值
+func SetDiscovered(d *Discovered) {
+	discovered.Store(d)
+}
+
+func LoadDiscovered(path string) (*Discovered, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d := Discovered{}
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (d *Discovered) Save(path string) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+var (
+	operationPattern    = regexp.MustCompile(`queryId:"([\w-]+)",operationName:"(\w+)",operationType:"\w+",metadata:\{featureSwitches:\[([^\]]*)\],fieldToggles:\[([^\]]*)\]`)
+	featureValuePattern = regexp.MustCompile(`"(\w+)":\{"value":(true|false)`)
+	bundlePattern       = regexp.MustCompile(`["'](https?://[^"']+/responsive-web/client-web[^/"']*/[^"']+\.js)["']`)
+)
+
+func splitNames(list string) []string {
+	names := []string{}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.Trim(strings.TrimSpace(name), `"`); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// 从 js 包中解析操作名到操作的映射
+func ParseBundle(js []byte) map[string]*Operation {
+	ops := make(map[string]*Operation)
+	for _, subs := range operationPattern.FindAllSubmatch(js, -1) {
+		ops[string(subs[2])] = &Operation{
+			QueryId:      string(subs[1]),
+			Features:     splitNames(string(subs[3])),
+			FieldToggles: splitNames(string(subs[4])),
+		}
+	}
+	return ops
+}
+
+// 从网页中解析功能开关的值
+func ParseFeatureValues(html []byte) map[string]bool {
+	values := make(map[string]bool)
+	for _, subs := range featureValuePattern.FindAllSubmatch(html, -1) {
+		values[string(subs[1])] = string(subs[2]) == "true"
+	}
+	return values
+}
+
+// 网页引用的网页客户端的 js 包
+func parseBundleUrls(html []byte) []string {
+	urls := []string{}
+	seen := make(map[string]bool)
+	for _, subs := range bundlePattern.FindAllSubmatch(html, -1) {
+		if u := string(subs[1]); !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// 获取网页客户端及其 js 包，从中发现 GraphQL 操作和功能开关的值
+func Discover(ctx context.Context, client *resty.Client) (*Discovered, error) {
+	// 与 GetSelfScreenName 相同，网页不接受 Authorization 头
+	client = client.Clone()
+	client.SetAuthToken("")
+	client.SetHeader("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36")
+
+	resp, err := client.R().SetContext(ctx).Get(HOST + "/home")
+	if err == nil {
+		err = utils.CheckRespStatus(resp)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get web client: %v", err)
+	}
+
+	d := &Discovered{
+		Operations:    make(map[string]*Operation),
+		FeatureValues: ParseFeatureValues(resp.Body()),
+		UpdatedAt:     time.Now(),
+	}
+	for _, u := range parseBundleUrls(resp.Body()) {
+		resp, err := client.R().SetContext(ctx).Get(u)
+		if err == nil {
+			err = utils.CheckRespStatus(resp)
+		}
+		if err != nil {
+			log.WithField("bundle", u).Debugln("failed to get bundle:", err)
+			continue
+		}
+		for name, op := range ParseBundle(resp.Body()) {
+			d.Operations[name] = op
+		}
+	}
+	if len(d.Operations) == 0 {
+		return nil, fmt.Errorf("no graphql operation is found in web client")
+	}
+	return d, nil
+}
+
+// 用发现的操作替换路径中的 query id 和参数中的功能开关。功能开关的值依次取自
+// 网页、硬编码的参数，均没有时为 false
+func (d *Discovered) apply(path string, params url.Values) string {
+	i := strings.LastIndex(path, "/")
+	j := strings.LastIndex(path[:max(i, 0)], "/")
+	if i < 0 || j < 0 {
+		return path
+	}
+	op := d.Operations[path[i+1:]]
+	if op == nil {
+		return path
+	}
+
+	override := func(key string, names []string, values map[string]bool) {
+		if len(names) == 0 {
+			params.Del(key)
+			return
+		}
+		old := make(map[string]bool)
+		json.Unmarshal([]byte(params.Get(key)), &old)
+		merged := make(map[string]bool, len(names))
+		for _, name := range names {
+			value, ok := values[name]
+			if !ok {
+				value = old[name]
+			}
+			merged[name] = value
+		}
+		data, _ := json.Marshal(merged)
+		params.Set(key, string(data))
+	}
+	override("features", op.Features, d.FeatureValues)
+	override("fieldToggles", op.FieldToggles, nil)
+	return path[:j+1] + op.QueryId + path[i:]
+}
@@ -0,0 +1,94 @@
+package twitter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/tidwall/gjson"
+)
+
+func TestParseBundle(t *testing.T) {
+	js, err := os.ReadFile("testdata/discovery/main.8f2b7e4a.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := ParseBundle(js)
+	if len(ops) != 3 {
+		t.Fatalf("operations = %v", ops)
+	}
+	want := &Operation{
+		QueryId:      "NEWMediaQueryId-abc_123",
+		Features:     []string{"rweb_tipjar_consumption_enabled", "verified_phone_label_enabled", "longform_notetweets_consumption_enabled", "new_feature_enabled"},
+		FieldToggles: []string{"withArticlePlainText"},
+	}
+	if !reflect.DeepEqual(ops["UserMedia"], want) {
+		t.Errorf("UserMedia = %+v", ops["UserMedia"])
+	}
+	if op := ops["UserByRestId"]; op == nil || len(op.FieldToggles) != 0 {
+		t.Errorf("UserByRestId = %+v", op)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/home" {
+			html, _ := os.ReadFile("testdata/discovery/home.html")
+			w.Write([]byte(strings.ReplaceAll(string(html), "https://abs.twimg.com", "http://"+r.Host)))
+			return
+		}
+		http.ServeFile(w, r, filepath.Join("testdata/discovery", path.Base(r.URL.Path)))
+	}))
+	defer server.Close()
+	host := HOST
+	HOST = server.URL
+	defer func() { HOST = host }()
+
+	d, err := Discover(context.Background(), resty.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Operations) != 3 || !d.FeatureValues["new_feature_enabled"] || d.FeatureValues["verified_phone_label_enabled"] {
+		t.Errorf("discovered = %+v", d)
+	}
+
+	file := filepath.Join(t.TempDir(), "graphql.json")
+	if err := d.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	if d, err = LoadDiscovered(file); err != nil {
+		t.Fatal(err)
+	}
+	SetDiscovered(d)
+	defer SetDiscovered(nil)
+
+	u, _ := url.Parse(makeUrl(&userMedia{userId: 1, count: 20}))
+	if u.Path != "/i/api/graphql/NEWMediaQueryId-abc_123/UserMedia" || apiPath(&userMedia{}) != u.Path {
+		t.Errorf("path = %s", u.Path)
+	}
+	// 值依次取自网页、硬编码的参数
+	features := gjson.Parse(u.Query().Get("features")).Map()
+	if len(features) != 4 || !features["new_feature_enabled"].Bool() || !features["longform_notetweets_consumption_enabled"].Bool() ||
+		features["verified_phone_label_enabled"].Bool() || !features["rweb_tipjar_consumption_enabled"].Bool() {
+		t.Errorf("features = %s", u.Query().Get("features"))
+	}
+	if toggles := u.Query().Get("fieldToggles"); toggles != `{"withArticlePlainText":false}` {
+		t.Errorf("field toggles = %s", toggles)
+	}
+	if !strings.Contains(u.Query().Get("variables"), `"userId":"1"`) {
+		t.Errorf("variables = %s", u.Query().Get("variables"))
+	}
+
+	u, _ = url.Parse(makeUrl(&userByScreenName{screenName: "alice"}))
+	if u.Path != (&userByScreenName{}).Path() || u.Query().Get("fieldToggles") == "" {
+		t.Errorf("undiscovered operation is changed: %s", u)
+	}
+}
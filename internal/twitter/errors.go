@@ -1,6 +1,15 @@
 package twitter
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 )
 
@@ -38,3 +47,96 @@ func (err *TwitterApiError) Error() string {
 func NewTwitterApiError(code int, raw string) *TwitterApiError {
 	return &TwitterApiError{Code: code, raw: raw}
 }
+
+// 无法解析的响应，Raw 为出错处的原始 JSON
+type ParseError struct {
+	What    string // 出错的对象，如 instructions, entry, created_at
+	Subject string // 受影响的用户或推文，未知时为空
+	Raw     string
+	Path    string // 原始 JSON 保存的路径，未保存时为空
+	Err     error
+}
+
+func (err *ParseError) Error() string {
+	msg := "unable to parse " + err.What
+	if err.Subject != "" {
+		msg += " of " + err.Subject
+	}
+	if err.Err != nil {
+		msg += ": " + err.Err.Error()
+	}
+	return msg
+}
+
+func (err *ParseError) Unwrap() error {
+	return err.Err
+}
+
+// 不为空时，无法解析的原始 JSON 被保存到此目录
+var BadPayloadDir string
+
+var badPayloads struct {
+	mtx    sync.Mutex
+	errors []*ParseError
+	saved  int
+}
+
+// 创建并报告解析错误
+func newParseError(what string, raw string, err error) *ParseError {
+	pe := &ParseError{What: what, Raw: raw, Err: err}
+	reportParseError(pe)
+	return pe
+}
+
+// 保存原始 JSON 并记录错误，供运行结束时汇总
+func reportParseError(err *ParseError) {
+	badPayloads.mtx.Lock()
+	defer badPayloads.mtx.Unlock()
+	for _, e := range badPayloads.errors {
+		if e == err {
+			return
+		}
+	}
+	badPayloads.errors = append(badPayloads.errors, err)
+
+	if BadPayloadDir == "" {
+		return
+	}
+	if e := os.MkdirAll(BadPayloadDir, 0755); e != nil {
+		log.Warnln("failed to save bad payload:", e)
+		return
+	}
+	badPayloads.saved++
+	name := fmt.Sprintf("%s-%s-%d.json", time.Now().Format("20060102T150405"), strings.ReplaceAll(err.What, " ", "_"), badPayloads.saved)
+	path := filepath.Join(BadPayloadDir, name)
+	if e := os.WriteFile(path, []byte(err.Raw), 0644); e != nil {
+		log.Warnln("failed to save bad payload:", e)
+		return
+	}
+	err.Path = path
+}
+
+// 为解析错误标注受影响的用户或推文，err 不是解析错误时原样返回
+func withSubject(err error, subject string) error {
+	var pe *ParseError
+	if errors.As(err, &pe) && pe.Subject == "" {
+		badPayloads.mtx.Lock()
+		pe.Subject = subject
+		badPayloads.mtx.Unlock()
+	}
+	return err
+}
+
+// 清空记录的解析错误，常驻运行时每轮同步后调用
+func ResetParseErrors() {
+	badPayloads.mtx.Lock()
+	defer badPayloads.mtx.Unlock()
+	badPayloads.errors = nil
+}
+
+// 本次运行中遇到的解析错误
+func ParseErrors() []*ParseError {
+	badPayloads.mtx.Lock()
+	defer badPayloads.mtx.Unlock()
+	return append([]*ParseError{}, badPayloads.errors...)
+}
@@ -0,0 +1,317 @@
+// 离线的 X/Twitter 服务器，实现本项目使用的 GraphQL 端点、关注接口和媒体服务器，用于端到端测试
+package fake
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unkmonster/tmd/internal/twitter"
+)
+
+type User struct {
+	Id         uint64
+	ScreenName string
+	Name       string
+	Protected  bool
+	Following  bool     // 登录的账号已关注此用户
+	Requested  bool     // 登录的账号已向此用户发送关注请求
+	Friends    []uint64 // 关注的用户
+	Likes      []uint64 // 喜欢的推文，按喜欢的时间逆序
+}
+
+type Tweet struct {
+	Id        uint64
+	Author    uint64
+	Text      string
+	CreatedAt time.Time
+	Media     []*Media
+	NoLegacy  bool // 时间线中的推文缺少 legacy，只能按 id 重新获取
+	Tombstone bool // 按 id 获取时推文不可用
+	Malformed bool // 时间线中的推文的发布时间无法解析
+}
+
+type Media struct {
+	Type string // photo, video, animated_gif
+	Key  string // 文件名，不含扩展名
+	Data []byte
+}
+
+type List struct {
+	Id      uint64
+	Name    string
+	Creator uint64
+	Members []uint64
+}
+
+// 速率限制窗口的时长
+const RateLimitWindow = 15 * time.Minute
+
+type rateWindow struct {
+	reset     time.Time
+	remaining int
+}
+
+type Server struct {
+	API   *httptest.Server
+	Media *httptest.Server
+	Self  string // 登录账号的 screen_name
+	// 每个端点在一个窗口内允许的请求数
+	RateLimit int
+	// 登录账号的书签，按添加的时间逆序
+	Bookmarks []uint64
+
+	mtx     sync.Mutex
+	users   map[uint64]*User
+	tweets  map[uint64]*Tweet
+	lists   map[uint64]*List
+	files   map[string][]byte // 媒体服务器上的路径到内容
+	faults  map[string][]int
+	counts  map[string]int
+	windows map[string]*rateWindow
+	follows []uint64
+}
+
+func New() *Server {
+	s := &Server{
+		Self:      "fake_self",
+		RateLimit: 500,
+		users:     make(map[uint64]*User),
+		tweets:    make(map[uint64]*Tweet),
+		lists:     make(map[uint64]*List),
+		files:     make(map[string][]byte),
+		faults:    make(map[string][]int),
+		counts:    make(map[string]int),
+		windows:   make(map[string]*rateWindow),
+	}
+	s.API = httptest.NewServer(http.HandlerFunc(s.serveApi))
+	s.Media = httptest.NewServer(http.HandlerFunc(s.serveMedia))
+	return s
+}
+
+func (s *Server) Close() {
+	s.API.Close()
+	s.Media.Close()
+}
+
+// 使 twitter 包请求此服务器，返回恢复原设置的函数
+func (s *Server) Install() func() {
+	host, mediaHost := twitter.HOST, twitter.MediaHost
+	twitter.HOST = s.API.URL
+	twitter.MediaHost = strings.TrimPrefix(s.Media.URL, "http://")
+	return func() {
+		twitter.HOST, twitter.MediaHost = host, mediaHost
+	}
+}
+
+func (s *Server) AddUser(users ...*User) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, u := range users {
+		s.users[u.Id] = u
+	}
+}
+
+func (s *Server) AddList(lists ...*List) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, l := range lists {
+		s.lists[l.Id] = l
+	}
+}
+
+// 添加推文，其媒体可通过媒体服务器下载
+func (s *Server) AddTweet(tweets ...*Tweet) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, tw := range tweets {
+		s.tweets[tw.Id] = tw
+		for _, m := range tw.Media {
+			s.files[s.mediaPath(tw, m)] = m.Data
+		}
+	}
+}
+
+// 使 op 端点（如 UserMedia, friendships/create.json）接下来的 times 个请求返回错误：
+// 88, 130, 326 为响应体中的 API 错误，429 为 HTTP 状态码
+func (s *Server) Fail(op string, code int, times int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for i := 0; i < times; i++ {
+		s.faults[op] = append(s.faults[op], code)
+	}
+}
+
+// op 端点收到的请求数，包括返回错误的请求
+func (s *Server) Requests(op string) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.counts[op]
+}
+
+// 收到的关注请求的用户 id
+func (s *Server) Follows() []uint64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]uint64{}, s.follows...)
+}
+
+func (s *Server) mediaPath(tw *Tweet, m *Media) string {
+	switch m.Type {
+	case "video":
+		return fmt.Sprintf("/ext_tw_video/%d/pu/vid/720x1280/%s.mp4", tw.Id, m.Key)
+	case "animated_gif":
+		return fmt.Sprintf("/tweet_video/%s.mp4", m.Key)
+	}
+	return fmt.Sprintf("/media/%s.jpg", m.Key)
+}
+
+func (s *Server) serveMedia(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	data, ok := s.files[r.URL.Path]
+	s.mtx.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, path.Base(r.URL.Path), time.Time{}, bytes.NewReader(data))
+}
+
+// GraphQL 请求的 variables
+type variables struct {
+	UserId     string   `json:"userId"`
+	ScreenName string   `json:"screen_name"`
+	ListId     string   `json:"listId"`
+	TweetId    string   `json:"tweetId"`
+	TweetIds   []string `json:"tweetIds"`
+	Count      int      `json:"count"`
+	Cursor     string   `json:"cursor"`
+}
+
+func (s *Server) serveApi(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie("auth_token"); err != nil || c.Value == "" {
+		http.Error(w, "missing auth_token", http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path == "/home" {
+		fmt.Fprintf(w, `<script>window.__INITIAL_STATE__={"screen_name":"%s"}</script>`, s.Self)
+		return
+	}
+
+	// 按操作名匹配端点，忽略路径中的 query id
+	op := path.Base(r.URL.Path)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.counts[op]++
+
+	window := s.windows[op]
+	if window == nil || time.Now().After(window.reset) {
+		window = &rateWindow{reset: time.Now().Add(RateLimitWindow), remaining: s.RateLimit}
+		s.windows[op] = window
+	}
+	window.remaining = max(0, window.remaining-1)
+	w.Header().Set("X-Rate-Limit-Limit", strconv.Itoa(s.RateLimit))
+	w.Header().Set("X-Rate-Limit-Remaining", strconv.Itoa(window.remaining))
+	w.Header().Set("X-Rate-Limit-Reset", strconv.FormatInt(window.reset.Unix(), 10))
+	w.Header().Set("Content-Type", "application/json")
+
+	code := 0
+	if faults := s.faults[op]; len(faults) != 0 {
+		code = faults[0]
+		s.faults[op] = faults[1:]
+	} else if window.remaining == 0 {
+		code = http.StatusTooManyRequests
+	}
+	if code != 0 {
+		writeError(w, code)
+		return
+	}
+
+	if op == "create.json" && r.Method == http.MethodPost {
+		s.follow(w, r)
+		return
+	}
+
+	vars := variables{}
+	if err := json.Unmarshal([]byte(r.URL.Query().Get("variables")), &vars); err != nil {
+		http.Error(w, "invalid variables", http.StatusBadRequest)
+		return
+	}
+	var resp any
+	switch op {
+	case "UserByRestId":
+		resp = s.userByRestId(&vars)
+	case "UserByScreenName":
+		resp = s.userByScreenName(&vars)
+	case "UserMedia":
+		resp = s.userMedia(&vars)
+	case "Likes":
+		resp = s.userLikes(&vars)
+	case "Bookmarks":
+		resp = s.bookmarks(&vars)
+	case "Following":
+		resp = s.following(&vars)
+	case "ListByRestId":
+		resp = s.listByRestId(&vars)
+	case "ListMembers":
+		resp = s.listMembers(&vars)
+	case "TweetResultByRestId":
+		resp = s.tweetResultByRestId(&vars)
+	case "TweetResultsByRestIds":
+		resp = s.tweetResultsByRestIds(&vars)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func writeError(w http.ResponseWriter, code int) {
+	messages := map[int]string{
+		twitter.ErrExceedPostLimit: "Rate limit exceeded",
+		twitter.ErrOverCapacity:    "Over capacity",
+		twitter.ErrAccountLocked:   "To protect our users from spam and other malicious activity, this account is temporarily locked.",
+	}
+	statuses := map[int]int{
+		twitter.ErrExceedPostLimit: http.StatusTooManyRequests,
+		twitter.ErrOverCapacity:    http.StatusServiceUnavailable,
+		twitter.ErrAccountLocked:   http.StatusForbidden,
+	}
+	message, ok := messages[code]
+	if !ok {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(code)
+		fmt.Fprintln(w, http.StatusText(code))
+		return
+	}
+	w.WriteHeader(statuses[code])
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": []any{map[string]any{"code": code, "message": message}},
+	})
+}
+
+func (s *Server) follow(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseUint(r.FormValue("user_id"), 10, 64)
+	user := s.users[id]
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []any{map[string]any{"code": 108, "message": "Cannot find specified user."}},
+		})
+		return
+	}
+	s.follows = append(s.follows, id)
+	if user.Protected {
+		user.Requested = true
+	} else {
+		user.Following = true
+	}
+	json.NewEncoder(w).Encode(s.userLegacy(user))
+}
@@ -0,0 +1,217 @@
+package fake
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/unkmonster/tmd/internal/twitter"
+)
+
+func newTestServer(t *testing.T) (*Server, *resty.Client) {
+	s := New()
+	t.Cleanup(s.Close)
+	t.Cleanup(s.Install())
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.AddUser(
+		&User{Id: 1, ScreenName: "alice", Name: "Alice", Friends: []uint64{2, 3}, Likes: []uint64{30, 10}},
+		&User{Id: 2, ScreenName: "bob", Name: "Bob"},
+		&User{Id: 3, ScreenName: "carol", Name: "Carol", Protected: true},
+	)
+	s.AddTweet(
+		&Tweet{Id: 10, Author: 1, Text: "photo", CreatedAt: base, Media: []*Media{{Type: "photo", Key: "a", Data: []byte("jpg")}}},
+		&Tweet{Id: 11, Author: 1, Text: "video", CreatedAt: base.Add(time.Hour), Media: []*Media{{Type: "video", Key: "b", Data: []byte("mp4")}}},
+		&Tweet{Id: 12, Author: 1, Text: "text only", CreatedAt: base.Add(2 * time.Hour)},
+		&Tweet{Id: 30, Author: 3, Text: "gif", CreatedAt: base, Media: []*Media{{Type: "animated_gif", Key: "c", Data: []byte("gif")}}},
+	)
+	s.AddList(&List{Id: 100, Name: "friends", Creator: 1, Members: []uint64{2, 3}})
+
+	client, screenName, err := twitter.Login(context.Background(), "token", "ct0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if screenName != s.Self {
+		t.Errorf("screen name = %s, want %s", screenName, s.Self)
+	}
+	client.SetRetryWaitTime(time.Millisecond)
+	return s, client
+}
+
+func TestEndpoints(t *testing.T) {
+	_, client := newTestServer(t)
+	ctx := context.Background()
+
+	alice, err := twitter.GetUserById(ctx, client, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.ScreenName != "alice" || alice.MediaCount != 2 || alice.FriendsCount != 2 {
+		t.Errorf("alice = %+v", alice)
+	}
+	carol, err := twitter.GetUserByScreenName(ctx, client, "Carol")
+	if err != nil || carol.Id != 3 || !carol.IsProtected || carol.IsVisiable() {
+		t.Errorf("carol = %+v, %v", carol, err)
+	}
+	if _, err := twitter.GetUserById(ctx, client, 404); err == nil {
+		t.Error("got missing user")
+	}
+
+	// 分页获取，按发布时间逆序
+	tweets, _, err := alice.GetMeidas(ctx, client, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tweets) != 2 || tweets[0].Id != 11 || tweets[1].Id != 10 {
+		t.Fatalf("media tweets = %v", tweets)
+	}
+	if tweets[0].Media[0].Type != twitter.MT_VIDEO || tweets[1].Media[0].Type != twitter.MT_PHOTO {
+		t.Errorf("media = %+v, %+v", tweets[0].Media, tweets[1].Media)
+	}
+	resp, err := client.R().SetQueryParam("name", "4096x4096").Get(tweets[1].Media[0].Url)
+	if err != nil || string(resp.Body()) != "jpg" {
+		t.Errorf("photo: %q, %v", resp.Body(), err)
+	}
+
+	likes, _, err := alice.GetLikes(ctx, client, nil)
+	if err != nil || len(likes) != 2 || likes[0].Id != 30 || likes[0].Media[0].Type != twitter.MT_GIF {
+		t.Errorf("likes = %v, %v", likes, err)
+	}
+	tweet, err := twitter.GetTweet(ctx, client, 12)
+	if err != nil || tweet.Text != "text only" || tweet.Creator.Id != 1 {
+		t.Errorf("tweet = %+v, %v", tweet, err)
+	}
+
+	list, err := twitter.GetLst(ctx, client, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Name != "friends" || list.MemberCount != 2 || list.Creator.Id != 1 {
+		t.Errorf("list = %+v", list)
+	}
+	members, err := list.GetMembers(ctx, client)
+	if err != nil || len(members) != 2 || members[0].Id != 2 {
+		t.Errorf("members = %v, %v", members, err)
+	}
+	friends, err := alice.Following().GetMembers(ctx, client)
+	if err != nil || len(friends) != 2 || friends[1].Id != 3 {
+		t.Errorf("following = %v, %v", friends, err)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	s, client := newTestServer(t)
+	ctx := context.Background()
+
+	carol, _ := twitter.GetUserById(ctx, client, 3)
+	if err := twitter.FollowUser(ctx, client, carol); err != nil {
+		t.Fatal(err)
+	}
+	if follows := s.Follows(); !slices.Equal(follows, []uint64{3}) {
+		t.Errorf("follows = %v", follows)
+	}
+	carol, _ = twitter.GetUserById(ctx, client, 3)
+	if carol.Followstate != twitter.FS_REQUESTED {
+		t.Errorf("follow state = %v, want requested", carol.Followstate)
+	}
+}
+
+func TestRefetch(t *testing.T) {
+	s, client := newTestServer(t)
+	ctx := context.Background()
+
+	base := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	s.AddUser(&User{Id: 4, ScreenName: "dave", Name: "Dave"})
+	s.AddTweet(
+		&Tweet{Id: 40, Author: 4, CreatedAt: base, Media: []*Media{{Type: "photo", Key: "d", Data: []byte("jpg")}}},
+		&Tweet{Id: 41, Author: 4, CreatedAt: base.Add(time.Hour), NoLegacy: true, Media: []*Media{{Type: "video", Key: "e", Data: []byte("mp4")}}},
+		&Tweet{Id: 42, Author: 4, CreatedAt: base.Add(2 * time.Hour), NoLegacy: true, Tombstone: true, Media: []*Media{{Type: "photo", Key: "f", Data: []byte("jpg")}}},
+		&Tweet{Id: 43, Author: 4, CreatedAt: base.Add(3 * time.Hour), NoLegacy: true, Media: []*Media{{Type: "photo", Key: "g", Data: []byte("jpg")}}},
+	)
+	recovered, lost := twitter.RefetchStats()
+
+	dave, err := twitter.GetUserById(ctx, client, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tweets, skipped, err := dave.GetMeidas(ctx, client, nil)
+	if err != nil || skipped != 0 {
+		t.Fatal(skipped, err)
+	}
+	// 重新获取的推文保持原来的顺序，不可用的推文被跳过
+	ids := []uint64{}
+	for _, tw := range tweets {
+		ids = append(ids, tw.Id)
+	}
+	if !slices.Equal(ids, []uint64{43, 41, 40}) {
+		t.Fatalf("media tweets = %v", ids)
+	}
+	if tweets[1].Media[0].Type != twitter.MT_VIDEO || tweets[1].Creator.Id != 4 {
+		t.Errorf("refetched tweet = %+v", tweets[1])
+	}
+	if n := s.Requests("TweetResultsByRestIds"); n != 1 {
+		t.Errorf("refetch requests = %d, want 1", n)
+	}
+	r, l := twitter.RefetchStats()
+	if r-recovered != 2 || l-lost != 1 {
+		t.Errorf("recovered = %d, lost = %d", r-recovered, l-lost)
+	}
+	twitter.ResetRefetchStats()
+	if r, l := twitter.RefetchStats(); r != 0 || l != 0 {
+		t.Errorf("after reset: recovered = %d, lost = %d", r, l)
+	}
+
+	// 重新获取失败的批次计入跳过数，交由调用者处理
+	s.Fail("TweetResultsByRestIds", twitter.ErrAccountLocked, 1)
+	tweets, skipped, err = dave.GetMeidas(ctx, client, nil)
+	if err != nil || len(tweets) != 1 || tweets[0].Id != 40 || skipped != 3 {
+		t.Errorf("tweets = %v, skipped = %d, %v", tweets, skipped, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s, client := newTestServer(t)
+	ctx := context.Background()
+	alice := &twitter.User{Id: 1}
+
+	// 88 和 326 不重试
+	for _, code := range []int{twitter.ErrExceedPostLimit, twitter.ErrAccountLocked} {
+		s.Fail("UserMedia", code, 1)
+		_, _, err := alice.GetMeidas(ctx, client, nil)
+		if v, ok := err.(*twitter.TwitterApiError); !ok || v.Code != code {
+			t.Errorf("err = %v, want api error %d", err, code)
+		}
+	}
+
+	// 130 和 429 被重试
+	for _, code := range []int{twitter.ErrOverCapacity, 429} {
+		before := s.Requests("UserByRestId")
+		s.Fail("UserByRestId", code, 2)
+		if _, err := twitter.GetUserById(ctx, client, 1); err != nil {
+			t.Errorf("%d: %v", code, err)
+		}
+		if n := s.Requests("UserByRestId") - before; n != 3 {
+			t.Errorf("%d: %d requests, want 3", code, n)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s, client := newTestServer(t)
+	s.RateLimit = 5
+	twitter.EnableRateLimit(client)
+	ctx := context.Background()
+	alice := &twitter.User{Id: 1}
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := alice.GetMeidas(ctx, client, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 剩余的请求数不超过阈值时，非阻塞的速率限制器拒绝请求
+	if _, _, err := alice.GetMeidas(ctx, client, nil); err != twitter.ErrWouldBlock {
+		t.Errorf("err = %v, want %v", err, twitter.ErrWouldBlock)
+	}
+}
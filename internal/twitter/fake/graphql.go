@@ -0,0 +1,275 @@
+package fake
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type object = map[string]any
+
+func idOf(str string) uint64 {
+	id, _ := strconv.ParseUint(str, 10, 64)
+	return id
+}
+
+// 用户的推文中含媒体的推文，按发布时间逆序
+func (s *Server) mediaTweets(uid uint64) []*Tweet {
+	tweets := []*Tweet{}
+	for _, tw := range s.tweets {
+		if tw.Author == uid && len(tw.Media) != 0 {
+			tweets = append(tweets, tw)
+		}
+	}
+	slices.SortFunc(tweets, func(a, b *Tweet) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Id, a.Id)
+	})
+	return tweets
+}
+
+func (s *Server) userLegacy(user *User) object {
+	legacy := object{
+		"name":          user.Name,
+		"screen_name":   user.ScreenName,
+		"protected":     user.Protected,
+		"friends_count": len(user.Friends),
+		"media_count":   len(s.mediaTweets(user.Id)),
+	}
+	if user.Following {
+		legacy["following"] = true
+	} else if user.Requested {
+		legacy["follow_request_sent"] = true
+	} else {
+		legacy["following"] = false
+	}
+	return legacy
+}
+
+func (s *Server) userResults(user *User) object {
+	if user == nil {
+		return object{"result": object{"__typename": "UserUnavailable"}}
+	}
+	return object{"result": object{
+		"__typename": "User",
+		"rest_id":    strconv.FormatUint(user.Id, 10),
+		"legacy":     s.userLegacy(user),
+	}}
+}
+
+func (s *Server) mediaEntity(tw *Tweet, m *Media) object {
+	u := s.Media.URL + s.mediaPath(tw, m)
+	entity := object{
+		"type":            m.Type,
+		"media_url_https": u,
+		"original_info":   object{"width": 720, "height": 1280},
+	}
+	if m.Type == "photo" {
+		return entity
+	}
+	entity["media_url_https"] = s.Media.URL + "/media/" + m.Key + "_thumb.jpg"
+	variant := object{"content_type": "video/mp4", "url": u, "bitrate": 2176000}
+	if m.Type == "animated_gif" {
+		variant["bitrate"] = 0
+	}
+	entity["video_info"] = object{"duration_millis": 1000, "variants": []any{variant}}
+	return entity
+}
+
+func (s *Server) tweetResults(tw *Tweet) object {
+	media := []any{}
+	for _, m := range tw.Media {
+		media = append(media, s.mediaEntity(tw, m))
+	}
+	legacy := object{
+		"id_str":     strconv.FormatUint(tw.Id, 10),
+		"full_text":  tw.Text,
+		"created_at": tw.CreatedAt.UTC().Format(time.RubyDate),
+		"entities":   object{"hashtags": []any{}, "user_mentions": []any{}},
+	}
+	if len(media) != 0 {
+		legacy["extended_entities"] = object{"media": media}
+	}
+	return object{"result": object{
+		"__typename": "Tweet",
+		"rest_id":    strconv.FormatUint(tw.Id, 10),
+		"core":       object{"user_results": s.userResults(s.users[tw.Author])},
+		"legacy":     legacy,
+	}}
+}
+
+// 时间线的一页，cursor 为已返回的条目数
+func timeline(items []object, vars *variables) object {
+	offset, _ := strconv.Atoi(vars.Cursor)
+	count := vars.Count
+	if count <= 0 {
+		count = 20
+	}
+	end := min(len(items), offset+count)
+	entries := []any{}
+	for i := offset; i < end; i++ {
+		entries = append(entries, object{
+			"entryId": "item-" + strconv.Itoa(i),
+			"content": object{"entryType": "TimelineTimelineItem", "itemContent": items[i]},
+		})
+	}
+	for _, cursor := range []struct{ typ, value string }{{"Top", strconv.Itoa(offset)}, {"Bottom", strconv.Itoa(max(offset, end))}} {
+		entries = append(entries, object{
+			"entryId": "cursor-" + strings.ToLower(cursor.typ) + "-" + cursor.value,
+			"content": object{"entryType": "TimelineTimelineCursor", "cursorType": cursor.typ, "value": cursor.value},
+		})
+	}
+	return object{"timeline": object{"instructions": []any{
+		object{"type": "TimelineAddEntries", "entries": entries},
+	}}}
+}
+
+func (s *Server) tweetItems(tweets []*Tweet) []object {
+	items := []object{}
+	for _, tw := range tweets {
+		results := s.tweetResults(tw)
+		if tw.NoLegacy {
+			delete(results["result"].(object), "legacy")
+		} else if tw.Malformed {
+			results["result"].(object)["legacy"].(object)["created_at"] = tw.CreatedAt.Format(time.DateOnly)
+		}
+		items = append(items, object{"itemType": "TimelineTweet", "tweet_results": results})
+	}
+	return items
+}
+
+func (s *Server) userItems(ids []uint64) []object {
+	items := []object{}
+	for _, id := range ids {
+		if user := s.users[id]; user != nil {
+			items = append(items, object{"itemType": "TimelineUser", "user_results": s.userResults(user)})
+		}
+	}
+	return items
+}
+
+func (s *Server) userByRestId(vars *variables) object {
+	user := s.users[idOf(vars.UserId)]
+	if user == nil {
+		return object{"data": object{}}
+	}
+	return object{"data": object{"user": s.userResults(user)}}
+}
+
+func (s *Server) userByScreenName(vars *variables) object {
+	for _, user := range s.users {
+		if strings.EqualFold(user.ScreenName, vars.ScreenName) {
+			return object{"data": object{"user": s.userResults(user)}}
+		}
+	}
+	return object{"data": object{}}
+}
+
+// 受保护且未关注的用户，其时间线不可见
+func (s *Server) visible(user *User) bool {
+	return user != nil && (!user.Protected || user.Following)
+}
+
+func (s *Server) userMedia(vars *variables) object {
+	user := s.users[idOf(vars.UserId)]
+	if !s.visible(user) {
+		return object{"data": object{"user": object{}}}
+	}
+	return object{"data": object{"user": object{"result": object{
+		"__typename":  "User",
+		"timeline_v2": timeline(s.tweetItems(s.mediaTweets(user.Id)), vars),
+	}}}}
+}
+
+func (s *Server) userLikes(vars *variables) object {
+	user := s.users[idOf(vars.UserId)]
+	if !s.visible(user) {
+		return object{"data": object{"user": object{}}}
+	}
+	tweets := []*Tweet{}
+	for _, id := range user.Likes {
+		if tw := s.tweets[id]; tw != nil {
+			tweets = append(tweets, tw)
+		}
+	}
+	return object{"data": object{"user": object{"result": object{
+		"__typename":  "User",
+		"timeline_v2": timeline(s.tweetItems(tweets), vars),
+	}}}}
+}
+
+func (s *Server) bookmarks(vars *variables) object {
+	tweets := []*Tweet{}
+	for _, id := range s.Bookmarks {
+		if tw := s.tweets[id]; tw != nil {
+			tweets = append(tweets, tw)
+		}
+	}
+	return object{"data": object{"bookmark_timeline_v2": timeline(s.tweetItems(tweets), vars)}}
+}
+
+func (s *Server) following(vars *variables) object {
+	user := s.users[idOf(vars.UserId)]
+	if !s.visible(user) {
+		return object{"data": object{"user": object{}}}
+	}
+	return object{"data": object{"user": object{"result": object{
+		"__typename": "User",
+		"timeline":   timeline(s.userItems(user.Friends), vars),
+	}}}}
+}
+
+func (s *Server) listByRestId(vars *variables) object {
+	list := s.lists[idOf(vars.ListId)]
+	if list == nil {
+		return object{"data": object{}}
+	}
+	return object{"data": object{"list": object{
+		"id_str":       strconv.FormatUint(list.Id, 10),
+		"name":         list.Name,
+		"member_count": len(list.Members),
+		"user_results": s.userResults(s.users[list.Creator]),
+	}}}
+}
+
+func (s *Server) listMembers(vars *variables) object {
+	list := s.lists[idOf(vars.ListId)]
+	if list == nil {
+		return object{"data": object{}}
+	}
+	return object{"data": object{"list": object{
+		"members_timeline": timeline(s.userItems(list.Members), vars),
+	}}}
+}
+
+func (s *Server) tweetResultByRestId(vars *variables) object {
+	tw := s.tweets[idOf(vars.TweetId)]
+	if tw == nil {
+		return object{"data": object{"tweetResult": object{}}}
+	}
+	if tw.Tombstone {
+		return object{"data": object{"tweetResult": tombstone()}}
+	}
+	return object{"data": object{"tweetResult": s.tweetResults(tw)}}
+}
+
+func tombstone() object {
+	return object{"result": object{"__typename": "TweetTombstone"}}
+}
+
+func (s *Server) tweetResultsByRestIds(vars *variables) object {
+	results := []any{}
+	for _, id := range vars.TweetIds {
+		tw := s.tweets[idOf(id)]
+		if tw == nil || tw.Tombstone {
+			results = append(results, tombstone())
+			continue
+		}
+		results = append(results, s.tweetResults(tw))
+	}
+	return object{"data": object{"tweetResult": results}}
+}
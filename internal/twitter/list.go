@@ -61,7 +61,7 @@ func itemContentsToUsers(itemContents []gjson.Result) []*User {
 	users := make([]*User, 0, len(itemContents))
 	for _, ic := range itemContents {
 		user_results := getResults(ic, timelineUser)
-		if user_results.String() == "{}" {
+		if !user_results.Exists() || user_results.String() == "{}" {
 			continue
 		}
 		u, err := parseUserResults(&user_results)
@@ -90,7 +90,8 @@ func (list *List) GetMembers(ctx context.Context, client *resty.Client) ([]*User
 	api := listMembers{}
 	api.count = 200
 	api.id = list.Id
-	return getMembers(ctx, client, &api, "data.list.members_timeline.timeline.instructions")
+	members, err := getMembers(ctx, client, &api, "data.list.members_timeline.timeline.instructions")
+	return members, withSubject(err, "list "+list.Title())
 }
 
 func (list *List) GetId() int64 {
@@ -109,7 +110,8 @@ func (fo UserFollowing) GetMembers(ctx context.Context, client *resty.Client) ([
 	api := following{}
 	api.count = 200
 	api.uid = fo.creator.Id
-	return getMembers(ctx, client, &api, "data.user.result.timeline.timeline.instructions")
+	members, err := getMembers(ctx, client, &api, "data.user.result.timeline.timeline.instructions")
+	return members, withSubject(err, fo.Title())
 }
 
 func (fo UserFollowing) GetId() int64 {
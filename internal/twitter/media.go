@@ -0,0 +1,197 @@
+package twitter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+type MediaType string
+
+const (
+	MT_PHOTO MediaType = "photo"
+	MT_VIDEO MediaType = "video"
+	MT_GIF   MediaType = "gif"
+	MT_SPACE MediaType = "space" // 已结束并可回放的语音空间，Url 为空间的链接
+)
+
+type Media struct {
+	Type     MediaType
+	Url      string // 视频为码率最高的 mp4 变体
+	Width    int
+	Height   int
+	Duration time.Duration // 仅视频
+	Bitrate  int           // 仅视频，Url 对应变体的码率
+	Variants []Variant     // 仅视频，全部变体
+}
+
+// 视频的一种编码版本
+type Variant struct {
+	Bitrate     int
+	ContentType string
+	Url         string
+	Width       int // 从链接中解析，未知为 0
+	Height      int
+}
+
+func (v *Variant) IsMp4() bool {
+	return v.ContentType == "video/mp4"
+}
+
+var resolutionPattern = regexp.MustCompile(`/(\d+)x(\d+)/`)
+
+func parseVariants(variants *gjson.Result) []Variant {
+	results := []Variant{}
+	for _, v := range variants.Array() {
+		variant := Variant{
+			Bitrate:     int(v.Get("bitrate").Int()),
+			ContentType: v.Get("content_type").String(),
+			Url:         v.Get("url").String(),
+		}
+		if subs := resolutionPattern.FindStringSubmatch(variant.Url); len(subs) != 0 {
+			variant.Width, _ = strconv.Atoi(subs[1])
+			variant.Height, _ = strconv.Atoi(subs[2])
+		}
+		results = append(results, variant)
+	}
+	return results
+}
+
+// 视频画质选择策略，零值选择码率最高的变体
+type VideoQuality struct {
+	lowest    bool
+	maxHeight int // 不为 0 时选择分辨率（短边）不超过此值的最高码率变体
+}
+
+var qualityCapPattern = regexp.MustCompile(`^(?:<=|≤)\s*(\d+)p?$`)
+
+// 解析画质选项 highest, lowest, <=720p (或 ≤720p)
+func ParseVideoQuality(str string) (VideoQuality, error) {
+	str = strings.ToLower(strings.TrimSpace(str))
+	switch str {
+	case "", "highest":
+		return VideoQuality{}, nil
+	case "lowest":
+		return VideoQuality{lowest: true}, nil
+	}
+
+	subs := qualityCapPattern.FindStringSubmatch(str)
+	if len(subs) == 0 {
+		return VideoQuality{}, fmt.Errorf("invalid video quality: %s", str)
+	}
+	height, err := strconv.Atoi(subs[1])
+	if err != nil || height == 0 {
+		return VideoQuality{}, fmt.Errorf("invalid video quality: %s", str)
+	}
+	return VideoQuality{maxHeight: height}, nil
+}
+
+// 按画质策略从 mp4 变体中选择一个，没有 mp4 变体返回 nil。
+// 没有满足分辨率上限的变体时选择码率最低的变体
+func (m *Media) SelectVariant(quality VideoQuality) *Variant {
+	candidates := make([]*Variant, 0, len(m.Variants))
+	for i := range m.Variants {
+		if m.Variants[i].IsMp4() {
+			candidates = append(candidates, &m.Variants[i])
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(candidates, func(a, b *Variant) int {
+		return b.Bitrate - a.Bitrate
+	})
+
+	if quality.lowest {
+		return candidates[len(candidates)-1]
+	}
+	if quality.maxHeight == 0 {
+		return candidates[0]
+	}
+	for _, v := range candidates {
+		// 未知分辨率的变体视为满足上限
+		if min(v.Width, v.Height) <= quality.maxHeight {
+			return v
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// 解析媒体类型名称 photo, video, gif, space
+func ParseMediaType(str string) (MediaType, error) {
+	switch typ := MediaType(strings.ToLower(strings.TrimSpace(str))); typ {
+	case MT_PHOTO, MT_VIDEO, MT_GIF, MT_SPACE:
+		return typ, nil
+	}
+	return "", fmt.Errorf("invalid media type: %s", str)
+}
+
+func parseMedia(media *gjson.Result) []Media {
+	results := []Media{}
+	for _, m := range media.Array() {
+		item := Media{
+			Width:  int(m.Get("original_info.width").Int()),
+			Height: int(m.Get("original_info.height").Int()),
+		}
+
+		switch m.Get("type").String() {
+		case "photo":
+			item.Type = MT_PHOTO
+			item.Url = m.Get("media_url_https").String()
+		case "video", "animated_gif":
+			item.Type = MT_VIDEO
+			if m.Get("type").String() == "animated_gif" {
+				item.Type = MT_GIF
+			}
+			variants := m.Get("video_info.variants")
+			item.Variants = parseVariants(&variants)
+			if variant := item.SelectVariant(VideoQuality{}); variant != nil {
+				item.Url = variant.Url
+				item.Bitrate = variant.Bitrate
+			} else if len(item.Variants) != 0 {
+				item.Url = item.Variants[len(item.Variants)-1].Url
+			}
+			item.Duration = time.Duration(m.Get("video_info.duration_millis").Int()) * time.Millisecond
+		default:
+			continue
+		}
+		results = append(results, item)
+	}
+	return results
+}
+
+// 根据媒体链接推断媒体类型
+func mediaTypeFromUrl(url string) MediaType {
+	if strings.Contains(url, "/tweet_video/") {
+		return MT_GIF
+	}
+	if strings.Contains(url, "video.twimg.com") {
+		return MT_VIDEO
+	}
+	return MT_PHOTO
+}
+
+// 兼容旧版本转储的推文，其媒体只有链接
+func (tw *Tweet) UnmarshalJSON(data []byte) error {
+	type plain Tweet
+	aux := struct {
+		*plain
+		Urls []string
+	}{plain: (*plain)(tw)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if len(tw.Media) == 0 {
+		for _, u := range aux.Urls {
+			tw.Media = append(tw.Media, Media{Type: mediaTypeFromUrl(u), Url: u})
+		}
+	}
+	return nil
+}
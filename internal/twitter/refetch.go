@@ -0,0 +1,175 @@
+package twitter
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync/atomic"
+
+	"github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// 每个请求重新获取的推文数
+const refetchBatchSize = 100
+
+var refetchStats struct {
+	recovered atomic.Int32
+	lost      atomic.Int32
+}
+
+// 本次运行中重新获取成功和仍无法获取的缺少 legacy 的推文数
+func RefetchStats() (recovered int, lost int) {
+	return int(refetchStats.recovered.Load()), int(refetchStats.lost.Load())
+}
+
+// 清空重新获取的统计，常驻运行时每轮同步后调用
+func ResetRefetchStats() {
+	refetchStats.recovered.Store(0)
+	refetchStats.lost.Store(0)
+}
+
+// 按 id 批量获取推文，不可用的推文不在结果中。
+// 响应中没有结果数组或结果数与 id 数不一致时返回错误
+func GetTweets(ctx context.Context, client *resty.Client, ids []uint64) (map[uint64]*Tweet, error) {
+	api := tweetResultsByRestIds{ids}
+	resp, err := client.R().SetContext(ctx).Get(makeUrl(&api))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tweets %v: %v", ids, err)
+	}
+
+	results := gjson.GetBytes(resp.Body(), "data.tweetResult")
+	if !results.IsArray() {
+		return nil, newParseError("tweetResult", resp.String(), fmt.Errorf("no results of %d tweets", len(ids)))
+	}
+	if n := len(results.Array()); n != len(ids) {
+		return nil, newParseError("tweetResult", resp.String(), fmt.Errorf("got %d results of %d tweets", n, len(ids)))
+	}
+
+	tweets := make(map[uint64]*Tweet)
+	for _, tweetResult := range results.Array() {
+		if tw := parseTweetResults(&tweetResult); tw != nil {
+			tweets[tw.Id] = tw
+		}
+	}
+	return tweets, nil
+}
+
+// 缺少 legacy 的推文的 id，推文完整或不可用时返回 0
+func partialTweetId(tweet_results *gjson.Result) uint64 {
+	result := tweet_results.Get("result")
+	if result.Get("__typename").String() == "TweetWithVisibilityResults" {
+		result = result.Get("tweet")
+	}
+	if !result.Exists() || result.Get("__typename").String() == "TweetTombstone" || result.Get("legacy").Exists() {
+		return 0
+	}
+	return result.Get("rest_id").Uint()
+}
+
+// 推文中缺少 legacy 的被转推和被引用的推文的 id，没有时为 0
+func partialNestedIds(tweet_results *gjson.Result) (retweeted uint64, quoted uint64) {
+	result := tweet_results.Get("result")
+	if result.Get("__typename").String() == "TweetWithVisibilityResults" {
+		result = result.Get("tweet")
+	}
+	retweetedResults := result.Get("legacy.retweeted_status_result")
+	quotedResults := result.Get("quoted_status_result")
+	return partialTweetId(&retweetedResults), partialTweetId(&quotedResults)
+}
+
+// 分批重新获取推文，返回获取到的推文和请求失败的批次中的 id
+func refetchTweets(ctx context.Context, client *resty.Client, ids []uint64) (map[uint64]*Tweet, []uint64) {
+	tweets := make(map[uint64]*Tweet)
+	failed := []uint64{}
+	for i := 0; i < len(ids); i += refetchBatchSize {
+		batch := ids[i:min(i+refetchBatchSize, len(ids))]
+		fetched, err := GetTweets(ctx, client, batch)
+		if err != nil {
+			log.Warnln("failed to refetch tweets without legacy:", err)
+			failed = append(failed, batch...)
+			continue
+		}
+		for id, tw := range fetched {
+			tweets[id] = tw
+		}
+	}
+	return tweets, failed
+}
+
+// 将 itemContent 解析为推文，返回推文和无法解析而被跳过的推文数。
+// 缺少 legacy 的推文及被转推、被引用的推文按 id 重新获取后合并到原来的位置，不可用的被跳过并计数；
+// 所在批次请求失败的推文同样被跳过，并计入返回的跳过数，使调用者不推进同步进度而在下次重试
+func collectTweets(ctx context.Context, client *resty.Client, itemContents []gjson.Result) ([]*Tweet, int) {
+	tweets := make([]*Tweet, 0, len(itemContents))
+	missing := make(map[int]uint64)    // 在 tweets 中的位置到推文 id
+	nested := make(map[**Tweet]uint64) // 推文的 Retweeted 或 Quoted 字段到推文 id
+	ids := []uint64{}
+	addId := func(id uint64) {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	skipped := 0
+	for _, itemContent := range itemContents {
+		tweetResults := getResults(itemContent, timelineTweet)
+		if tw := parseTweetResults(&tweetResults); tw != nil {
+			retweeted, quoted := partialNestedIds(&tweetResults)
+			if retweeted != 0 {
+				nested[&tw.Retweeted] = retweeted
+				addId(retweeted)
+			}
+			if quoted != 0 {
+				nested[&tw.Quoted] = quoted
+				addId(quoted)
+			}
+			tweets = append(tweets, tw)
+		} else if id := partialTweetId(&tweetResults); id != 0 {
+			missing[len(tweets)] = id
+			tweets = append(tweets, nil)
+			addId(id)
+		} else if unparsable(&tweetResults) {
+			skipped++
+		}
+	}
+	if len(ids) == 0 {
+		return tweets, skipped
+	}
+
+	fetched, failed := refetchTweets(ctx, client, ids)
+	skipped += len(failed)
+	lost := 0
+	for field, id := range nested {
+		if *field = fetched[id]; *field == nil {
+			lost++
+		}
+	}
+	results := make([]*Tweet, 0, len(tweets))
+	for i, tw := range tweets {
+		if id, ok := missing[i]; ok {
+			if tw = fetched[id]; tw == nil {
+				lost++
+				continue
+			}
+		}
+		results = append(results, tw)
+	}
+	total := len(missing) + len(nested)
+	refetchStats.recovered.Add(int32(total - lost))
+	refetchStats.lost.Add(int32(lost))
+	log.Debugf("refetched %d tweets without legacy", total-lost)
+	if lost != 0 {
+		log.Warnf("%d tweets without legacy could not be recovered", lost)
+	}
+	return results, skipped
+}
+
+// 推文完整却无法被 parseTweetResults 解析，区别于不可用的推文
+func unparsable(tweet_results *gjson.Result) bool {
+	result := tweet_results.Get("result")
+	if result.Get("__typename").String() == "TweetWithVisibilityResults" {
+		result = result.Get("tweet")
+	}
+	return result.Get("legacy").Exists()
+}
@@ -0,0 +1,68 @@
+package twitter
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/tidwall/gjson"
+)
+
+const spaceUrlPrefix = "https://x.com/i/spaces/"
+
+// 从推文的卡片中解析语音空间，卡片不是语音空间返回 nil
+func parseSpaceCard(card *gjson.Result) *Media {
+	if !strings.HasSuffix(card.Get("name").String(), "audiospace") {
+		return nil
+	}
+	for _, binding := range card.Get("binding_values").Array() {
+		if binding.Get("key").String() == "id" {
+			return &Media{Type: MT_SPACE, Url: spaceUrlPrefix + binding.Get("value.string_value").String()}
+		}
+	}
+	return nil
+}
+
+// 语音空间的 id，不是语音空间返回空串
+func (m *Media) SpaceId() string {
+	if m.Type != MT_SPACE {
+		return ""
+	}
+	return strings.TrimPrefix(m.Url, spaceUrlPrefix)
+}
+
+// 获取已结束语音空间回放的 m3u8 播放列表地址
+func GetSpacePlaylist(ctx context.Context, client *resty.Client, spaceId string) (string, error) {
+	api := audioSpaceById{spaceId}
+	resp, err := client.R().SetContext(ctx).Get(makeUrl(&api))
+	if err != nil {
+		return "", fmt.Errorf("failed to get space [%s]: %v", spaceId, err)
+	}
+
+	metadata := gjson.GetBytes(resp.Body(), "data.audioSpace.metadata")
+	if !metadata.Exists() {
+		return "", fmt.Errorf("space [%s] does not exist", spaceId)
+	}
+	if state := metadata.Get("state").String(); state != "Ended" {
+		return "", fmt.Errorf("space [%s] is not ended: %s", spaceId, state)
+	}
+	if !metadata.Get("is_space_available_for_replay").Bool() {
+		return "", fmt.Errorf("space [%s] is not available for replay", spaceId)
+	}
+
+	u, _ := url.Parse(HOST)
+	u = u.JoinPath("/i/api/1.1/live_video_stream/status", metadata.Get("media_key").String())
+	u.RawQuery = "client=web&use_syndication_guest_id=false&cookie_set_host=x.com"
+	resp, err = client.R().SetContext(ctx).Get(u.String())
+	if err != nil {
+		return "", fmt.Errorf("failed to get stream of space [%s]: %v", spaceId, err)
+	}
+
+	location := gjson.GetBytes(resp.Body(), "source.location")
+	if !location.Exists() {
+		return "", fmt.Errorf("space [%s] has no playlist", spaceId)
+	}
+	return location.String(), nil
+}
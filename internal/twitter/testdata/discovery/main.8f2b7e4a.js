@@ -0,0 +1 @@
+(self.webpackChunk_twitter_responsive_web=self.webpackChunk_twitter_responsive_web||[]).push([["main"],{12345:e=>{e.exports={queryId:"NEWMediaQueryId-abc_123",operationName:"UserMedia",operationType:"query",metadata:{featureSwitches:["rweb_tipjar_consumption_enabled","verified_phone_label_enabled","longform_notetweets_consumption_enabled","new_feature_enabled"],fieldToggles:["withArticlePlainText"]}}},12346:e=>{e.exports={queryId:"NEWUserByRestIdQid",operationName:"UserByRestId",operationType:"query",metadata:{featureSwitches:["responsive_web_graphql_timeline_navigation_enabled"],fieldToggles:[]}}},12347:e=>{e.exports={queryId:"FollowMutationQid",operationName:"CreateBookmark",operationType:"mutation",metadata:{featureSwitches:[],fieldToggles:[]}}}}]);
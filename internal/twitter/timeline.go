@@ -13,12 +13,12 @@ const (
 	timelineUser
 )
 
-func getInstructions(resp []byte, path string) gjson.Result {
+func getInstructions(resp []byte, path string) (gjson.Result, error) {
 	inst := gjson.GetBytes(resp, path)
 	if !inst.Exists() {
-		panic(fmt.Sprintf("unable to get instructions: %s path: '%s'", resp, path))
+		return inst, newParseError("instructions", string(resp), fmt.Errorf("path '%s' does not exist", path))
 	}
-	return inst
+	return inst, nil
 }
 
 func getEntries(instructions gjson.Result) gjson.Result {
@@ -39,7 +39,7 @@ func getModuleItems(instructions gjson.Result) gjson.Result {
 	return gjson.Result{}
 }
 
-func getNextCursor(entries gjson.Result) string {
+func getNextCursor(entries gjson.Result) (string, error) {
 	array := entries.Array()
 	// if len(array) == 2 {
 	// 	return "" // no next page
@@ -48,41 +48,84 @@ func getNextCursor(entries gjson.Result) string {
 	for i := len(array) - 1; i >= 0; i-- {
 		if array[i].Get("content.entryType").String() == "TimelineTimelineCursor" &&
 			array[i].Get("content.cursorType").String() == "Bottom" {
-			return array[i].Get("content.value").String()
+			return array[i].Get("content.value").String(), nil
 		}
 	}
-
-	panic(fmt.Sprintf("invalid entries: %s", entries.String()))
+	return "", newParseError("entries", entries.Raw, fmt.Errorf("no bottom cursor"))
 }
 
-func getItemContentFromModuleItem(moduleItem gjson.Result) gjson.Result {
+func getItemContentFromModuleItem(moduleItem gjson.Result) (gjson.Result, error) {
 	res := moduleItem.Get("item.itemContent")
 	if !res.Exists() {
-		panic(fmt.Errorf("invalid ModuleItem: %s", moduleItem.String()))
+		return res, newParseError("module item", moduleItem.Raw, nil)
 	}
-	return res
+	return res, nil
 }
 
-func getItemContentsFromEntry(entry gjson.Result) []gjson.Result {
+func getItemContentsFromEntry(entry gjson.Result) ([]gjson.Result, error) {
 	content := entry.Get("content")
 	ty := content.Get("entryType").String()
 	if ty == "TimelineTimelineModule" {
-		return content.Get("items.#.item.itemContent").Array()
+		return content.Get("items.#.item.itemContent").Array(), nil
 	} else if ty == "TimelineTimelineItem" {
-		return []gjson.Result{content.Get("itemContent")}
+		return []gjson.Result{content.Get("itemContent")}, nil
 	}
-
-	panic(fmt.Sprintf("invalid entry: %s", entry.String()))
+	return nil, newParseError("entry", entry.Raw, fmt.Errorf("unknown entry type '%s'", ty))
 }
 
+// itemContent 中的 tweet_results 或 user_results，不存在时 Exists() 为假
 func getResults(itemContent gjson.Result, itemType int) gjson.Result {
 	if itemType == timelineTweet {
 		return itemContent.Get("tweet_results")
 	} else if itemType == timelineUser {
 		return itemContent.Get("user_results")
 	}
+	return gjson.Result{}
+}
 
-	panic(fmt.Sprintf("invalid itemContent: %s", itemContent.String()))
+// 解析时间线响应，返回所有 itemContent、底部 cursor 和被跳过的条目数。
+// 无法解析的条目在创建 ParseError 时已被报告，此处跳过
+func parseTimeline(resp []byte, instPath string) ([]gjson.Result, string, int, error) {
+	instructions, err := getInstructions(resp, instPath)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	entries := getEntries(instructions)
+	moduleItems := getModuleItems(instructions)
+	if !entries.Exists() && !moduleItems.Exists() {
+		return nil, "", 0, newParseError("instructions", instructions.Raw, fmt.Errorf("no entries or module items"))
+	}
+
+	itemContents := make([]gjson.Result, 0)
+	skipped := 0
+	if entries.IsArray() {
+		for _, entry := range entries.Array() {
+			if entry.Get("content.entryType").String() == "TimelineTimelineCursor" {
+				continue
+			}
+			contents, err := getItemContentsFromEntry(entry)
+			if err != nil {
+				skipped++
+				continue
+			}
+			itemContents = append(itemContents, contents...)
+		}
+	}
+	if moduleItems.IsArray() {
+		for _, moduleItem := range moduleItems.Array() {
+			content, err := getItemContentFromModuleItem(moduleItem)
+			if err != nil {
+				skipped++
+				continue
+			}
+			itemContents = append(itemContents, content)
+		}
+	}
+	next, err := getNextCursor(entries)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return itemContents, next, skipped, nil
 }
 
 func getTimelineResp(ctx context.Context, api timelineApi, client *resty.Client) ([]byte, error) {
@@ -94,46 +137,26 @@ func getTimelineResp(ctx context.Context, api timelineApi, client *resty.Client)
 	return resp.Body(), nil
 }
 
-// 获取时间线 API 并返回所有 itemContent 和 底部 cursor
-func getTimelineItemContents(ctx context.Context, api timelineApi, client *resty.Client, instPath string) ([]gjson.Result, string, error) {
+// 获取时间线 API 并返回所有 itemContent、底部 cursor 和无法解析而被跳过的条目数
+func getTimelineItemContents(ctx context.Context, api timelineApi, client *resty.Client, instPath string) ([]gjson.Result, string, int, error) {
 	resp, err := getTimelineResp(ctx, api, client)
 	if err != nil {
-		return nil, "", err
+		return nil, "", 0, err
 	}
 
 	// is temporarily unavailable because it violates the Twitter Media Policy.
 	// Protected User's following: Permission denied
 	if string(resp) == "{\"data\":{\"user\":{}}}" {
-		return nil, "", nil
-	}
-	instructions := getInstructions(resp, instPath)
-	entries := getEntries(instructions)
-	moduleItems := getModuleItems(instructions)
-	if !entries.Exists() && !moduleItems.Exists() {
-		panic(fmt.Sprintf("invalid instructions: %s", instructions.String()))
-	}
-
-	itemContents := make([]gjson.Result, 0)
-	if entries.IsArray() {
-		for _, entry := range entries.Array() {
-			if entry.Get("content.entryType").String() != "TimelineTimelineCursor" {
-				itemContents = append(itemContents, getItemContentsFromEntry(entry)...)
-			}
-		}
-	}
-	if moduleItems.IsArray() {
-		for _, moduleItem := range moduleItems.Array() {
-			itemContents = append(itemContents, getItemContentFromModuleItem(moduleItem))
-		}
+		return nil, "", 0, nil
 	}
-	return itemContents, getNextCursor(entries), nil
+	return parseTimeline(resp, instPath)
 }
 
 func getTimelineItemContentsTillEnd(ctx context.Context, api timelineApi, client *resty.Client, instPath string) ([]gjson.Result, error) {
 	res := make([]gjson.Result, 0)
 
 	for {
-		page, next, err := getTimelineItemContents(ctx, api, client, instPath)
+		page, next, _, err := getTimelineItemContents(ctx, api, client, instPath)
 		if err != nil {
 			return nil, err
 		}
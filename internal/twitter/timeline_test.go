@@ -0,0 +1,67 @@
+package twitter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestParseTimelineErrors(t *testing.T) {
+	BadPayloadDir = t.TempDir()
+	defer func() { BadPayloadDir = "" }()
+	ResetParseErrors()
+	defer ResetParseErrors()
+
+	const instPath = "data.user.result.timeline_v2.timeline.instructions"
+	tweet := func(id string, createdAt string) string {
+		return `{"entryType": "TimelineTimelineItem", "itemContent": {"tweet_results": {"result": {"rest_id": "` + id +
+			`", "legacy": {"created_at": "` + createdAt + `", "full_text": "text"}}}}}`
+	}
+	cursor := `{"content": {"entryType": "TimelineTimelineCursor", "cursorType": "Bottom", "value": "next"}}`
+
+	// 无法解析的条目和推文被跳过
+	resp := `{"data": {"user": {"result": {"timeline_v2": {"timeline": {"instructions": [{"type": "TimelineAddEntries", "entries": [
+		{"content": ` + tweet("1", "Mon Jan 01 00:00:00 +0000 2024") + `},
+		{"content": ` + tweet("2", "2024-01-01") + `},
+		{"content": {"entryType": "TimelineSomethingNew"}},
+		` + cursor + `]}]}}}}}}`
+	itemContents, next, skipped, err := parseTimeline([]byte(resp), instPath)
+	if err != nil || next != "next" || len(itemContents) != 2 || skipped != 1 {
+		t.Fatalf("parseTimeline = %d items, %q, %d skipped, %v", len(itemContents), next, skipped, err)
+	}
+	tweets, skipped := collectTweets(context.Background(), nil, itemContents)
+	if len(tweets) != 1 || tweets[0].Id != 1 || skipped != 1 {
+		t.Errorf("tweets = %v, %d skipped", tweets, skipped)
+	}
+
+	errs := ParseErrors()
+	if len(errs) != 2 || errs[0].What != "entry" || errs[1].What != "created_at" || errs[1].Subject != "tweet 2" {
+		t.Fatalf("parse errors = %v", errs)
+	}
+	for _, pe := range errs {
+		data, err := os.ReadFile(pe.Path)
+		if err != nil || len(data) == 0 || string(data) != pe.Raw {
+			t.Errorf("saved payload of %v: %q, %v", pe, data, err)
+		}
+	}
+
+	// 无法继续的时间线返回错误
+	for _, resp := range []string{
+		`{"data": {"user": {"result": {}}}}`,
+		`{"data": {"user": {"result": {"timeline_v2": {"timeline": {"instructions": [{"type": "TimelineClearCache"}]}}}}}}`,
+		`{"data": {"user": {"result": {"timeline_v2": {"timeline": {"instructions": [{"type": "TimelineAddEntries", "entries": []}]}}}}}}`,
+	} {
+		_, _, _, err := parseTimeline([]byte(resp), instPath)
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Raw == "" {
+			t.Errorf("%s: err = %v", resp, err)
+		}
+		if withSubject(err, "user alice"); pe != nil && pe.Subject != "user alice" {
+			t.Errorf("subject = %s", pe.Subject)
+		}
+	}
+	if n := len(ParseErrors()); n != 5 {
+		t.Errorf("%d parse errors are recorded, want 5", n)
+	}
+}
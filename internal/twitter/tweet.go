@@ -1,9 +1,14 @@
 package twitter
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
 	"time"
 
+	"github.com/go-resty/resty/v2"
 	"github.com/tidwall/gjson"
 )
 
@@ -12,7 +17,46 @@ type Tweet struct {
 	Text      string
 	CreatedAt time.Time
 	Creator   *User
-	Urls      []string
+	Media     []Media
+	Retweeted *Tweet          // 转推的原推文，转推本身不含媒体
+	Quoted    *Tweet          // 引用的推文
+	Legacy    json.RawMessage // GraphQL 响应中原始的 legacy 对象
+}
+
+// 从 legacy 中解析的推文元数据
+type TweetMetadata struct {
+	ReplyTo       uint64   `json:"in_reply_to_status_id,omitempty"`
+	QuotedId      uint64   `json:"quoted_status_id,omitempty"`
+	Hashtags      []string `json:"hashtags"`
+	Mentions      []string `json:"mentions"`
+	FavoriteCount int      `json:"favorite_count"`
+	RetweetCount  int      `json:"retweet_count"`
+	ReplyCount    int      `json:"reply_count"`
+	QuoteCount    int      `json:"quote_count"`
+	BookmarkCount int      `json:"bookmark_count"`
+}
+
+// 解析推文的元数据，Legacy 为空时各项为零值
+func (tw *Tweet) Metadata() *TweetMetadata {
+	legacy := gjson.ParseBytes(tw.Legacy)
+	meta := TweetMetadata{
+		ReplyTo:       legacy.Get("in_reply_to_status_id_str").Uint(),
+		QuotedId:      legacy.Get("quoted_status_id_str").Uint(),
+		Hashtags:      []string{},
+		Mentions:      []string{},
+		FavoriteCount: int(legacy.Get("favorite_count").Int()),
+		RetweetCount:  int(legacy.Get("retweet_count").Int()),
+		ReplyCount:    int(legacy.Get("reply_count").Int()),
+		QuoteCount:    int(legacy.Get("quote_count").Int()),
+		BookmarkCount: int(legacy.Get("bookmark_count").Int()),
+	}
+	for _, tag := range legacy.Get("entities.hashtags.#.text").Array() {
+		meta.Hashtags = append(meta.Hashtags, tag.String())
+	}
+	for _, mention := range legacy.Get("entities.user_mentions.#.screen_name").Array() {
+		meta.Mentions = append(meta.Mentions, mention.String())
+	}
+	return &meta
 }
 
 func parseTweetResults(tweet_results *gjson.Result) *Tweet {
@@ -27,7 +71,7 @@ func parseTweetResults(tweet_results *gjson.Result) *Tweet {
 		result = result.Get("tweet")
 	}
 	legacy := result.Get("legacy")
-	// TODO: 利用 rest_id 重新获取推文信息
+	// 缺少 legacy 的推文由 collectTweets 按 rest_id 重新获取
 	if !legacy.Exists() {
 		return nil
 	}
@@ -35,37 +79,69 @@ func parseTweetResults(tweet_results *gjson.Result) *Tweet {
 
 	tweet.Id = result.Get("rest_id").Uint()
 	tweet.Text = legacy.Get("full_text").String()
+	tweet.Legacy = json.RawMessage(legacy.Raw)
 	tweet.Creator, _ = parseUserResults(&user_results)
 	tweet.CreatedAt, err = time.Parse(time.RubyDate, legacy.Get("created_at").String())
 	if err != nil {
-		panic(fmt.Errorf("invalid time format %v", err))
+		// 跳过此推文
+		pe := newParseError("created_at", result.Raw, err)
+		withSubject(pe, fmt.Sprintf("tweet %d", tweet.Id))
+		return nil
 	}
 	media := legacy.Get("extended_entities.media")
 	if media.Exists() {
-		tweet.Urls = getUrlsFromMedia(&media)
+		tweet.Media = parseMedia(&media)
+	}
+	card := result.Get("card.legacy")
+	if space := parseSpaceCard(&card); space != nil {
+		tweet.Media = append(tweet.Media, *space)
+	}
+
+	if retweeted := legacy.Get("retweeted_status_result"); retweeted.Exists() {
+		tweet.Retweeted = parseTweetResults(&retweeted)
+		tweet.Media = nil
+	}
+	if quoted := result.Get("quoted_status_result"); quoted.Exists() {
+		tweet.Quoted = parseTweetResults(&quoted)
 	}
 	return &tweet
 }
 
-func getUrlsFromMedia(media *gjson.Result) []string {
-	results := []string{}
-	for _, m := range media.Array() {
-		typ := m.Get("type").String()
-		if typ == "video" || typ == "animated_gif" {
-			results = append(results, m.Get("video_info.variants.@reverse.0.url").String())
-		} else if typ == "photo" {
-			results = append(results, m.Get("media_url_https").String())
-		}
-	}
-	return results
+func GetTweet(ctx context.Context, client *resty.Client, id uint64) (*Tweet, error) {
+	api := tweetResultByRestId{id}
+	resp, err := client.R().SetContext(ctx).Get(makeUrl(&api))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tweet [%d]: %v", id, err)
+	}
+
+	tweetResult := gjson.GetBytes(resp.Body(), "data.tweetResult")
+	tweet := parseTweetResults(&tweetResult)
+	if tweet == nil {
+		return nil, fmt.Errorf("tweet [%d] is unavailable", id)
+	}
+	return tweet, nil
 }
 
-// ended audio space
+// 推文的链接，作者未知时使用 https://x.com/i/status/<id>
+func (tw *Tweet) Url() string {
+	screenName := "i"
+	if tw.Creator != nil && tw.Creator.ScreenName != "" {
+		screenName = tw.Creator.ScreenName
+	}
+	return fmt.Sprintf("https://x.com/%s/status/%d", screenName, tw.Id)
+}
 
-/*
-id = ?
-media_key = audio_space_by_id()
-live_video_stream = get https://x.com/i/api/1.1/live_video_stream/status/{media_key}?client=web&use_syndication_guest_id=false&cookie_set_host=x.com
-playlist = live_video_stream.source.location
-handle playlist...
-*/
+var tweetUrlPattern = regexp.MustCompile(`^(?:https?://)?(?:[\w-]+\.)?(?:x|twitter)\.com/(?:i/web|[^/]+)/status(?:es)?/(\d+)`)
+
+// 从推文链接（https://x.com/<screen_name>/status/<id>）或推文 id 中解析推文 id
+func ParseTweetId(str string) (uint64, error) {
+	if id, err := strconv.ParseUint(str, 10, 64); err == nil {
+		return id, nil
+	}
+
+	subs := tweetUrlPattern.FindStringSubmatch(str)
+	if len(subs) == 0 {
+		return 0, fmt.Errorf("invalid tweet id or url: %s", str)
+	}
+	return strconv.ParseUint(subs[1], 10, 64)
+}
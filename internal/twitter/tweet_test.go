@@ -0,0 +1,307 @@
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/tidwall/gjson"
+)
+
+func TestParseTweetId(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    uint64
+		wantErr bool
+	}{
+		{input: "1234567890", want: 1234567890},
+		{input: "https://x.com/elonmusk/status/1234567890", want: 1234567890},
+		{input: "https://twitter.com/elonmusk/status/1234567890?s=20", want: 1234567890},
+		{input: "https://mobile.twitter.com/elonmusk/statuses/1234567890", want: 1234567890},
+		{input: "https://x.com/elonmusk/status/1234567890/photo/1", want: 1234567890},
+		{input: "x.com/i/web/status/1234567890", want: 1234567890},
+		{input: "https://x.com/elonmusk", wantErr: true},
+		{input: "https://example.com/elonmusk/status/1234567890", wantErr: true},
+		{input: "elonmusk", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			id, err := ParseTweetId(test.input)
+			if (err != nil) != test.wantErr {
+				t.Errorf("ParseTweetId(%q) err = %v, want error: %v", test.input, err, test.wantErr)
+				return
+			}
+			if id != test.want {
+				t.Errorf("ParseTweetId(%q) = %d, want %d", test.input, id, test.want)
+			}
+		})
+	}
+}
+
+func TestParseMedia(t *testing.T) {
+	media := gjson.Parse(`[
+		{"type": "photo", "media_url_https": "https://pbs.twimg.com/media/a.jpg", "original_info": {"width": 1200, "height": 800}},
+		{"type": "video", "original_info": {"width": 1280, "height": 720}, "video_info": {"duration_millis": 1500, "variants": [
+			{"content_type": "application/x-mpegURL", "url": "https://video.twimg.com/ext_tw_video/1/pu/pl/b.m3u8"},
+			{"bitrate": 832000, "content_type": "video/mp4", "url": "https://video.twimg.com/ext_tw_video/1/pu/vid/640x360/b.mp4"}
+		]}},
+		{"type": "animated_gif", "video_info": {"variants": [
+			{"bitrate": 0, "content_type": "video/mp4", "url": "https://video.twimg.com/tweet_video/c.mp4"}
+		]}},
+		{"type": "unknown"}
+	]`)
+
+	want := []Media{
+		{Type: MT_PHOTO, Url: "https://pbs.twimg.com/media/a.jpg", Width: 1200, Height: 800},
+		{Type: MT_VIDEO, Url: "https://video.twimg.com/ext_tw_video/1/pu/vid/640x360/b.mp4", Width: 1280, Height: 720, Duration: 1500 * time.Millisecond, Bitrate: 832000},
+		{Type: MT_GIF, Url: "https://video.twimg.com/tweet_video/c.mp4"},
+	}
+	got := parseMedia(&media)
+	if len(got) != len(want) {
+		t.Fatalf("len(media) = %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		got[i].Variants = nil
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("media[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestUnmarshalLegacyTweet(t *testing.T) {
+	data := `{"Id": 1, "Text": "a", "Urls": ["https://pbs.twimg.com/media/a.jpg", "https://video.twimg.com/ext_tw_video/1/pu/vid/b.mp4", "https://video.twimg.com/tweet_video/c.mp4"]}`
+	var tweet Tweet
+	if err := json.Unmarshal([]byte(data), &tweet); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []MediaType{MT_PHOTO, MT_VIDEO, MT_GIF}
+	if len(tweet.Media) != len(want) {
+		t.Fatalf("len(media) = %d, want %d", len(tweet.Media), len(want))
+	}
+	for i, typ := range want {
+		if tweet.Media[i].Type != typ {
+			t.Errorf("media[%d].Type = %s, want %s", i, tweet.Media[i].Type, typ)
+		}
+	}
+	if tweet.Id != 1 || tweet.Text != "a" {
+		t.Errorf("tweet = %+v", tweet)
+	}
+}
+
+func TestVideoVariants(t *testing.T) {
+	data, err := os.ReadFile("testdata/video_media.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	media := gjson.Parse("[" + string(data) + "]")
+	parsed := parseMedia(&media)
+	if len(parsed) != 1 {
+		t.Fatalf("len(media) = %d, want 1", len(parsed))
+	}
+	video := parsed[0]
+
+	wantVariants := []Variant{
+		{Bitrate: 2176000, ContentType: "video/mp4", Width: 720, Height: 1280},
+		{ContentType: "application/x-mpegURL"},
+		{Bitrate: 632000, ContentType: "video/mp4", Width: 320, Height: 568},
+		{Bitrate: 10368000, ContentType: "video/mp4", Width: 1080, Height: 1920},
+		{Bitrate: 950000, ContentType: "video/mp4", Width: 480, Height: 852},
+	}
+	if len(video.Variants) != len(wantVariants) {
+		t.Fatalf("len(variants) = %d, want %d", len(video.Variants), len(wantVariants))
+	}
+	for i, want := range wantVariants {
+		got := video.Variants[i]
+		got.Url = ""
+		if got != want {
+			t.Errorf("variants[%d] = %+v, want %+v", i, got, want)
+		}
+	}
+	if video.Bitrate != 10368000 || video.Duration != 30033*time.Millisecond {
+		t.Errorf("bitrate = %d, duration = %v", video.Bitrate, video.Duration)
+	}
+
+	tests := []struct {
+		quality string
+		want    int
+	}{
+		{"highest", 10368000},
+		{"", 10368000},
+		{"lowest", 632000},
+		{"<=720p", 2176000},
+		{"≤480p", 950000},
+		{"<=360", 632000},
+		{"<=144p", 632000}, // 没有满足上限的变体
+	}
+	for _, test := range tests {
+		quality, err := ParseVideoQuality(test.quality)
+		if err != nil {
+			t.Errorf("ParseVideoQuality(%q): %v", test.quality, err)
+			continue
+		}
+		if variant := video.SelectVariant(quality); variant == nil || variant.Bitrate != test.want {
+			t.Errorf("SelectVariant(%q) = %+v, want bitrate %d", test.quality, variant, test.want)
+		}
+	}
+
+	for _, bad := range []string{"best", "720p", "<=p", "<=0p"} {
+		if _, err := ParseVideoQuality(bad); err == nil {
+			t.Errorf("ParseVideoQuality(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestParseSpaceCard(t *testing.T) {
+	card := gjson.Parse(`{"name": "3691233323:audiospace", "binding_values": [
+		{"key": "narrow_cast_space_type", "value": {"string_value": "0", "type": "STRING"}},
+		{"key": "id", "value": {"string_value": "1YqKDqDXAbwKV", "type": "STRING"}}
+	]}`)
+	space := parseSpaceCard(&card)
+	if space == nil || space.Type != MT_SPACE || space.SpaceId() != "1YqKDqDXAbwKV" {
+		t.Errorf("space = %+v", space)
+	}
+
+	card = gjson.Parse(`{"name": "summary_large_image", "binding_values": [{"key": "id", "value": {"string_value": "1"}}]}`)
+	if space := parseSpaceCard(&card); space != nil {
+		t.Errorf("parsed space from a non-space card: %+v", space)
+	}
+}
+
+func TestParseRetweetAndQuote(t *testing.T) {
+	tweetJson := func(id int, screenName string, extra string, legacyExtra string) string {
+		return `{"result": {"__typename": "Tweet", "rest_id": "` + strconv.Itoa(id) + `",
+			"core": {"user_results": {"result": {"rest_id": "` + strconv.Itoa(id*10) + `", "legacy": {"screen_name": "` + screenName + `"}}}},
+			"legacy": {"full_text": "tweet ` + strconv.Itoa(id) + `", "created_at": "Mon Jul 01 08:00:00 +0000 2024",
+				"extended_entities": {"media": [{"type": "photo", "media_url_https": "https://pbs.twimg.com/media/` + strconv.Itoa(id) + `.jpg"}]}` + legacyExtra + `}` + extra + `}}`
+	}
+
+	original := tweetJson(1, "author", "", "")
+	retweet := gjson.Parse(tweetJson(2, "retweeter", "", `, "retweeted_status_result": `+original))
+	tw := parseTweetResults(&retweet)
+	if tw.Retweeted == nil || tw.Retweeted.Id != 1 || tw.Retweeted.Creator.ScreenName != "author" || len(tw.Retweeted.Media) != 1 {
+		t.Errorf("retweeted = %+v", tw.Retweeted)
+	}
+	if len(tw.Media) != 0 || tw.Creator.ScreenName != "retweeter" {
+		t.Errorf("retweet: media = %v, creator = %v", tw.Media, tw.Creator)
+	}
+
+	quote := gjson.Parse(tweetJson(3, "quoter", `, "quoted_status_result": `+original, ""))
+	tw = parseTweetResults(&quote)
+	if tw.Quoted == nil || tw.Quoted.Id != 1 || len(tw.Quoted.Media) != 1 {
+		t.Errorf("quoted = %+v", tw.Quoted)
+	}
+	if len(tw.Media) != 1 || tw.Retweeted != nil {
+		t.Errorf("quote: media = %v, retweeted = %v", tw.Media, tw.Retweeted)
+	}
+}
+
+func TestGetTweetsIncomplete(t *testing.T) {
+	ResetParseErrors()
+	defer ResetParseErrors()
+	body := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	host := HOST
+	HOST = server.URL
+	defer func() { HOST = host }()
+
+	tombstone := `{"result": {"__typename": "TweetTombstone"}}`
+	tests := []struct {
+		body    string
+		wantErr bool
+	}{
+		{`{"data": {"tweetResult": [` + tombstone + `, ` + tombstone + `]}}`, false},
+		{`{"data": {"tweetResult": [` + tombstone + `]}}`, true},
+		{`{"data": {}}`, true},
+		{`{"errors": [{"message": "unknown"}]}`, true},
+	}
+	for _, test := range tests {
+		body = test.body
+		tweets, err := GetTweets(context.Background(), resty.New(), []uint64{1, 2})
+		if (err != nil) != test.wantErr || len(tweets) != 0 {
+			t.Errorf("%s: %v, %v", test.body, tweets, err)
+		}
+	}
+}
+
+func TestCollectNestedWithoutLegacy(t *testing.T) {
+	tweetJson := func(id int, legacyExtra string, extra string) string {
+		return `{"__typename": "Tweet", "rest_id": "` + strconv.Itoa(id) + `",
+			"core": {"user_results": {"result": {"rest_id": "` + strconv.Itoa(id*10) + `", "legacy": {"screen_name": "user` + strconv.Itoa(id) + `"}}}},
+			"legacy": {"full_text": "tweet ` + strconv.Itoa(id) + `", "created_at": "Mon Jul 01 08:00:00 +0000 2024",
+				"extended_entities": {"media": [{"type": "photo", "media_url_https": "https://pbs.twimg.com/media/` + strconv.Itoa(id) + `.jpg"}]}` + legacyExtra + `}` + extra + `}`
+	}
+	partial := func(id int) string {
+		return `{"result": {"__typename": "Tweet", "rest_id": "` + strconv.Itoa(id) + `"}}`
+	}
+	item := func(result string) gjson.Result {
+		return gjson.Parse(`{"tweet_results": {"result": ` + result + `}}`)
+	}
+
+	// 按 id 返回完整的推文，3 不可用
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var vars struct {
+			TweetIds []string `json:"tweetIds"`
+		}
+		json.Unmarshal([]byte(r.URL.Query().Get("variables")), &vars)
+		requested = append(requested, vars.TweetIds...)
+		results := []string{}
+		for _, id := range vars.TweetIds {
+			if id == "3" {
+				results = append(results, `{"result": {"__typename": "TweetTombstone"}}`)
+				continue
+			}
+			n, _ := strconv.Atoi(id)
+			results = append(results, `{"result": `+tweetJson(n, "", "")+`}`)
+		}
+		w.Write([]byte(`{"data": {"tweetResult": [` + strings.Join(results, ",") + `]}}`))
+	}))
+	defer server.Close()
+	host := HOST
+	HOST = server.URL
+	defer func() { HOST = host }()
+
+	recovered, lost := RefetchStats()
+	itemContents := []gjson.Result{
+		item(tweetJson(10, `, "retweeted_status_result": `+partial(1), "")),
+		item(tweetJson(11, "", `, "quoted_status_result": `+partial(2))),
+		item(tweetJson(12, "", `, "quoted_status_result": `+partial(1))),
+		item(tweetJson(13, `, "retweeted_status_result": `+partial(3), "")),
+	}
+	tweets, skipped := collectTweets(context.Background(), resty.New(), itemContents)
+	if len(tweets) != 4 || skipped != 0 {
+		t.Fatalf("tweets = %v, skipped = %d", tweets, skipped)
+	}
+	if tw := tweets[0]; tw.Retweeted == nil || tw.Retweeted.Id != 1 || len(tw.Retweeted.Media) != 1 || len(tw.Media) != 0 {
+		t.Errorf("retweet = %+v, retweeted = %+v", tw, tw.Retweeted)
+	}
+	if tw := tweets[1]; tw.Quoted == nil || tw.Quoted.Id != 2 || len(tw.Media) != 1 {
+		t.Errorf("quote = %+v, quoted = %+v", tw, tw.Quoted)
+	}
+	if tw := tweets[2]; tw.Quoted == nil || tw.Quoted.Id != 1 {
+		t.Errorf("quoted = %+v", tw.Quoted)
+	}
+	if tweets[3].Retweeted != nil {
+		t.Errorf("unavailable retweeted = %+v", tweets[3].Retweeted)
+	}
+	// 同一推文只请求一次
+	if !reflect.DeepEqual(requested, []string{"1", "2", "3"}) {
+		t.Errorf("requested %v", requested)
+	}
+	if r, l := RefetchStats(); r-recovered != 3 || l-lost != 1 {
+		t.Errorf("recovered = %d, lost = %d", r-recovered, l-lost)
+	}
+}
@@ -3,6 +3,7 @@ package twitter
 import (
 	"context"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -109,24 +110,18 @@ func (u *User) IsVisiable() bool {
 	return u.Followstate == FS_FOLLOWING || !u.IsProtected
 }
 
-func itemContentsToTweets(itemContents []gjson.Result) []*Tweet {
-	res := make([]*Tweet, 0, len(itemContents))
-	for _, itemContent := range itemContents {
-		tweetResults := getResults(itemContent, timelineTweet)
-		if tw := parseTweetResults(&tweetResults); tw != nil {
-			res = append(res, tw)
-		}
-	}
-	return res
-}
-
-func (u *User) getMediasOnePage(ctx context.Context, api *userMedia, client *resty.Client) ([]*Tweet, string, error) {
+// 获取一页用户媒体，返回推文、下一页的 cursor 和无法解析而被跳过的条目数
+func (u *User) getMediasOnePage(ctx context.Context, api *userMedia, client *resty.Client) ([]*Tweet, string, int, error) {
 	if !u.IsVisiable() {
-		return nil, "", nil
+		return nil, "", 0, nil
 	}
 
-	itemContents, next, err := getTimelineItemContents(ctx, api, client, "data.user.result.timeline_v2.timeline.instructions")
-	return itemContentsToTweets(itemContents), next, err
+	itemContents, next, skipped, err := getTimelineItemContents(ctx, api, client, "data.user.result.timeline_v2.timeline.instructions")
+	if err != nil {
+		return nil, "", 0, err
+	}
+	tweets, n := collectTweets(ctx, client, itemContents)
+	return tweets, next, skipped + n, nil
 }
 
 // 在逆序切片中，筛选出在 timerange 范围内的推文
@@ -165,9 +160,11 @@ func filterTweetsByTimeRange(tweets []*Tweet, min *time.Time, max *time.Time) (c
 	return
 }
 
-func (u *User) GetMeidas(ctx context.Context, client *resty.Client, timeRange *utils.TimeRange) ([]*Tweet, error) {
+// 获取用户在 timeRange 内的媒体推文（按发布时间逆序），同时返回无法解析而被跳过的条目数。
+// 有条目被跳过时结果不完整，调用者不应据此推进最新发布时间
+func (u *User) GetMeidas(ctx context.Context, client *resty.Client, timeRange *utils.TimeRange) ([]*Tweet, int, error) {
 	if !u.IsVisiable() {
-		return nil, nil
+		return nil, 0, nil
 	}
 
 	api := userMedia{}
@@ -176,6 +173,7 @@ func (u *User) GetMeidas(ctx context.Context, client *resty.Client, timeRange *u
 	api.userId = u.Id
 
 	results := make([]*Tweet, 0)
+	skipped := 0
 
 	var minTime *time.Time
 	var maxTime *time.Time
@@ -186,10 +184,11 @@ func (u *User) GetMeidas(ctx context.Context, client *resty.Client, timeRange *u
 	}
 
 	for {
-		currentTweets, next, err := u.getMediasOnePage(ctx, &api, client)
+		currentTweets, next, n, err := u.getMediasOnePage(ctx, &api, client)
 		if err != nil {
-			return nil, err
+			return nil, 0, withSubject(err, "user "+u.Title())
 		}
+		skipped += n
 
 		if len(currentTweets) == 0 {
 			break // empty page
@@ -213,7 +212,43 @@ func (u *User) GetMeidas(ctx context.Context, client *resty.Client, timeRange *u
 			maxTime = nil
 		}
 	}
-	return results, nil
+	return results, skipped, nil
+}
+
+// 获取用户喜欢的推文（按喜欢的时间逆序），遇到 id 在 until 中的任一推文时停止，until 为空则获取全部。
+// 同时返回无法解析而被跳过的条目数
+func (u *User) GetLikes(ctx context.Context, client *resty.Client, until []uint64) ([]*Tweet, int, error) {
+	if !u.IsVisiable() {
+		return nil, 0, nil
+	}
+
+	api := likes{}
+	api.count = 100
+	api.cursor = ""
+	api.userId = u.Id
+
+	results := make([]*Tweet, 0)
+	skipped := 0
+	for {
+		itemContents, next, n, err := getTimelineItemContents(ctx, &api, client, "data.user.result.timeline_v2.timeline.instructions")
+		if err != nil {
+			return nil, 0, withSubject(err, "likes of "+u.Title())
+		}
+		if len(itemContents) == 0 {
+			break // empty page
+		}
+
+		tweets, m := collectTweets(ctx, client, itemContents)
+		skipped += n + m
+		for _, tw := range tweets {
+			if slices.Contains(until, tw.Id) {
+				return results, skipped, nil
+			}
+			results = append(results, tw)
+		}
+		api.SetCursor(next)
+	}
+	return results, skipped, nil
 }
 
 func (u *User) Title() string {
@@ -225,7 +260,7 @@ func (u *User) Following() UserFollowing {
 }
 
 func FollowUser(ctx context.Context, client *resty.Client, user *User) error {
-	url := "https://x.com/i/api/1.1/friendships/create.json"
+	url := HOST + "/i/api/1.1/friendships/create.json"
 	_, err := client.R().SetFormData(map[string]string{
 		"user_id": fmt.Sprintf("%d", user.Id),
 		// "skip_status":                       1,
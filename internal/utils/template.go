@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 文件名模板，形如 {date:2006-01-02}_{id}_{text:50}，
+// 占位符为 {field} 或 {field:arg}，其值由执行模板时提供
+type NameTemplate struct {
+	parts []namePart
+}
+
+type namePart struct {
+	literal string
+	field   string
+	arg     string
+}
+
+// 解析模板，fields 为允许使用的占位符
+func ParseNameTemplate(str string, fields ...string) (*NameTemplate, error) {
+	tmpl := NameTemplate{}
+	rest := str
+	for len(rest) != 0 {
+		begin := strings.IndexAny(rest, "{}")
+		if begin < 0 {
+			begin = len(rest)
+		}
+		if literal := rest[:begin]; literal != "" {
+			if reWinNonSupport.MatchString(literal) {
+				return nil, fmt.Errorf("invalid character in name template: %s", str)
+			}
+			tmpl.parts = append(tmpl.parts, namePart{literal: literal})
+		}
+		rest = rest[begin:]
+		if len(rest) == 0 {
+			break
+		}
+		if rest[0] == '}' {
+			return nil, fmt.Errorf("unexpected '}' in name template: %s", str)
+		}
+
+		end := strings.IndexByte(rest, '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed '{' in name template: %s", str)
+		}
+		field, arg, _ := strings.Cut(rest[1:end], ":")
+		if !slices.Contains(fields, field) {
+			return nil, fmt.Errorf("unknown field {%s} in name template, available: %s", field, strings.Join(fields, ", "))
+		}
+		tmpl.parts = append(tmpl.parts, namePart{field: field, arg: arg})
+		rest = rest[end+1:]
+	}
+
+	if len(tmpl.parts) == 0 {
+		return nil, fmt.Errorf("empty name template")
+	}
+	return &tmpl, nil
+}
+
+func MustParseNameTemplate(str string, fields ...string) *NameTemplate {
+	tmpl, err := ParseNameTemplate(str, fields...)
+	if err != nil {
+		panic(err)
+	}
+	return tmpl
+}
+
+// 执行模板，value 返回占位符的值，值将被转换为有效的文件名。
+// 结果的长度不超过 maxFileNameLen 字节
+func (t *NameTemplate) Execute(value func(field string, arg string) string) string {
+	builder := strings.Builder{}
+	for _, part := range t.parts {
+		if part.field == "" {
+			builder.WriteString(part.literal)
+		} else {
+			builder.WriteString(WinFileName(value(part.field, part.arg)))
+		}
+	}
+
+	name := builder.String()
+	for len(name) > maxFileNameLen {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// 截取字符串的前 n 个字符，arg 为空或无效时返回原字符串
+func TruncateRunes(str string, arg string) string {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n <= 0 {
+		return str
+	}
+	for i := range str {
+		if n == 0 {
+			return str[:i]
+		}
+		n--
+	}
+	return str
+}
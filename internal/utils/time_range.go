@@ -1,8 +1,40 @@
 package utils
 
-import "time"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type TimeRange struct {
 	Min time.Time
 	Max time.Time
 }
+
+// 解析时间参数：RFC3339 时间，日期 (2006-01-02)，或相对于 now 的时长，如 30d, 2w, 12h
+func ParseTimeArg(str string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, str, time.Local); err == nil {
+		return t, nil
+	}
+
+	var unit time.Duration
+	switch {
+	case strings.HasSuffix(str, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(str, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		n, err := strconv.Atoi(str[:len(str)-1])
+		if err == nil && n >= 0 {
+			return now.Add(-time.Duration(n) * unit), nil
+		}
+	} else if d, err := time.ParseDuration(str); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s", str)
+}
@@ -5,8 +5,11 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
 func TestUniquePath(t *testing.T) {
@@ -338,3 +341,88 @@ func TestSetConsoleTitle(t *testing.T) {
 		t.Errorf("title = %s, want hello", title)
 	}
 }
+
+func TestParseTimeArg(t *testing.T) {
+	now := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		input   string
+		want    time.Time
+		wantErr bool
+	}{
+		{input: "2024-07-01T08:00:00Z", want: time.Date(2024, 7, 1, 8, 0, 0, 0, time.UTC)},
+		{input: "2024-07-01T08:00:00+08:00", want: time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{input: "2024-07-01", want: time.Date(2024, 7, 1, 0, 0, 0, 0, time.Local)},
+		{input: "30d", want: now.AddDate(0, 0, -30)},
+		{input: "2w", want: now.AddDate(0, 0, -14)},
+		{input: "12h", want: now.Add(-12 * time.Hour)},
+		{input: "1h30m", want: now.Add(-90 * time.Minute)},
+		{input: "-3d", wantErr: true},
+		{input: "d", wantErr: true},
+		{input: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseTimeArg(tt.input, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error: %v, got: %v", tt.wantErr, err)
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseTimeArg(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNameTemplate(t *testing.T) {
+	values := map[string]string{
+		"id":   "123",
+		"text": "a/b: hello https://t.co/xyz",
+		"date": "2024-07-01",
+	}
+	value := func(field string, arg string) string {
+		if field == "text" {
+			return TruncateRunes(values[field], arg)
+		}
+		return values[field]
+	}
+
+	tests := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{template: "{text}", want: "ab hello "},
+		{template: "{date}_{id}_{text:4}", want: "2024-07-01_123_ab"},
+		{template: "tweet-{id}", want: "tweet-123"},
+		{template: "{id}{{text}", wantErr: true},
+		{template: "{id", wantErr: true},
+		{template: "id}", wantErr: true},
+		{template: "{unknown}", wantErr: true},
+		{template: "{id}/{text}", wantErr: true},
+		{template: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			tmpl, err := ParseNameTemplate(tt.template, "id", "text", "date")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error: %v, got: %v", tt.wantErr, err)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got := tmpl.Execute(value); got != tt.want {
+				t.Errorf("Execute() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	long := MustParseNameTemplate("{text}_{text}", "text")
+	values["text"] = strings.Repeat("中", 80)
+	if got := long.Execute(value); len(got) > maxFileNameLen || !utf8.ValidString(got) {
+		t.Errorf("len(Execute()) = %d, want <= %d", len(got), maxFileNameLen)
+	}
+}
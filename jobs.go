@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/unkmonster/tmd/internal/downloading"
+	"gopkg.in/yaml.v3"
+)
+
+// jobs.yaml 中的一个任务：要同步的目标及覆盖配置的选项
+type Job struct {
+	Users            []string `yaml:"users,omitempty"` // user_id 或 screen_name
+	Lists            []uint64 `yaml:"lists,omitempty"`
+	Foll             []string `yaml:"foll,omitempty"`  // user_id 或 screen_name
+	Root             string   `yaml:"root,omitempty"`  // 列表和用户目录所在的存储根目录，为空使用配置中的 root_path。数据库不随之改变
+	Media            []string `yaml:"media,omitempty"` // photo, video, gif, space，为空下载全部类型
+	AutoFollow       bool     `yaml:"auto_follow,omitempty"`
+	Since            string   `yaml:"since,omitempty"`
+	Until            string   `yaml:"until,omitempty"`
+	Retweets         string   `yaml:"retweets,omitempty"` // skip, retweeter, author
+	Quotes           string   `yaml:"quotes,omitempty"`   // skip, quoter, author
+	FilenameTemplate string   `yaml:"filename_template,omitempty"`
+}
+
+func readJobs(path string) (map[string]*Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make(map[string]*Job)
+	if err := yaml.Unmarshal(data, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// 将任务转换为运行参数
+func (j *Job) runArgs() (*runArgs, error) {
+	args := runArgs{root: j.Root}
+	args.opts = downloading.BatchOptions{AutoFollow: j.AutoFollow}
+
+	for _, user := range j.Users {
+		if err := args.usrArgs.Set(user); err != nil {
+			return nil, err
+		}
+	}
+	for _, user := range j.Foll {
+		if err := args.follArgs.Set(user); err != nil {
+			return nil, err
+		}
+	}
+	args.listArgs.id = append(args.listArgs.id, j.Lists...)
+	if len(j.Users) == 0 && len(j.Lists) == 0 && len(j.Foll) == 0 {
+		return nil, fmt.Errorf("no users, lists or foll in job")
+	}
+
+	if len(j.Media) != 0 {
+		if err := args.media.Set(strings.Join(j.Media, ",")); err != nil {
+			return nil, err
+		}
+	}
+	if j.FilenameTemplate != "" {
+		tmpl, err := downloading.ParseMediaNameTemplate(j.FilenameTemplate)
+		if err != nil {
+			return nil, err
+		}
+		args.opts.Media.NameTemplate = tmpl
+	}
+
+	var since, until timeArg
+	if j.Since != "" {
+		if err := since.Set(j.Since); err != nil {
+			return nil, err
+		}
+	}
+	if j.Until != "" {
+		if err := until.Set(j.Until); err != nil {
+			return nil, err
+		}
+	}
+	args.opts.Since = since.Time
+	args.opts.Until = until.Time
+
+	var retweets = repostArg{downloading.RP_SELF}
+	var quotes = repostArg{downloading.RP_SKIP}
+	if j.Retweets != "" {
+		if err := retweets.Set(j.Retweets); err != nil {
+			return nil, err
+		}
+	}
+	if j.Quotes != "" {
+		if err := quotes.Set(j.Quotes); err != nil {
+			return nil, err
+		}
+	}
+	args.opts.Retweets = retweets.policy
+	args.opts.Quotes = quotes.policy
+	return &args, nil
+}
+
+// 解析任务名及其前后的标志，如 tmd run --dbg myjob 或 tmd run myjob --dbg。没有任务名时返回空串
+func parseJobArgs(flags *flag.FlagSet, args []string) (string, error) {
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	if flags.NArg() == 0 {
+		return "", nil
+	}
+	name := flags.Arg(0)
+	if err := flags.Parse(flags.Args()[1:]); err != nil {
+		return "", err
+	}
+	if flags.NArg() != 0 {
+		return "", fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	return name, nil
+}
+
+func runJob(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	dbg := flags.Bool("dbg", false, "display debug message")
+	noRetry := flags.Bool("no-retry", false, "quickly exit without retrying failed tweets")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s run [flags] <job> [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	name, err := parseJobArgs(flags, args)
+	if err != nil {
+		flags.Usage()
+		return err
+	}
+
+	path := filepath.Join(getAppRootPath(), "jobs.yaml")
+	jobs, err := readJobs(path)
+	if err != nil {
+		return fmt.Errorf("failed to load jobs: %v", err)
+	}
+	names := []string{}
+	for name := range jobs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	if name == "" {
+		flags.Usage()
+		return fmt.Errorf("jobs in %s: %s", path, strings.Join(names, ", "))
+	}
+	job := jobs[name]
+	if job == nil {
+		return fmt.Errorf("job '%s' does not exist, available: %s", name, strings.Join(names, ", "))
+	}
+	run, err := job.runArgs()
+	if err != nil {
+		return fmt.Errorf("invalid job '%s': %v", name, err)
+	}
+	run.dbg = *dbg
+	run.noRetry = *noRetry
+	execute(run)
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/unkmonster/tmd/internal/downloading"
+	"github.com/unkmonster/tmd/internal/twitter"
+)
+
+func TestReadJobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.yaml")
+	data := `
+art:
+  lists: [1234]
+  users: [alice, 42]
+  foll: [bob]
+  root: /data/art
+  media: [photo, gif]
+  auto_follow: true
+  since: 2024-01-01
+  retweets: author
+  quotes: quoter
+  filename_template: "{id}_{index}{ext}"
+empty: {}
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := readJobs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Job{
+		Users:            []string{"alice", "42"},
+		Lists:            []uint64{1234},
+		Foll:             []string{"bob"},
+		Root:             "/data/art",
+		Media:            []string{"photo", "gif"},
+		AutoFollow:       true,
+		Since:            "2024-01-01",
+		Retweets:         "author",
+		Quotes:           "quoter",
+		FilenameTemplate: "{id}_{index}{ext}",
+	}
+	if len(jobs) != 2 || !reflect.DeepEqual(jobs["art"], want) {
+		t.Errorf("jobs = %v, art = %+v", jobs, jobs["art"])
+	}
+
+	if _, err := readJobs(filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestJobRunArgs(t *testing.T) {
+	job := &Job{
+		Users:      []string{"alice", "42"},
+		Lists:      []uint64{1234},
+		Foll:       []string{"bob"},
+		Root:       "/data/art",
+		Media:      []string{"photo", "gif"},
+		AutoFollow: true,
+		Since:      "2024-01-01",
+		Retweets:   "author",
+	}
+	args, err := job.runArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args.usrArgs, userArgs{id: []uint64{42}, screenName: []string{"alice"}}) {
+		t.Errorf("users: %+v", args.usrArgs)
+	}
+	if len(args.follArgs.id) != 0 || !reflect.DeepEqual(args.follArgs.screenName, []string{"bob"}) {
+		t.Errorf("foll: %+v", args.follArgs)
+	}
+	if !reflect.DeepEqual(args.listArgs.id, []uint64{1234}) {
+		t.Errorf("lists: %v", args.listArgs.id)
+	}
+	if !reflect.DeepEqual(args.media.types, []twitter.MediaType{twitter.MT_PHOTO, twitter.MT_GIF}) {
+		t.Errorf("media: %v", args.media.types)
+	}
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	if args.root != job.Root || !args.opts.AutoFollow || !args.opts.Since.Equal(since) || !args.opts.Until.IsZero() {
+		t.Errorf("root %s, opts %+v", args.root, args.opts)
+	}
+	// 未设置的引用处理方式与命令行的默认值相同
+	if args.opts.Retweets != downloading.RP_AUTHOR || args.opts.Quotes != downloading.RP_SKIP {
+		t.Errorf("retweets %d, quotes %d", args.opts.Retweets, args.opts.Quotes)
+	}
+	if args.opts.Media.NameTemplate != nil {
+		t.Error("name template is set without filename_template")
+	}
+
+	invalid := []*Job{
+		{},
+		{Users: []string{"alice"}, Media: []string{"audio"}},
+		{Users: []string{"alice"}, Since: "yesterday"},
+		{Users: []string{"alice"}, Retweets: "everyone"},
+		{Users: []string{"alice"}, Quotes: "nobody"},
+		{Users: []string{"alice"}, FilenameTemplate: "{unknown}"},
+	}
+	for _, job := range invalid {
+		if _, err := job.runArgs(); err == nil {
+			t.Errorf("job %+v is valid", job)
+		}
+	}
+}
+
+func TestParseJobArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		name    string
+		dbg     bool
+		noRetry bool
+		wantErr bool
+	}{
+		{[]string{}, "", false, false, false},
+		{[]string{"art"}, "art", false, false, false},
+		{[]string{"--dbg", "art"}, "art", true, false, false},
+		{[]string{"art", "--dbg"}, "art", true, false, false},
+		{[]string{"--no-retry", "art", "--dbg"}, "art", true, true, false},
+		{[]string{"art", "other"}, "", false, false, true},
+	}
+	for _, test := range tests {
+		flags := flag.NewFlagSet("run", flag.ContinueOnError)
+		dbg := flags.Bool("dbg", false, "")
+		noRetry := flags.Bool("no-retry", false, "")
+		name, err := parseJobArgs(flags, test.args)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+		if err == nil && (name != test.name || *dbg != test.dbg || *noRetry != test.noRetry) {
+			t.Errorf("%v: name %q, dbg %v, no-retry %v", test.args, name, *dbg, *noRetry)
+		}
+	}
+}
@@ -11,16 +11,19 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/gookit/color"
 	"github.com/jmoiron/sqlx"
 	"github.com/rifflock/lfshook"
 	log "github.com/sirupsen/logrus"
+	"github.com/unkmonster/tmd/internal/cassette"
 	"github.com/unkmonster/tmd/internal/database"
 	"github.com/unkmonster/tmd/internal/downloading"
 	"github.com/unkmonster/tmd/internal/twitter"
@@ -33,10 +36,26 @@ type Cookie struct {
 	Ct0       string `yaml:"ct0"`
 }
 
+// 额外的存储根目录，匹配的列表及其成员、匹配的用户存储在此目录下
+type RootConfig struct {
+	Path  string   `yaml:"path"`
+	Lists []uint64 `yaml:"lists,omitempty"`
+	Users []string `yaml:"users,omitempty"` // user_id 或 screen_name
+}
+
 type Config struct {
-	RootPath           string `yaml:"root_path"`
-	Cookie             Cookie `yaml:"cookie"`
-	MaxDownloadRoutine int    `yaml:"max_download_routine"`
+	RootPath           string        `yaml:"root_path"`
+	Cookie             Cookie        `yaml:"cookie"`
+	MaxDownloadRoutine int           `yaml:"max_download_routine"`
+	VideoQuality       string        `yaml:"video_quality,omitempty"`
+	FilenameTemplate   string        `yaml:"filename_template,omitempty"`
+	UserDirTemplate    string        `yaml:"user_dir_template,omitempty"`
+	ListDirTemplate    string        `yaml:"list_dir_template,omitempty"`
+	Dedupe             string        `yaml:"dedupe,omitempty"`
+	Sidecar            bool          `yaml:"sidecar,omitempty"`
+	EmbedMetadata      bool          `yaml:"embed_metadata,omitempty"`
+	Daemon             *DaemonConfig `yaml:"daemon,omitempty"`
+	Roots              []RootConfig  `yaml:"roots,omitempty"`
 }
 
 type userArgs struct {
@@ -105,6 +124,31 @@ func (a *intArgs) String() string {
 	return "string array"
 }
 
+type tweetArgs struct {
+	intArgs
+}
+
+func (t *tweetArgs) Set(str string) error {
+	id, err := twitter.ParseTweetId(str)
+	if err != nil {
+		return err
+	}
+	t.id = append(t.id, id)
+	return nil
+}
+
+func (t tweetArgs) GetTweet(ctx context.Context, client *resty.Client) ([]*twitter.Tweet, error) {
+	tweets := []*twitter.Tweet{}
+	for _, id := range t.id {
+		tweet, err := twitter.GetTweet(ctx, client, id)
+		if err != nil {
+			return nil, err
+		}
+		tweets = append(tweets, tweet)
+	}
+	return tweets, nil
+}
+
 type ListArgs struct {
 	intArgs
 }
@@ -121,9 +165,68 @@ func (l ListArgs) GetList(ctx context.Context, client *resty.Client) ([]*twitter
 	return lists, nil
 }
 
+type timeArg struct {
+	time.Time
+}
+
+func (t *timeArg) Set(str string) error {
+	tm, err := utils.ParseTimeArg(str, time.Now())
+	if err != nil {
+		return err
+	}
+	t.Time = tm
+	return nil
+}
+
+func (t *timeArg) String() string {
+	return "time"
+}
+
+type mediaArgs struct {
+	types []twitter.MediaType
+}
+
+func (m *mediaArgs) Set(str string) error {
+	for _, s := range strings.Split(str, ",") {
+		typ, err := twitter.ParseMediaType(s)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(m.types, typ) {
+			m.types = append(m.types, typ)
+		}
+	}
+	return nil
+}
+
+func (m *mediaArgs) String() string {
+	return "photo,video,gif"
+}
+
+type repostArg struct {
+	policy downloading.RepostPolicy
+}
+
+func (r *repostArg) Set(str string) error {
+	policy, err := downloading.ParseRepostPolicy(str)
+	if err != nil {
+		return err
+	}
+	r.policy = policy
+	return nil
+}
+
+func (r *repostArg) String() string {
+	return "policy"
+}
+
 type Task struct {
 	users []*twitter.User
 	lists []twitter.ListBase
+	likes []*twitter.User
+	// 书签的所有者，即登录的账号，为空则不下载书签
+	bookmarks *twitter.User
+	tweets    []*twitter.Tweet
 }
 
 func printTask(task *Task) {
@@ -139,12 +242,32 @@ func printTask(task *Task) {
 	for _, l := range task.lists {
 		fmt.Printf("    - %s\n", l.Title())
 	}
+	if len(task.likes) != 0 {
+		fmt.Printf("likes: %d\n", len(task.likes))
+	}
+	for _, u := range task.likes {
+		fmt.Printf("    - %s\n", u.Title())
+	}
+	if task.bookmarks != nil {
+		fmt.Printf("bookmarks: %s\n", task.bookmarks.Title())
+	}
+	if len(task.tweets) != 0 {
+		fmt.Printf("tweets: %d\n", len(task.tweets))
+	}
+	for _, tw := range task.tweets {
+		if tw.Creator != nil {
+			fmt.Printf("    - %d %s\n", tw.Id, tw.Creator.Title())
+		} else {
+			fmt.Printf("    - %d\n", tw.Id)
+		}
+	}
 }
 
-func MakeTask(ctx context.Context, client *resty.Client, usrArgs userArgs, listArgs ListArgs, follArgs userArgs) (*Task, error) {
+func MakeTask(ctx context.Context, client *resty.Client, usrArgs userArgs, listArgs ListArgs, follArgs userArgs, likesArgs userArgs, bookmarks bool, twArgs tweetArgs) (*Task, error) {
 	task := Task{}
 	task.users = make([]*twitter.User, 0)
 	task.lists = make([]twitter.ListBase, 0)
+	task.likes = make([]*twitter.User, 0)
 
 	users, err := usrArgs.GetUser(ctx, client)
 	if err != nil {
@@ -168,25 +291,52 @@ func MakeTask(ctx context.Context, client *resty.Client, usrArgs userArgs, listA
 	for _, user := range users {
 		task.lists = append(task.lists, user.Following())
 	}
+
+	// likes
+	users, err = likesArgs.GetUser(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	task.likes = append(task.likes, users...)
+
+	// bookmarks
+	if bookmarks {
+		self, err := twitter.GetUserByScreenName(ctx, client, twitter.GetClientScreenName(client))
+		if err != nil {
+			return nil, err
+		}
+		task.bookmarks = self
+	}
+
+	// tweets
+	tweets, err := twArgs.GetTweet(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	task.tweets = tweets
 	return &task, nil
 }
 
 type storePath struct {
-	root   string
-	users  string
-	data   string
-	db     string
-	errorj string
+	root        string
+	users       string
+	likes       string
+	data        string
+	db          string
+	errorj      string
+	badPayloads string
 }
 
 func newStorePath(root string) (*storePath, error) {
 	ph := storePath{}
 	ph.root = root
 	ph.users = filepath.Join(root, "users")
+	ph.likes = filepath.Join(root, "likes")
 	ph.data = filepath.Join(root, ".data")
 
 	ph.db = filepath.Join(ph.data, "foo.db")
 	ph.errorj = filepath.Join(ph.data, "errors.json")
+	ph.badPayloads = filepath.Join(ph.data, "bad_payloads")
 
 	// ensure folder exist
 	err := os.Mkdir(ph.root, 0755)
@@ -221,30 +371,7 @@ func initLogger(dbg bool, logFile io.Writer) {
 	log.AddHook(lfshook.NewHook(logFile, nil))
 }
 
-func main() {
-	//flags
-	var usrArgs userArgs
-	var listArgs ListArgs
-	var follArgs userArgs
-	var confArg bool
-	var dbg bool
-	var autoFollow bool
-	var noRetry bool
-
-	flag.BoolVar(&confArg, "conf", false, "reconfigure")
-	flag.Var(&usrArgs, "user", "download tweets from the user specified by user_id/screen_name since the last download")
-	flag.Var(&listArgs, "list", "batch download each member from list specified by list_id")
-	flag.Var(&follArgs, "foll", "batch download each member followed by the user specified by user_id/screen_name")
-	flag.BoolVar(&dbg, "dbg", false, "display debug message")
-	flag.BoolVar(&autoFollow, "auto-follow", false, "send follow request automatically to protected users")
-	flag.BoolVar(&noRetry, "no-retry", false, "quickly exit without retrying failed tweets")
-	flag.Parse()
-
-	var err error
-
-	// context
-	ctx, cancel := context.WithCancel(context.Background())
-
+func getAppRootPath() string {
 	var homepath string
 	if runtime.GOOS == "windows" {
 		homepath = os.Getenv("appdata")
@@ -254,8 +381,80 @@ func main() {
 	if homepath == "" {
 		panic("failed to get home path from env")
 	}
+	return filepath.Join(homepath, ".tmd2")
+}
 
-	appRootPath := filepath.Join(homepath, ".tmd2")
+func main() {
+	if len(os.Args) > 1 {
+		if cmd := findSubcommand(os.Args[1]); cmd != nil {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
+	}
+
+	//flags
+	var args runArgs
+	var since timeArg
+	var until timeArg
+	var retweets = repostArg{downloading.RP_SELF}
+	var quotes = repostArg{downloading.RP_SKIP}
+
+	flag.BoolVar(&args.conf, "conf", false, "reconfigure")
+	flag.Var(&args.usrArgs, "user", "download tweets from the user specified by user_id/screen_name since the last download")
+	flag.Var(&args.listArgs, "list", "batch download each member from list specified by list_id")
+	flag.Var(&args.follArgs, "foll", "batch download each member followed by the user specified by user_id/screen_name")
+	flag.Var(&args.likesArgs, "likes", "download tweets liked by the user specified by user_id/screen_name since the last download")
+	flag.BoolVar(&args.likesFolder, "likes-folder", false, "save liked tweets into a folder named after the liker instead of the creators' folders")
+	flag.BoolVar(&args.bookmarks, "bookmarks", false, "download tweets bookmarked by the signed-in account since the last download")
+	flag.Var(&args.twArgs, "tweet", "download the tweet specified by tweet_id/url")
+	flag.BoolVar(&args.dbg, "dbg", false, "display debug message")
+	flag.BoolVar(&args.opts.AutoFollow, "auto-follow", false, "send follow request automatically to protected users")
+	flag.BoolVar(&args.opts.Backfill, "backfill", false, "walk the entire media timeline of each user again and download media that do not exist locally")
+	flag.Var(&since, "since", "only download tweets of users/lists/followings created after the time (RFC3339, 2006-01-02 or relative like 30d, 12h)")
+	flag.Var(&until, "until", "only download tweets of users/lists/followings created before the time (RFC3339, 2006-01-02 or relative like 30d, 12h)")
+	flag.Var(&args.media, "media", "only download media of the given types, comma separated (photo, video, gif, space)")
+	flag.Var(&retweets, "retweets", "how to handle retweets of users/lists/followings: skip, retweeter (default) or author")
+	flag.Var(&quotes, "quotes", "how to handle tweets quoted by users/lists/followings: skip (default), quoter or author")
+	flag.BoolVar(&args.noRetry, "no-retry", false, "quickly exit without retrying failed tweets")
+	flag.Parse()
+
+	args.opts.Since = since.Time
+	args.opts.Until = until.Time
+	args.opts.Retweets = retweets.policy
+	args.opts.Quotes = quotes.policy
+	execute(&args)
+}
+
+// 一次运行的参数，来自命令行或任务文件
+type runArgs struct {
+	usrArgs     userArgs
+	listArgs    ListArgs
+	follArgs    userArgs
+	likesArgs   userArgs
+	twArgs      tweetArgs
+	bookmarks   bool
+	likesFolder bool
+	conf        bool
+	dbg         bool
+	noRetry     bool
+	media       mediaArgs
+	opts        downloading.BatchOptions
+
+	// 本次同步的全部目标的存储根目录，为空使用配置中的存储目录。数据库仍位于配置的存储目录下
+	root string
+}
+
+func execute(args *runArgs) {
+	var err error
+	dbg := args.dbg
+
+	// context
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	appRootPath := getAppRootPath()
 	confPath := filepath.Join(appRootPath, "conf.yaml")
 	cliLogPath := filepath.Join(appRootPath, "client.log")
 	logPath := filepath.Join(appRootPath, "tmd2.log")
@@ -281,7 +480,7 @@ func main() {
 
 	// read/write config
 	conf, err := readConf(confPath)
-	if os.IsNotExist(err) || confArg {
+	if os.IsNotExist(err) || args.conf {
 		conf, err = promptConfig(confPath)
 		if err != nil {
 			log.Fatalln("config failure with", err)
@@ -290,49 +489,37 @@ func main() {
 	if err != nil {
 		log.Fatalln("failed to load config:", err)
 	}
-	if confArg {
+	if args.conf {
 		log.Println("config done")
 		return
 	}
 	log.Infoln("config is loaded")
-	if conf.MaxDownloadRoutine > 0 {
-		downloading.MaxDownloadRoutine = conf.MaxDownloadRoutine
+	if err = applyConf(conf); err != nil {
+		log.Fatalln(err)
 	}
+	if args.root != "" {
+		if err = prependStoreRoot(args.root); err != nil {
+			log.Fatalln("failed to make store dir:", err)
+		}
+	}
+	args.opts.Media.Types = args.media.types
 
 	// ensure store path exist
 	pathHelper, err := newStorePath(conf.RootPath)
 	if err != nil {
 		log.Fatalln("failed to make store dir:", err)
 	}
+	twitter.BadPayloadDir = pathHelper.badPayloads
 
 	// sign in
-	client, screenName, err := twitter.Login(ctx, conf.Cookie.AuthCoken, conf.Cookie.Ct0)
-	if err != nil {
-		log.Fatalln("failed to login:", err)
-	}
-	twitter.EnableRateLimit(client)
-	if dbg {
-		twitter.EnableRequestCounting(client)
-	}
-	log.Infoln("signed in as:", color.FgLightBlue.Render(screenName))
-
-	// load additional cookies
-	cookies, err := readAdditionalCookies(additionalCookiesPath)
-	if err != nil {
-		log.Warnln("failed to load additional cookies:", err)
-	}
-	log.Debugln("loaded additional cookies:", len(cookies))
-	addtional := batchLogin(ctx, dbg, cookies, screenName)
-
-	// set clients logger
 	cliLogFile, err := os.OpenFile(cliLogPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		log.Fatalln("failed to create log file:", err)
 	}
 	defer cliLogFile.Close()
-	setClientLogger(client, cliLogFile)
-	for _, cli := range addtional {
-		setClientLogger(cli, cliLogFile)
+	client, addtional, err := signIn(ctx, conf, additionalCookiesPath, dbg, cliLogFile)
+	if err != nil {
+		log.Fatalln("failed to login:", err)
 	}
 
 	// load previous tweets
@@ -344,7 +531,7 @@ func main() {
 	log.Infoln("loaded previous failed tweets:", dumper.Count())
 
 	// collect tasks
-	task, err := MakeTask(ctx, client, usrArgs, listArgs, follArgs)
+	task, err := MakeTask(ctx, client, args.usrArgs, args.listArgs, args.follArgs, args.likesArgs, args.bookmarks, args.twArgs)
 	if err != nil {
 		log.Fatalln("failed to parse cmd args:", err)
 	}
@@ -375,6 +562,8 @@ func main() {
 	defer func() {
 		dumper.Dump(pathHelper.errorj)
 		log.Infof("%d tweets have been dumped and will be downloaded the next time the program runs", dumper.Count())
+		reportParseErrors(pathHelper.badPayloads)
+		reportRefetchStats()
 	}()
 
 	// retry failed tweets at exit
@@ -383,22 +572,210 @@ func main() {
 			dumper.Push(te.Entity.Id(), te.Tweet)
 		}
 		// 如果手动取消，不尝试重试，快速终止进程
-		if ctx.Err() != context.Canceled && !noRetry {
-			retryFailedTweets(ctx, dumper, db, client)
+		if ctx.Err() != context.Canceled && !args.noRetry {
+			retryFailedTweets(ctx, dumper, db, client, &args.opts.Media)
 		}
 	}()
 
 	// do job
-	if len(task.users) == 0 && len(task.lists) == 0 {
+	if len(task.users) == 0 && len(task.lists) == 0 && len(task.likes) == 0 && task.bookmarks == nil && len(task.tweets) == 0 {
 		return
 	}
 	log.Infoln("start working for...")
 	printTask(task)
 
-	todump, err = downloading.BatchDownloadAny(ctx, client, db, task.lists, task.users, pathHelper.root, pathHelper.users, autoFollow, addtional)
+	todump, err = downloading.BatchDownloadAny(ctx, client, db, task.lists, task.users, pathHelper.root, pathHelper.users, &args.opts, addtional)
 	if err != nil {
 		log.Errorln("failed to download:", err)
 	}
+
+	likesDir := ""
+	if args.likesFolder {
+		likesDir = pathHelper.likes
+	}
+	for _, liker := range task.likes {
+		fails, err := downloading.DownloadLikes(ctx, client, db, liker, pathHelper.users, likesDir, &args.opts.Media)
+		if err != nil {
+			log.WithField("user", liker.Title()).Errorln("failed to download likes:", err)
+		}
+		todump = append(todump, fails...)
+	}
+
+	if task.bookmarks != nil {
+		fails, err := downloading.DownloadBookmarks(ctx, client, db, task.bookmarks, pathHelper.users, &args.opts.Media)
+		if err != nil {
+			log.Errorln("failed to download bookmarks:", err)
+		}
+		todump = append(todump, fails...)
+	}
+
+	if len(task.tweets) != 0 {
+		fails := downloading.DownloadTweets(ctx, client, db, task.tweets, pathHelper.users, &args.opts.Media)
+		todump = append(todump, fails...)
+	}
+}
+
+// 根据配置设置下载参数
+func applyConf(conf *Config) error {
+	var err error
+	if conf.MaxDownloadRoutine > 0 {
+		downloading.MaxDownloadRoutine = conf.MaxDownloadRoutine
+	}
+	downloading.VideoQuality, err = twitter.ParseVideoQuality(conf.VideoQuality)
+	if err != nil {
+		return fmt.Errorf("failed to parse video quality: %v", err)
+	}
+	downloading.MediaNameTemplate, err = downloading.ParseMediaNameTemplate(conf.FilenameTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse filename template: %v", err)
+	}
+	downloading.UserDirTemplate, err = downloading.ParseUserDirTemplate(conf.UserDirTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse user dir template: %v", err)
+	}
+	downloading.ListDirTemplate, err = downloading.ParseListDirTemplate(conf.ListDirTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse list dir template: %v", err)
+	}
+	downloading.Dedupe, err = downloading.ParseDedupeMode(conf.Dedupe)
+	if err != nil {
+		return fmt.Errorf("failed to parse dedupe mode: %v", err)
+	}
+	downloading.Sidecar = conf.Sidecar
+	downloading.EmbedMetadata = conf.EmbedMetadata
+
+	downloading.StoreRoots = nil
+	for _, root := range conf.Roots {
+		if root.Path == "" {
+			return fmt.Errorf("path of storage root is empty")
+		}
+		path, err := filepath.Abs(root.Path)
+		if err != nil {
+			return err
+		}
+		sr := &downloading.StoreRoot{Path: path, Lists: root.Lists, Users: root.Users}
+		if err := os.MkdirAll(sr.UsersDir(), 0755); err != nil {
+			return err
+		}
+		downloading.StoreRoots = append(downloading.StoreRoots, sr)
+	}
+	return nil
+}
+
+// 添加匹配全部目标的存储根目录，优先于配置中的存储根目录
+func prependStoreRoot(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	sr := &downloading.StoreRoot{Path: path, All: true}
+	if err := os.MkdirAll(sr.UsersDir(), 0755); err != nil {
+		return err
+	}
+	downloading.StoreRoots = append([]*downloading.StoreRoot{sr}, downloading.StoreRoots...)
+	return nil
+}
+
+// 存储目录和全部额外的存储根目录
+func archiveRoots(conf *Config) ([]string, error) {
+	roots := []string{conf.RootPath}
+	for _, root := range conf.Roots {
+		path, err := filepath.Abs(root.Path)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, path)
+	}
+	return roots, nil
+}
+
+// 登录主账号和 additionalCookiesPath 中的附加账号，启用速率限制，客户端的日志写入 cliLog
+func signIn(ctx context.Context, conf *Config, additionalCookiesPath string, dbg bool, cliLog io.Writer) (*resty.Client, []*resty.Client, error) {
+	if dir := os.Getenv("TMD_CASSETTE"); dir != "" {
+		mode, err := cassette.ParseMode(os.Getenv("TMD_CASSETTE_MODE"))
+		if err != nil {
+			return nil, nil, err
+		}
+		if twitter.Cassette, err = cassette.New(dir, mode); err != nil {
+			return nil, nil, err
+		}
+		log.Warnln("requests are recorded or replayed with cassette:", dir)
+	}
+	client, screenName, err := twitter.Login(ctx, conf.Cookie.AuthCoken, conf.Cookie.Ct0)
+	if err != nil {
+		return nil, nil, err
+	}
+	loadGraphqlOperations(ctx, client, filepath.Join(getAppRootPath(), "graphql.json"))
+	twitter.EnableRateLimit(client)
+	if dbg {
+		twitter.EnableRequestCounting(client)
+	}
+	log.Infoln("signed in as:", color.FgLightBlue.Render(screenName))
+
+	// load additional cookies
+	cookies, err := readAdditionalCookies(additionalCookiesPath)
+	if err != nil {
+		log.Warnln("failed to load additional cookies:", err)
+	}
+	log.Debugln("loaded additional cookies:", len(cookies))
+	addtional := batchLogin(ctx, dbg, cookies, screenName)
+
+	setClientLogger(client, cliLog)
+	for _, cli := range addtional {
+		setClientLogger(cli, cliLog)
+	}
+	return client, addtional, nil
+}
+
+// 汇总本次运行中重新获取的缺少 legacy 的推文
+func reportRefetchStats() {
+	if recovered, lost := twitter.RefetchStats(); recovered+lost != 0 {
+		log.Infof("%d tweets without legacy were refetched, %d of them could not be recovered", recovered+lost, lost)
+	}
+}
+
+// 汇总本次运行中因响应无法解析而被跳过的用户和推文
+func reportParseErrors(dir string) {
+	errs := twitter.ParseErrors()
+	if len(errs) == 0 {
+		return
+	}
+	log.Warnf("%d responses could not be parsed, the raw payloads are saved to %s", len(errs), dir)
+	for _, err := range errs {
+		subject := err.Subject
+		if subject == "" {
+			subject = "unknown"
+		}
+		log.WithFields(log.Fields{"skipped": subject, "payload": err.Path}).Warnln(err)
+	}
+}
+
+// 发现的 GraphQL 操作的缓存有效期
+const graphqlCacheTTL = 24 * time.Hour
+
+// 加载缓存的 GraphQL 操作，缓存不存在或过期时从网页客户端重新发现。发现失败时使用过期的缓存，
+// 没有缓存时使用硬编码的 query id 和功能开关
+func loadGraphqlOperations(ctx context.Context, client *resty.Client, path string) {
+	cached, err := twitter.LoadDiscovered(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Warnln("failed to load graphql operations:", err)
+	}
+	if cached != nil && time.Since(cached.UpdatedAt) < graphqlCacheTTL {
+		twitter.SetDiscovered(cached)
+		return
+	}
+
+	discovered, err := twitter.Discover(ctx, client)
+	if err != nil {
+		log.Warnln("failed to discover graphql operations:", err)
+		twitter.SetDiscovered(cached)
+		return
+	}
+	log.Debugln("discovered graphql operations:", len(discovered.Operations))
+	twitter.SetDiscovered(discovered)
+	if err := discovered.Save(path); err != nil {
+		log.Warnln("failed to save graphql operations:", err)
+	}
 }
 
 func setClientLogger(client *resty.Client, out io.Writer) {
@@ -503,7 +880,7 @@ func promptConfig(saveto string) (*Config, error) {
 	return &conf, writeConf(saveto, &conf)
 }
 
-func retryFailedTweets(ctx context.Context, dumper *downloading.TweetDumper, db *sqlx.DB, client *resty.Client) error {
+func retryFailedTweets(ctx context.Context, dumper *downloading.TweetDumper, db *sqlx.DB, client *resty.Client, opts *downloading.MediaOptions) error {
 	if dumper.Count() == 0 {
 		return nil
 	}
@@ -519,7 +896,7 @@ func retryFailedTweets(ctx context.Context, dumper *downloading.TweetDumper, db
 		toretry = append(toretry, leg)
 	}
 
-	newFails := downloading.BatchDownloadTweet(ctx, client, toretry...)
+	newFails := downloading.BatchDownloadTweet(ctx, client, db, opts, toretry...)
 	dumper.Clear()
 	for _, pt := range newFails {
 		te := pt.(*downloading.TweetInEntity)